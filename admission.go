@@ -0,0 +1,70 @@
+package tracker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// AdmissionController decides whether the server side takes on a new
+// handshake, to shed the tracking of new connections under overload. A
+// rejected client is told to try again after backoff, the connection stays
+// usable, without request headers.
+type AdmissionController interface {
+	Admit(appID string) (admitted bool, backoff time.Duration)
+}
+
+// AdmissionFunc adapts a function to an AdmissionController.
+type AdmissionFunc func(appID string) (admitted bool, backoff time.Duration)
+
+func (f AdmissionFunc) Admit(appID string) (bool, time.Duration) {
+	return f(appID)
+}
+
+// HandshakeRejectedError is returned by Negotiation when the server rejected
+// the handshake, it may be negotiated again after Backoff. It is
+// ErrNegotiationFailed, of the INTERNAL_ERROR type it was sent with.
+type HandshakeRejectedError struct {
+	Backoff time.Duration
+}
+
+func (e *HandshakeRejectedError) Error() string {
+	return fmt.Sprintf("%s %v", handshakeRejectedPrefix, e.Backoff)
+}
+
+func (e *HandshakeRejectedError) Is(target error) bool {
+	return target == ErrNegotiationFailed
+}
+
+func (e *HandshakeRejectedError) TypeId() int32 {
+	return thrift.INTERNAL_ERROR
+}
+
+// handshakeRejectedPrefix starts the message of the exception a rejection is
+// sent with, followed by the backoff.
+const handshakeRejectedPrefix = "tracker handshake rejected, retry after"
+
+func newHandshakeRejectedException(backoff time.Duration) thrift.TApplicationException {
+	return thrift.NewTApplicationException(thrift.INTERNAL_ERROR,
+		(&HandshakeRejectedError{Backoff: backoff}).Error())
+}
+
+// asHandshakeRejected returns the HandshakeRejectedError err carries if it is
+// the exception of a rejection, err otherwise.
+func asHandshakeRejected(err error) error {
+	nerr, ok := err.(*NegotiationError)
+	if !ok || nerr.Reason != ReasonPeerException {
+		return err
+	}
+	x, ok := nerr.Err.(thrift.TApplicationException)
+	if !ok || x.TypeId() != thrift.INTERNAL_ERROR || !strings.HasPrefix(x.Error(), handshakeRejectedPrefix) {
+		return err
+	}
+	backoff, perr := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(x.Error(), handshakeRejectedPrefix)))
+	if perr != nil {
+		return err
+	}
+	return &HandshakeRejectedError{Backoff: backoff}
+}
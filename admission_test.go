@@ -0,0 +1,62 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func TestAdmissionControllerAdmits(t *testing.T) {
+	var seen string
+	admit := AdmissionFunc(func(appID string) (bool, time.Duration) {
+		seen = appID
+		return true, 0
+	})
+	client, server := upgradedPair(t, nil, []Option{WithAdmissionController(admit)})
+	if seen != "client" {
+		t.Fatalf("expect the controller to be consulted with the app ID, got %q", seen)
+	}
+	if !client.RequestHeaderSupported() || !server.RequestHeaderSupported() {
+		t.Fatal("expect an admitted handshake to upgrade")
+	}
+}
+
+func TestAdmissionControllerRejects(t *testing.T) {
+	admitted := false
+	server := NewSimpleTracker("server", WithAdmissionController(AdmissionFunc(func(string) (bool, time.Duration) {
+		return admitted, 150 * time.Millisecond
+	})))
+	client := NewSimpleTracker("client")
+	cprot, sprot := newProtocolPair(t)
+
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+	err := client.Negotiation(1, cprot, cprot)
+	rejected, ok := err.(*HandshakeRejectedError)
+	if !ok || rejected.Backoff != 150*time.Millisecond {
+		t.Fatalf("expect a rejection with a backoff of 150ms, got %#v", err)
+	}
+	if !errors.Is(err, ErrNegotiationFailed) || rejected.TypeId() != thrift.INTERNAL_ERROR {
+		t.Fatalf("expect an INTERNAL_ERROR negotiation failure, got %#v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("expect the server to keep the connection, got %v", err)
+	}
+	if client.RequestHeaderSupported() || server.RequestHeaderSupported() {
+		t.Fatal("expect a rejected handshake not to upgrade")
+	}
+
+	// The same connection negotiates again once admitted.
+	admitted = true
+	go func() { done <- serveUpgrade(server, sprot) }()
+	if err := client.Negotiation(2, cprot, cprot); err != nil {
+		t.Fatalf("expect the retry to succeed, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	passRequestHeader(t, context.Background(), client, server)
+}
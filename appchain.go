@@ -0,0 +1,68 @@
+package tracker
+
+import (
+	"context"
+	"strings"
+)
+
+// MetaKeyAppChain is the reserved meta key carrying the app IDs of the
+// services a request went through, comma separated, oldest first: every
+// tracker appends its own app ID to the chain it inherited on every call it
+// makes, enough to draw the dependency graph of the services from the headers.
+const MetaKeyAppChain = "app_chain"
+
+// MaxAppChainLength is the maximum number of app IDs in a chain, the oldest
+// ones are dropped first.
+const MaxAppChainLength = 16
+
+const (
+	ctxKeyAppChain   ctxKey = "__thrift_tracking_app_chain"
+	ctxKeyLocalAppID ctxKey = "__thrift_tracking_local_app_id"
+)
+
+// AppChainFromContext returns the app IDs of the services the current
+// request went through, oldest first, the last one is the immediate caller.
+// It is empty at the edge.
+func AppChainFromContext(ctx context.Context) []string {
+	chain, _ := ctx.Value(ctxKeyAppChain).([]string)
+	return append([]string(nil), chain...)
+}
+
+func extractAppChain(ctx context.Context, meta map[string]string) (context.Context, error) {
+	var chain []string
+	for _, id := range strings.Split(meta[MetaKeyAppChain], ",") {
+		if id != "" {
+			chain = append(chain, id)
+		}
+	}
+	if len(chain) == 0 {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, ctxKeyAppChain, capAppChain(chain)), nil
+}
+
+// injectAppChain appends the app ID of the writing tracker to the inherited
+// chain, unless it is the last one already: a service calling itself, or
+// going through several of its own hops, counts once.
+func injectAppChain(ctx context.Context, meta map[string]string) error {
+	chain, _ := ctx.Value(ctxKeyAppChain).([]string)
+	if id, _ := ctx.Value(ctxKeyLocalAppID).(string); id != "" {
+		id = strings.Replace(id, ",", "_", -1)
+		if len(chain) == 0 || chain[len(chain)-1] != id {
+			chain = capAppChain(append(chain[:len(chain):len(chain)], id))
+		}
+	}
+	if len(chain) == 0 {
+		delete(meta, MetaKeyAppChain)
+		return nil
+	}
+	meta[MetaKeyAppChain] = strings.Join(chain, ",")
+	return nil
+}
+
+func capAppChain(chain []string) []string {
+	if len(chain) > MaxAppChainLength {
+		return chain[len(chain)-MaxAppChainLength:]
+	}
+	return chain
+}
@@ -0,0 +1,51 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestAppChain(t *testing.T) {
+	ctx := context.Background()
+	var want []string
+	for _, app := range []string{"gateway", "orders", "orders", "stock"} {
+		client, server := NewSimpleTracker(app), NewSimpleTracker("next")
+		handshake(t, client, server)
+		ctx = passRequestHeader(t, ctx, client, server)
+		if len(want) == 0 || want[len(want)-1] != app {
+			want = append(want, app)
+		}
+		if chain := AppChainFromContext(ctx); !reflect.DeepEqual(chain, want) {
+			t.Fatalf("expect the chain %v, got %v", want, chain)
+		}
+	}
+	if chain := AppChainFromContext(context.Background()); len(chain) != 0 {
+		t.Fatalf("expect no chain at the edge, got %v", chain)
+	}
+}
+
+func TestAppChainCapped(t *testing.T) {
+	ctx := context.Background()
+	var last string
+	for i := 0; i < MaxAppChainLength+4; i++ {
+		last = fmt.Sprintf("app-%d", i)
+		client, server := NewSimpleTracker(last), NewSimpleTracker("next")
+		handshake(t, client, server)
+		ctx = passRequestHeader(t, ctx, client, server)
+	}
+	chain := AppChainFromContext(ctx)
+	if len(chain) != MaxAppChainLength || chain[0] != "app-4" || chain[len(chain)-1] != last {
+		t.Fatalf("expect the last %d apps, got %v", MaxAppChainLength, chain)
+	}
+}
+
+func TestAppChainForged(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{MetaKeyAppChain: "forged"})
+	ctx = passRequestHeader(t, ctx, client, server)
+	if chain := AppChainFromContext(ctx); !reflect.DeepEqual(chain, []string{"client"}) {
+		t.Fatalf("expect the chain set by the tracker only, got %v", chain)
+	}
+}
@@ -0,0 +1,208 @@
+package tracker
+
+import (
+	"context"
+	"strings"
+)
+
+// The meta keys of the Zipkin B3 propagation, the single "b3" one and the
+// multiple ones, the names of the HTTP headers in lower case. They are read
+// regardless of case.
+const (
+	MetaKeyB3             = "b3"
+	MetaKeyB3TraceID      = "x-b3-traceid"
+	MetaKeyB3SpanID       = "x-b3-spanid"
+	MetaKeyB3ParentSpanID = "x-b3-parentspanid"
+	MetaKeyB3Sampled      = "x-b3-sampled"
+	MetaKeyB3Flags        = "x-b3-flags"
+)
+
+var b3MetaKeys = []string{
+	MetaKeyB3, MetaKeyB3TraceID, MetaKeyB3SpanID, MetaKeyB3ParentSpanID, MetaKeyB3Sampled, MetaKeyB3Flags,
+}
+
+// B3SpanContext is the Zipkin span a call belongs to, in lower hex: TraceID
+// is 16 or 32 digits long, SpanID and ParentSpanID 16. Sampled is nil if the
+// sampling decision is deferred to the receiver, Debug implies sampled.
+type B3SpanContext struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Sampled      *bool
+	Debug        bool
+}
+
+const ctxKeyB3 ctxKey = "__thrift_tracking_b3"
+
+// WithB3 returns a context that propagates sc with the requests made with it,
+// for the trackers with a B3Propagator: the tracer sets the span of every
+// call.
+func WithB3(ctx context.Context, sc B3SpanContext) context.Context {
+	return context.WithValue(ctx, ctxKeyB3, sc)
+}
+
+// B3FromContext returns the B3 span context of the current request, ok is
+// false if none was received nor set by WithB3.
+func B3FromContext(ctx context.Context) (sc B3SpanContext, ok bool) {
+	sc, ok = ctx.Value(ctxKeyB3).(B3SpanContext)
+	return
+}
+
+// B3Propagator is the Propagator of Zipkin B3, for the thrift calls to join
+// the traces of an existing Zipkin deployment. It reads both formats, the
+// single header winning over the multiple ones, and writes the one chosen by
+// SingleHeader. The outgoing keys of either format are replaced, the incoming
+// ones are not passed on as is.
+type B3Propagator struct {
+	// SingleHeader writes the "b3" key, "{trace}-{span}-{sampling}-{parent}",
+	// rather than the multiple ones.
+	SingleHeader bool
+}
+
+func (p B3Propagator) Inject(ctx context.Context, meta map[string]string) {
+	for _, k := range b3MetaKeys {
+		deleteMetaFold(meta, k)
+	}
+	sc, ok := B3FromContext(ctx)
+	if !ok {
+		return
+	}
+	if p.SingleHeader {
+		if v := sc.single(); v != "" {
+			meta[MetaKeyB3] = v
+		}
+		return
+	}
+	if sc.TraceID != "" && sc.SpanID != "" {
+		meta[MetaKeyB3TraceID] = sc.TraceID
+		meta[MetaKeyB3SpanID] = sc.SpanID
+		if sc.ParentSpanID != "" {
+			meta[MetaKeyB3ParentSpanID] = sc.ParentSpanID
+		}
+	}
+	switch {
+	case sc.Debug:
+		meta[MetaKeyB3Flags] = "1"
+	case sc.Sampled != nil && *sc.Sampled:
+		meta[MetaKeyB3Sampled] = "1"
+	case sc.Sampled != nil:
+		meta[MetaKeyB3Sampled] = "0"
+	}
+}
+
+// single returns sc in the single header format, empty if sc has nothing to
+// propagate.
+func (sc B3SpanContext) single() string {
+	var sampling string
+	switch {
+	case sc.Debug:
+		sampling = "d"
+	case sc.Sampled != nil && *sc.Sampled:
+		sampling = "1"
+	case sc.Sampled != nil:
+		sampling = "0"
+	}
+	if sc.TraceID == "" || sc.SpanID == "" {
+		return sampling // a sampling decision alone, if any
+	}
+	parts := []string{sc.TraceID, sc.SpanID}
+	if sampling != "" {
+		parts = append(parts, sampling)
+		if sc.ParentSpanID != "" { // only allowed after the sampling state
+			parts = append(parts, sc.ParentSpanID)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func (p B3Propagator) Extract(ctx context.Context, meta map[string]string) context.Context {
+	if v, ok := lookupMetaFold(meta, MetaKeyB3); ok {
+		if sc, ok := parseB3Single(v); ok {
+			return WithB3(ctx, sc)
+		}
+		return ctx
+	}
+	var sc B3SpanContext
+	traceID, _ := lookupMetaFold(meta, MetaKeyB3TraceID)
+	spanID, _ := lookupMetaFold(meta, MetaKeyB3SpanID)
+	parentID, _ := lookupMetaFold(meta, MetaKeyB3ParentSpanID)
+	if traceID != "" || spanID != "" {
+		if !isB3TraceID(traceID) || !isB3SpanID(spanID) || (parentID != "" && !isB3SpanID(parentID)) {
+			return ctx
+		}
+		sc.TraceID, sc.SpanID, sc.ParentSpanID = strings.ToLower(traceID), strings.ToLower(spanID), strings.ToLower(parentID)
+	}
+	if flags, _ := lookupMetaFold(meta, MetaKeyB3Flags); flags == "1" {
+		sc.Debug = true
+	} else if sampled, ok := lookupMetaFold(meta, MetaKeyB3Sampled); ok {
+		switch strings.ToLower(sampled) {
+		case "1", "true":
+			sc.Sampled = b3Bool(true)
+		case "0", "false":
+			sc.Sampled = b3Bool(false)
+		default:
+			return ctx
+		}
+	}
+	if sc == (B3SpanContext{}) {
+		return ctx
+	}
+	return WithB3(ctx, sc)
+}
+
+func parseB3Single(v string) (B3SpanContext, bool) {
+	var sc B3SpanContext
+	parts := strings.Split(strings.ToLower(v), "-")
+	if len(parts) == 1 { // a sampling decision alone
+		return sc, parseB3Sampling(&sc, parts[0])
+	}
+	if len(parts) > 4 || !isB3TraceID(parts[0]) || !isB3SpanID(parts[1]) {
+		return sc, false
+	}
+	sc.TraceID, sc.SpanID = parts[0], parts[1]
+	if len(parts) > 2 && !parseB3Sampling(&sc, parts[2]) {
+		return sc, false
+	}
+	if len(parts) > 3 {
+		if !isB3SpanID(parts[3]) {
+			return sc, false
+		}
+		sc.ParentSpanID = parts[3]
+	}
+	return sc, true
+}
+
+func parseB3Sampling(sc *B3SpanContext, s string) bool {
+	switch s {
+	case "d":
+		sc.Debug = true
+	case "1":
+		sc.Sampled = b3Bool(true)
+	case "0":
+		sc.Sampled = b3Bool(false)
+	default:
+		return false
+	}
+	return true
+}
+
+func b3Bool(b bool) *bool {
+	return &b
+}
+
+func isB3TraceID(s string) bool {
+	return (len(s) == 16 || len(s) == 32) && isHex(s)
+}
+
+func isB3SpanID(s string) bool {
+	return len(s) == 16 && isHex(s)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
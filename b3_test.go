@@ -0,0 +1,95 @@
+package tracker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+const (
+	b3Trace  = "463ac35c9f6413ad48485a3953bb6124"
+	b3Span   = "a2fb4a1d1a96d312"
+	b3Parent = "0020000000000001"
+)
+
+func TestB3PropagatorFormats(t *testing.T) {
+	sampled := B3SpanContext{TraceID: b3Trace, SpanID: b3Span, ParentSpanID: b3Parent, Sampled: b3Bool(true)}
+	for _, c := range []struct {
+		name string
+		sc   B3SpanContext
+		meta map[string]string
+	}{
+		{"single", sampled, map[string]string{MetaKeyB3: b3Trace + "-" + b3Span + "-1-" + b3Parent}},
+		{"single debug", B3SpanContext{TraceID: b3Trace, SpanID: b3Span, Debug: true},
+			map[string]string{MetaKeyB3: b3Trace + "-" + b3Span + "-d"}},
+		{"single deny", B3SpanContext{Sampled: b3Bool(false)}, map[string]string{MetaKeyB3: "0"}},
+		{"multi", sampled, map[string]string{
+			MetaKeyB3TraceID: b3Trace, MetaKeyB3SpanID: b3Span, MetaKeyB3ParentSpanID: b3Parent, MetaKeyB3Sampled: "1",
+		}},
+		{"multi debug", B3SpanContext{TraceID: b3Trace, SpanID: b3Span, Debug: true},
+			map[string]string{MetaKeyB3TraceID: b3Trace, MetaKeyB3SpanID: b3Span, MetaKeyB3Flags: "1"}},
+	} {
+		p := B3Propagator{SingleHeader: c.meta[MetaKeyB3] != ""}
+		meta := map[string]string{"k": "v"}
+		p.Inject(WithB3(context.Background(), c.sc), meta)
+		delete(meta, "k")
+		if !reflect.DeepEqual(meta, c.meta) {
+			t.Fatalf("%s: expect %v injected, got %v", c.name, c.meta, meta)
+		}
+		got, ok := B3FromContext(p.Extract(context.Background(), c.meta))
+		if !ok || !reflect.DeepEqual(got, c.sc) {
+			t.Fatalf("%s: expect %+v extracted, got %+v", c.name, c.sc, got)
+		}
+	}
+}
+
+func TestB3PropagatorExtract(t *testing.T) {
+	var p B3Propagator
+	sc, ok := B3FromContext(p.Extract(context.Background(), map[string]string{
+		"X-B3-TraceId": "463AC35C9F6413AD", "X-B3-SpanId": b3Span, "X-B3-Sampled": "true",
+	}))
+	if !ok || sc.TraceID != "463ac35c9f6413ad" || sc.SpanID != b3Span || sc.Sampled == nil || !*sc.Sampled {
+		t.Fatalf("expect the headers read regardless of case, got %+v", sc)
+	}
+	sc, _ = B3FromContext(p.Extract(context.Background(), map[string]string{
+		MetaKeyB3: b3Trace + "-" + b3Span, MetaKeyB3TraceID: "ffffffffffffffff", MetaKeyB3SpanID: "ffffffffffffffff",
+	}))
+	if sc.TraceID != b3Trace {
+		t.Fatalf("expect the single header to win, got %+v", sc)
+	}
+	for _, meta := range []map[string]string{
+		{MetaKeyB3: "nope"},
+		{MetaKeyB3: b3Trace + "-short"},
+		{MetaKeyB3: b3Trace + "-" + b3Span + "-x"},
+		{MetaKeyB3TraceID: b3Trace},
+		{MetaKeyB3TraceID: b3Trace, MetaKeyB3SpanID: b3Span, MetaKeyB3Sampled: "maybe"},
+		{},
+	} {
+		if sc, ok := B3FromContext(p.Extract(context.Background(), meta)); ok {
+			t.Fatalf("expect %v rejected, got %+v", meta, sc)
+		}
+	}
+}
+
+func TestB3PropagatorAcrossHops(t *testing.T) {
+	single := []Option{WithPropagators(B3Propagator{SingleHeader: true})}
+	client, server := upgradedPair(t, single, single)
+	sc := B3SpanContext{TraceID: b3Trace, SpanID: b3Span, Sampled: b3Bool(true)}
+	ctx := passRequestHeader(t, WithB3(context.Background(), sc), client, server)
+	if got, ok := B3FromContext(ctx); !ok || !reflect.DeepEqual(got, sc) {
+		t.Fatalf("expect the span context across the hop, got %+v", got)
+	}
+
+	// The tracer of the server starts a child span, written in the other format.
+	multi := []Option{WithPropagators(B3Propagator{})}
+	next, downstream := upgradedPair(t, multi, multi)
+	child := B3SpanContext{TraceID: b3Trace, SpanID: b3Parent, ParentSpanID: b3Span, Sampled: b3Bool(true)}
+	ctx = passRequestHeader(t, WithB3(ctx, child), next, downstream)
+	if got, _ := B3FromContext(ctx); !reflect.DeepEqual(got, child) {
+		t.Fatalf("expect the child span downstream, got %+v", got)
+	}
+	meta, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+	if _, ok := meta[MetaKeyB3]; ok {
+		t.Fatalf("expect the inherited single header replaced, got %v", meta)
+	}
+}
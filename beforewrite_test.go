@@ -0,0 +1,44 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func TestBeforeWriteRequestHeader(t *testing.T) {
+	enrich := WithBeforeWriteRequestHeader(func(ctx context.Context, header *tracking.RequestHeader) error {
+		if header.Meta == nil {
+			header.Meta = make(map[string]string)
+		}
+		header.Meta["tenant"] = "acme"
+		header.RequestID = "overridden"
+		return nil
+	})
+	for _, opts := range [][]Option{
+		{enrich},
+		{enrich, WithMetaCodec(JSONMetaCodec)},
+		{enrich, WithSampler(NeverSample)},
+	} {
+		client, server := upgradedPair(t, opts, nil)
+		ctx := passRequestHeader(t, WithRequestID(context.Background(), "req"), client, server)
+		meta, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+		if meta["tenant"] != "acme" || ctx.Value(CtxKeyRequestID) != "overridden" {
+			t.Fatalf("expect the header enriched, got %v %v", ctx.Value(CtxKeyRequestID), meta)
+		}
+	}
+}
+
+func TestBeforeWriteRequestHeaderAborts(t *testing.T) {
+	errDenied := errors.New("denied")
+	client, _ := upgradedPair(t, []Option{
+		WithFailOpen(nil),
+		WithBeforeWriteRequestHeader(func(context.Context, *tracking.RequestHeader) error { return errDenied }),
+	}, nil)
+	prot := newMemoryProtocol()
+	if err := client.TryWriteRequestHeader(context.Background(), prot); err != errDenied {
+		t.Fatalf("expect the call aborted, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package tracker
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// BreakerState is the state of a HandshakeBreaker for a server.
+type BreakerState int
+
+const (
+	// BreakerClosed lets the handshakes run, the server is fine.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen skips the handshakes, the connections go on without
+	// tracking until the cooldown is over.
+	BreakerOpen
+	// BreakerHalfOpen lets a single handshake probe the server, the others
+	// are skipped until it completes.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// HandshakeBreaker spares the clients of a server failing every handshake the
+// cost of running it on each new connection: once threshold handshakes in a
+// row have failed, or did not upgrade, the connections to the server are
+// passed through without tracking for cooldown. A single handshake probes the
+// server then, the breaker closes again if it upgrades, opens for another
+// cooldown otherwise. The aborted handshakes do not count.
+//
+// Trackers live as long as a connection, share one HandshakeBreaker among the
+// client trackers of a service with WithHandshakeBreaker. The servers are
+// told apart by the address of the transport, that of TSocket, Key overrides
+// it for other transports, those without one are all keyed "".
+//
+// The breaker tells the time with the Clock of the trackers sharing it, see
+// WithClock, the system clock until one of them has run a handshake.
+type HandshakeBreaker struct {
+	// Key, if set, returns the key of the server trans is connected to.
+	Key func(trans thrift.TTransport) string
+
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	now       func() time.Time
+	servers   map[string]*breakerServer
+}
+
+type breakerServer struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewHandshakeBreaker returns a breaker opening after threshold failed
+// handshakes in a row, threshold is at least 1.
+func NewHandshakeBreaker(threshold int, cooldown time.Duration) *HandshakeBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &HandshakeBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		servers:   make(map[string]*breakerServer),
+	}
+}
+
+func (b *HandshakeBreaker) key(trans thrift.TTransport) string {
+	if b.Key != nil {
+		return b.Key(trans)
+	}
+	if trans, ok := trans.(interface{ Addr() net.Addr }); ok { // TSocket
+		if addr := trans.Addr(); addr != nil {
+			return addr.String()
+		}
+	}
+	return ""
+}
+
+// State returns the state of the breaker for the server keyed key.
+func (b *HandshakeBreaker) State(key string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state(b.servers[key], b.now())
+}
+
+// States returns the state of the breaker for every server it has seen a
+// failed handshake of, for metrics.
+func (b *HandshakeBreaker) States() map[string]BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	states := make(map[string]BreakerState, len(b.servers))
+	for key, s := range b.servers {
+		states[key] = b.state(s, now)
+	}
+	return states
+}
+
+func (b *HandshakeBreaker) state(s *breakerServer, now time.Time) BreakerState {
+	switch {
+	case s == nil || s.failures < b.threshold:
+		return BreakerClosed
+	case s.probing || !now.Before(s.openUntil):
+		return BreakerHalfOpen
+	}
+	return BreakerOpen
+}
+
+// allow tells whether a handshake may run with the server keyed key, taking
+// the probe if the breaker is half open, now is the clock of the tracker.
+func (b *HandshakeBreaker) allow(key string, now func() time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.now = now
+	s := b.servers[key]
+	switch b.state(s, now()) {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		if !s.probing {
+			s.probing = true
+			return true
+		}
+	}
+	return false
+}
+
+// done records the outcome of a handshake allowed to run, unless it was
+// aborted.
+func (b *HandshakeBreaker) done(key string, upgraded, aborted bool, now func() time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.now = now
+	s := b.servers[key]
+	if upgraded {
+		delete(b.servers, key)
+		return
+	}
+	if aborted {
+		if s != nil { // not the fault of the server, let another handshake probe
+			s.probing = false
+		}
+		return
+	}
+	if s == nil {
+		s = &breakerServer{}
+		b.servers[key] = s
+	}
+	s.failures++
+	if s.probing || s.failures >= b.threshold {
+		s.openUntil = now().Add(b.cooldown)
+	}
+	s.probing = false
+}
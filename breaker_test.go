@@ -0,0 +1,104 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func newTestBreaker(threshold int, cooldown time.Duration) *HandshakeBreaker {
+	b := NewHandshakeBreaker(threshold, cooldown)
+	b.Key = func(thrift.TTransport) string { return "server" }
+	return b
+}
+
+// failHandshake negotiates with no server behind, the reply never comes.
+func failHandshake(b *HandshakeBreaker, opts ...Option) (*SimpleTracker, error) {
+	client := NewSimpleTracker("client", append(opts, WithHandshakeBreaker(b))...).(*SimpleTracker)
+	prot := newMemoryProtocol()
+	return client, client.Negotiation(1, prot, prot)
+}
+
+func TestHandshakeBreaker(t *testing.T) {
+	b := newTestBreaker(2, time.Minute)
+	clock := newFakeClock()
+	for i := 0; i < 2; i++ {
+		if _, err := failHandshake(b, WithClock(clock)); err == nil {
+			t.Fatalf("handshake %d: expect an error", i)
+		}
+	}
+	if s := b.State("server"); s != BreakerOpen {
+		t.Fatalf("expect the breaker open, got %v", s)
+	}
+	client, err := failHandshake(b, WithClock(clock))
+	if err != nil || !client.Passthrough() {
+		t.Fatalf("expect the handshake skipped, got %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if s := b.States()["server"]; s != BreakerHalfOpen {
+		t.Fatalf("expect the breaker half open, got %v", s)
+	}
+	if _, err := failHandshake(b, WithClock(clock)); err == nil {
+		t.Fatal("expect the probe to run and fail")
+	}
+	if s := b.State("server"); s != BreakerOpen {
+		t.Fatalf("expect the failed probe to open the breaker again, got %v", s)
+	}
+
+	clock.Advance(time.Minute - time.Nanosecond)
+	if s := b.State("server"); s != BreakerOpen {
+		t.Fatalf("expect the breaker open for the whole cooldown, got %v", s)
+	}
+	clock.Advance(time.Nanosecond)
+	client = NewSimpleTracker("client", WithHandshakeBreaker(b), WithClock(clock)).(*SimpleTracker)
+	handshake(t, client, NewSimpleTracker("server"))
+	if !client.RequestHeaderSupported() {
+		t.Fatal("expect the probe to upgrade")
+	}
+	if s := b.State("server"); s != BreakerClosed || len(b.States()) != 0 {
+		t.Fatalf("expect the breaker closed, got %v %v", s, b.States())
+	}
+}
+
+func TestHandshakeBreakerSingleProbe(t *testing.T) {
+	b := newTestBreaker(1, 0)
+	failHandshake(b)
+	if !b.allow("server", time.Now) {
+		t.Fatal("expect the first handshake to probe")
+	}
+	if client, err := failHandshake(b); err != nil || !client.Passthrough() {
+		t.Fatalf("expect the handshakes skipped while probing, got %v", err)
+	}
+	b.done("server", false, true, time.Now)
+	if !b.allow("server", time.Now) {
+		t.Fatal("expect an aborted probe to let another one run")
+	}
+}
+
+func TestHandshakeBreakerAborted(t *testing.T) {
+	b := newTestBreaker(1, time.Minute)
+	client := NewSimpleTracker("client", WithHandshakeBreaker(b)).(*SimpleTracker)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	prot := newMemoryProtocol()
+	if err := client.NegotiationContext(ctx, 1, prot, prot); err == nil {
+		t.Fatal("expect the negotiation aborted")
+	}
+	if s := b.State("server"); s != BreakerClosed {
+		t.Fatalf("expect aborted handshakes not to count, got %v", s)
+	}
+}
+
+func TestHandshakeBreakerAddress(t *testing.T) {
+	b := NewHandshakeBreaker(1, time.Minute)
+	cprot, _ := newProtocolPair(t)
+	if key := b.key(cprot.Transport()); key != "pipe" {
+		t.Fatalf("expect the address of the socket, got %q", key)
+	}
+	if key := b.key(thrift.NewTMemoryBuffer()); key != "" {
+		t.Fatalf("expect no key without an address, got %q", key)
+	}
+}
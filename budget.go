@@ -0,0 +1,62 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// MetaKeyBudget is the reserved meta key carrying the remaining fan-out
+// budget of a request, every hop costs 1. Once a server reads a request with
+// a budget of 1 (or less), the calls it makes downstream are refused with
+// ErrBudgetExhausted, unless their context gets a new one via WithBudget.
+//
+// The budget is only checked on connections upgraded by the handshake, the
+// request header can not be written otherwise.
+const MetaKeyBudget = "budget"
+
+const ctxKeyBudget ctxKey = "__thrift_tracking_budget"
+
+// ErrBudgetExhausted is returned by TryWriteRequestHeader when the budget of
+// the current request has run out.
+var ErrBudgetExhausted = errors.New("thrift tracker: request budget exhausted")
+
+// WithBudget returns a context that attaches a budget of n hops to the
+// requests made with it.
+func WithBudget(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, ctxKeyBudget, n)
+}
+
+// BudgetFromContext returns the budget left for the downstream calls of the
+// current request, ok is false if there is no budget.
+func BudgetFromContext(ctx context.Context) (n int, ok bool) {
+	n, ok = ctx.Value(ctxKeyBudget).(int)
+	return
+}
+
+func extractBudget(ctx context.Context, meta map[string]string) (context.Context, error) {
+	v, ok := meta[MetaKeyBudget]
+	if !ok {
+		return ctx, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil { // garbage, ignore it
+		return ctx, nil
+	}
+	if n--; n < 0 {
+		n = 0
+	}
+	return context.WithValue(ctx, ctxKeyBudget, n), nil
+}
+
+func injectBudget(ctx context.Context, meta map[string]string) error {
+	n, ok := BudgetFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if n <= 0 {
+		return ErrBudgetExhausted
+	}
+	meta[MetaKeyBudget] = strconv.Itoa(n)
+	return nil
+}
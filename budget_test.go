@@ -0,0 +1,40 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func TestBudgetDecrement(t *testing.T) {
+	ctx := WithBudget(context.Background(), 3)
+	for want := 2; want >= 0; want-- {
+		client, server := upgradedPair(t, nil, nil)
+		ctx = passRequestHeader(t, ctx, client, server)
+		if n, ok := BudgetFromContext(ctx); !ok || n != want {
+			t.Fatalf("expect budget %d, got %d(%v)", want, n, ok)
+		}
+	}
+
+	client, _ := upgradedPair(t, nil, nil)
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := client.TryWriteRequestHeader(ctx, prot); err != ErrBudgetExhausted {
+		t.Fatalf("expect ErrBudgetExhausted, got %v", err)
+	}
+	if err := client.TryWriteRequestHeader(WithBudget(ctx, 1), prot); err != nil {
+		t.Fatalf("expect a new budget to unblock, got %v", err)
+	}
+}
+
+func TestBudgetAbsent(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	sctx := passRequestHeader(t, context.Background(), client, server)
+	if n, ok := BudgetFromContext(sctx); ok {
+		t.Fatalf("expect no budget, got %d", n)
+	}
+	sctx, _ = extractBudget(context.Background(), map[string]string{MetaKeyBudget: "garbage"})
+	if n, ok := BudgetFromContext(sctx); ok {
+		t.Fatalf("expect a garbage budget to be ignored, got %d", n)
+	}
+}
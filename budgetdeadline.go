@@ -0,0 +1,72 @@
+package tracker
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// MetaKeyDeadlineBudget is the reserved meta key carrying, in milliseconds,
+// the time left to the deadline of the context of a call when it was
+// written, see WithDeadlinePropagation. Being relative, it does not depend on
+// the clocks of the hosts agreeing, unlike the total deadline, and it bounds
+// the current attempt only.
+const MetaKeyDeadlineBudget = "deadline_ms"
+
+const (
+	ctxKeyDeadlineBudget    ctxKey = "__thrift_tracking_deadline_budget"
+	ctxKeyPropagateDeadline ctxKey = "__thrift_tracking_propagate_deadline"
+	ctxKeyDeadlineCancel    ctxKey = "__thrift_tracking_deadline_cancel"
+)
+
+// DeadlineBudgetFromContext returns the time the caller had left for the
+// current request when it sent it, ok is false if it sent none.
+func DeadlineBudgetFromContext(ctx context.Context) (budget time.Duration, ok bool) {
+	budget, ok = ctx.Value(ctxKeyDeadlineBudget).(time.Duration)
+	return
+}
+
+// ReleaseDeadline stops the timer of the deadline set on the context of a
+// request by WithDeadlinePropagation, once the request is served:
+// TrackedProcessor calls it. The timer goes away on its own at the deadline
+// otherwise.
+func ReleaseDeadline(ctx context.Context) {
+	if cancel, ok := ctx.Value(ctxKeyDeadlineCancel).(context.CancelFunc); ok {
+		cancel()
+	}
+}
+
+func extractDeadlineBudget(ctx context.Context, meta map[string]string) (context.Context, error) {
+	ms, err := strconv.ParseInt(meta[MetaKeyDeadlineBudget], 10, 64)
+	if err != nil || ms < 0 {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, ctxKeyDeadlineBudget, time.Duration(ms)*time.Millisecond), nil
+}
+
+// injectDeadlineBudget writes the time left to the deadline of ctx, an
+// expired one as 0 for the server to fail fast.
+func injectDeadlineBudget(ctx context.Context, meta map[string]string) error {
+	deadline, ok := ctx.Deadline()
+	if propagate, _ := ctx.Value(ctxKeyPropagateDeadline).(bool); !ok || !propagate {
+		delete(meta, MetaKeyDeadlineBudget)
+		return nil
+	}
+	budget := time.Until(deadline)
+	if budget < 0 {
+		budget = 0
+	}
+	meta[MetaKeyDeadlineBudget] = strconv.FormatInt(int64(budget/time.Millisecond), 10)
+	return nil
+}
+
+// withDeadlineBudget bounds ctx, the context of a request, by the budget
+// sent by the caller: it is done right away for the callers out of time.
+func withDeadlineBudget(ctx context.Context) context.Context {
+	budget, ok := DeadlineBudgetFromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	return context.WithValue(ctx, ctxKeyDeadlineCancel, cancel)
+}
@@ -0,0 +1,71 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeadlinePropagation(t *testing.T) {
+	opts := []Option{WithDeadlinePropagation()}
+	client, server := upgradedPair(t, opts, opts)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	sctx := passRequestHeader(t, ctx, client, server)
+	defer ReleaseDeadline(sctx)
+
+	budget, ok := DeadlineBudgetFromContext(sctx)
+	if !ok || budget <= 59*time.Second || budget > time.Minute {
+		t.Fatalf("expect the budget of a minute, got %v", budget)
+	}
+	deadline, ok := sctx.Deadline()
+	if left := time.Until(deadline); !ok || left <= 59*time.Second || left > time.Minute {
+		t.Fatalf("expect the request bounded by the budget, got %v", left)
+	}
+	if meta, _ := sctx.Value(CtxKeyRequestMeta).(map[string]string); meta[MetaKeyDeadlineBudget] == "" {
+		t.Fatal("expect the budget in the meta")
+	}
+
+	// Downstream, the budget is the one left to the request, not the upstream one.
+	next, downstream := upgradedPair(t, opts, opts)
+	short, cancelShort := context.WithTimeout(sctx, time.Second)
+	defer cancelShort()
+	dctx := passRequestHeader(t, short, next, downstream)
+	if budget, _ := DeadlineBudgetFromContext(dctx); budget > time.Second {
+		t.Fatalf("expect the budget of the downstream call, got %v", budget)
+	}
+	ReleaseDeadline(dctx)
+	if dctx.Err() != context.Canceled {
+		t.Fatalf("expect ReleaseDeadline to release the context, got %v", dctx.Err())
+	}
+}
+
+func TestDeadlinePropagationExpired(t *testing.T) {
+	opts := []Option{WithDeadlinePropagation()}
+	client, server := upgradedPair(t, opts, opts)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	sctx := passRequestHeader(t, ctx, client, server)
+	if budget, ok := DeadlineBudgetFromContext(sctx); !ok || budget != 0 {
+		t.Fatalf("expect an expired budget clamped to 0, got %v", budget)
+	}
+	select {
+	case <-sctx.Done():
+	default:
+		t.Fatal("expect the request to fail fast")
+	}
+}
+
+func TestDeadlinePropagationOptIn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client, server := upgradedPair(t, nil, []Option{WithDeadlinePropagation()})
+	if _, ok := DeadlineBudgetFromContext(passRequestHeader(t, ctx, client, server)); ok {
+		t.Fatal("expect no budget from a client without the option")
+	}
+	client, server = upgradedPair(t, []Option{WithDeadlinePropagation()}, nil)
+	if _, ok := passRequestHeader(t, ctx, client, server).Deadline(); ok {
+		t.Fatal("expect a server without the option not to bound the request")
+	}
+}
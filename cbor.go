@@ -0,0 +1,139 @@
+package tracker
+
+import (
+	"errors"
+	"sort"
+	"unicode/utf8"
+)
+
+// CBOR (RFC 8949) major types used by cborMetaCodec.
+const (
+	cborTextString = 3
+	cborMap        = 5
+)
+
+var errCBORMalformed = errors.New("cbor meta codec: malformed data")
+
+// cborMetaCodec writes the meta as a definite length map of text strings,
+// the keys sorted as the deterministic encoding of RFC 8949 wants. It reads
+// exactly that subset back, anything else is rejected.
+type cborMetaCodec struct{}
+
+func (cborMetaCodec) Name() string {
+	return "cbor"
+}
+
+func (cborMetaCodec) Encode(meta map[string]string) ([]byte, error) {
+	keys := make([]string, 0, len(meta))
+	size := 9
+	for k, v := range meta {
+		keys = append(keys, k)
+		size += 18 + len(k) + len(v)
+	}
+	// Deterministic encoding: shorter keys first, then bytewise.
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	data := appendCBORHead(make([]byte, 0, size), cborMap, uint64(len(meta)))
+	for _, k := range keys {
+		data = appendCBORText(data, k)
+		data = appendCBORText(data, meta[k])
+	}
+	return data, nil
+}
+
+func (cborMetaCodec) Decode(data []byte) (map[string]string, error) {
+	n, data, err := readCBORHead(data, cborMap)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(data)/2) { // every entry takes 2 bytes at least
+		return nil, errCBORMalformed
+	}
+	meta := make(map[string]string, int(n))
+	for i := uint64(0); i < n; i++ {
+		var k, v string
+		if k, data, err = readCBORText(data); err != nil {
+			return nil, err
+		}
+		if v, data, err = readCBORText(data); err != nil {
+			return nil, err
+		}
+		meta[k] = v
+	}
+	if len(data) != 0 {
+		return nil, errCBORMalformed
+	}
+	return meta, nil
+}
+
+func appendCBORHead(data []byte, major byte, n uint64) []byte {
+	major <<= 5
+	var size uint
+	switch {
+	case n < 24:
+		return append(data, major|byte(n))
+	case n <= 0xff:
+		data, size = append(data, major|24), 1
+	case n <= 0xffff:
+		data, size = append(data, major|25), 2
+	case n <= 0xffffffff:
+		data, size = append(data, major|26), 4
+	default:
+		data, size = append(data, major|27), 8
+	}
+	for i := size; i > 0; i-- { // big endian
+		data = append(data, byte(n>>(8*(i-1))))
+	}
+	return data
+}
+
+func appendCBORText(data []byte, s string) []byte {
+	return append(appendCBORHead(data, cborTextString, uint64(len(s))), s...)
+}
+
+func readCBORHead(data []byte, major byte) (uint64, []byte, error) {
+	if len(data) == 0 || data[0]>>5 != major {
+		return 0, nil, errCBORMalformed
+	}
+	info := data[0] & 0x1f
+	data = data[1:]
+	var size int
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default: // indefinite lengths and reserved values
+		return 0, nil, errCBORMalformed
+	}
+	if len(data) < size {
+		return 0, nil, errCBORMalformed
+	}
+	var n uint64
+	for _, b := range data[:size] {
+		n = n<<8 | uint64(b)
+	}
+	return n, data[size:], nil
+}
+
+func readCBORText(data []byte) (string, []byte, error) {
+	n, data, err := readCBORHead(data, cborTextString)
+	if err != nil {
+		return "", nil, err
+	}
+	if n > uint64(len(data)) || !utf8.Valid(data[:n]) {
+		return "", nil, errCBORMalformed
+	}
+	return string(data[:n]), data[n:], nil
+}
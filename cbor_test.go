@@ -0,0 +1,79 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCBORMetaCodecRFCVector(t *testing.T) {
+	// RFC 8949, Appendix A.
+	meta := map[string]string{"a": "A", "b": "B", "c": "C", "d": "D", "e": "E"}
+	want, _ := hex.DecodeString("a56161614161626142616361436164614461656145")
+
+	data, err := CBORMetaCodec.Encode(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("expect %x, got %x", want, data)
+	}
+	got, err := CBORMetaCodec.Decode(want)
+	if err != nil || !reflect.DeepEqual(got, meta) {
+		t.Fatalf("expect %v, got %v, %v", meta, got, err)
+	}
+}
+
+func TestCBORMetaCodecRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 70000) // takes a 4 bytes length
+	metas := []map[string]string{
+		{},
+		{"": ""},
+		{"键": "值", "emoji🙂": "✓"},
+		{strings.Repeat("k", 30): strings.Repeat("v", 300), "long": long},
+	}
+	for _, meta := range metas {
+		data, err := CBORMetaCodec.Encode(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := CBORMetaCodec.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(meta) || (len(meta) > 0 && !reflect.DeepEqual(got, meta)) {
+			t.Fatalf("expect %v, got %v", meta, got)
+		}
+	}
+}
+
+func TestCBORMetaCodecMalformed(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"a1",              // truncated map
+		"a16161",          // missing value
+		"bf61616141ff",    // indefinite length map
+		"a1616141",        // value is a byte string
+		"a1626161",        // truncated key
+		"ba7fffffff",      // huge map
+		"a161616141ff",    // trailing data
+		"a16161" + "61ff", // invalid UTF-8
+	} {
+		raw, _ := hex.DecodeString(data)
+		if _, err := CBORMetaCodec.Decode(raw); err == nil {
+			t.Fatalf("expect %s to be rejected", data)
+		}
+	}
+}
+
+func TestCBORMetaCodecOverTheWire(t *testing.T) {
+	client, server := upgradedPair(t, []Option{WithMetaCodec(CBORMetaCodec)}, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"k": "v"})
+	sctx := passRequestHeader(t, ctx, client, server)
+	if metaFromContext(sctx)["k"] != "v" {
+		t.Fatalf("expect the CBOR meta to be decoded, got %v", metaFromContext(sctx))
+	}
+}
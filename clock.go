@@ -0,0 +1,36 @@
+package tracker
+
+import (
+	"time"
+)
+
+// Clock tells the time to a tracker, replaced to test what depends on it: the
+// handshake RTT, the structured request IDs, the entry timestamp stamped at
+// the edge, the cooldown of its HandshakeBreaker and the traces of a
+// RecordingTracker wrapping it. Timers and I/O deadlines follow the system
+// clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// clocked is a tracker telling the time, with its Clock if any.
+type clocked interface {
+	now() time.Time
+}
+
+func (t *SimpleTracker) now() time.Time {
+	if t.clock == nil {
+		return time.Now()
+	}
+	return t.clock.Now()
+}
+
+// HandshakeRTT returns the round trip time of the last successful
+// Negotiation, from the flush of the upgrade call to the reply read, an
+// estimate of the latency of the connection. It is 0 until then, on the
+// server side, and with WithOnewayHandshake.
+func (t *SimpleTracker) HandshakeRTT() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.handshakeRTT
+}
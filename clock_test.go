@@ -0,0 +1,100 @@
+package tracker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// fakeClock only moves forward when told so.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1500000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestHandshakeRTT(t *testing.T) {
+	clock := newFakeClock()
+	client := NewSimpleTracker("client", WithClock(clock)).(*SimpleTracker)
+	server := NewSimpleTracker("server")
+	if rtt := client.HandshakeRTT(); rtt != 0 {
+		t.Fatalf("expect no RTT before the handshake, got %v", rtt)
+	}
+
+	cprot, sprot := newProtocolPair(t)
+	done := make(chan error, 1)
+	go func() {
+		name, _, seqID, err := sprot.ReadMessageBegin()
+		if err != nil || name != TrackingAPIName {
+			done <- err
+			return
+		}
+		tracking.NewUpgradeArgs_().Read(sprot)
+		sprot.ReadMessageEnd()
+		clock.Advance(42 * time.Millisecond) // the round trip
+		reply := server.(*SimpleTracker).upgradeReply(tracking.NewUpgradeArgs_())
+		sprot.WriteMessageBegin(TrackingAPIName, thrift.REPLY, seqID)
+		reply.Write(sprot)
+		sprot.WriteMessageEnd()
+		done <- sprot.Flush()
+	}()
+	if err := client.Negotiation(1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if rtt := client.HandshakeRTT(); rtt != 42*time.Millisecond {
+		t.Fatalf("expect an RTT of 42ms, got %v", rtt)
+	}
+}
+
+func TestRecordingTrackerClock(t *testing.T) {
+	clock := newFakeClock()
+	client, _ := upgradedPair(t, nil, nil)
+	inner := NewSimpleTracker("server", WithClock(clock))
+	handshake(t, NewSimpleTracker("client"), inner)
+	server := NewRecordingTracker(inner, 1)
+
+	ctx := serveRecorded(t, client, server, "req")
+	clock.Advance(7 * time.Millisecond)
+	server.Finish(ctx)
+	r := server.Recent()[0]
+	if !r.Start.Equal(time.Unix(1500000000, 0)) || r.Duration != 7*time.Millisecond {
+		t.Fatalf("expect the trace timed by the clock, got %v for %v", r.Start, r.Duration)
+	}
+}
+
+func TestClockStampsRequests(t *testing.T) {
+	clock := newFakeClock()
+	client, server := upgradedPair(t, []Option{WithClock(clock), WithIDFormat(IDFormatStructured)},
+		[]Option{WithIDFormat(IDFormatStructured)})
+	ctx := passRequestHeader(t, context.Background(), client, server)
+	if ts, ok := EntryTimestampFromContext(ctx); !ok || !ts.Equal(clock.Now()) {
+		t.Fatalf("expect the entry timestamp of the clock, got %v", ts)
+	}
+	id, _ := ctx.Value(CtxKeyRequestID).(string)
+	if want := ":1500000000000:"; !strings.Contains(id, want) {
+		t.Fatalf("expect the request ID stamped %s, got %s", want, id)
+	}
+}
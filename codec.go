@@ -0,0 +1,256 @@
+package tracker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// MetaCodec serializes the meta of a request header into a single blob, which
+// is easier to handle across languages than a Thrift map.
+//
+// The blob travels along with the codec name, so servers decode it with any
+// codec they know of, whichever the client picked. The reserved keys always
+// stay in the Thrift map, peers unaware of codecs, thriftpy for one, only see
+// those: configure a codec only when the servers downstream are able to
+// decode it.
+type MetaCodec interface {
+	Name() string
+	Encode(meta map[string]string) ([]byte, error)
+	Decode(data []byte) (map[string]string, error)
+}
+
+var (
+	// ThriftMetaCodec is the default, the meta stays in the map field of the
+	// header. Used standalone, it encodes the map with the binary protocol.
+	ThriftMetaCodec MetaCodec = thriftMetaCodec{}
+	// JSONMetaCodec encodes the meta as a JSON object.
+	JSONMetaCodec MetaCodec = jsonMetaCodec{}
+	// CBORMetaCodec encodes the meta as a CBOR map of text strings, readable
+	// by any standard CBOR library.
+	CBORMetaCodec MetaCodec = cborMetaCodec{}
+)
+
+var metaCodecs = map[string]MetaCodec{
+	ThriftMetaCodec.Name(): ThriftMetaCodec,
+	JSONMetaCodec.Name():   JSONMetaCodec,
+	CBORMetaCodec.Name():   CBORMetaCodec,
+}
+
+type thriftMetaCodec struct{}
+
+func (thriftMetaCodec) Name() string {
+	return "thrift"
+}
+
+func (thriftMetaCodec) Encode(meta map[string]string) ([]byte, error) {
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	if err := prot.WriteMapBegin(thrift.STRING, thrift.STRING, len(meta)); err != nil {
+		return nil, err
+	}
+	for k, v := range meta {
+		if err := prot.WriteString(k); err != nil {
+			return nil, err
+		}
+		if err := prot.WriteString(v); err != nil {
+			return nil, err
+		}
+	}
+	if err := prot.WriteMapEnd(); err != nil {
+		return nil, err
+	}
+	if err := prot.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (thriftMetaCodec) Decode(data []byte) (map[string]string, error) {
+	buf := thrift.NewTMemoryBufferLen(len(data))
+	buf.Write(data)
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	_, _, size, err := prot.ReadMapBegin()
+	if err != nil {
+		return nil, err
+	}
+	if size > len(data) { // every entry takes some bytes, do not trust the size blindly
+		return nil, errors.New("thrift meta codec: map size exceeds data")
+	}
+	meta := make(map[string]string, size)
+	for i := 0; i < size; i++ {
+		k, err := prot.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		v, err := prot.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		meta[k] = v
+	}
+	return meta, prot.ReadMapEnd()
+}
+
+type jsonMetaCodec struct{}
+
+func (jsonMetaCodec) Name() string {
+	return "json"
+}
+
+func (jsonMetaCodec) Encode(meta map[string]string) ([]byte, error) {
+	return json.Marshal(meta)
+}
+
+func (jsonMetaCodec) Decode(data []byte) (map[string]string, error) {
+	var meta map[string]string
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// metaCodecNames returns the names of codecs, nil if there are none.
+func metaCodecNames(codecs []MetaCodec) []string {
+	if len(codecs) == 0 {
+		return nil
+	}
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		names[i] = codec.Name()
+	}
+	return names
+}
+
+// lookupMetaCodec returns the codec of codecs named name, nil if none is.
+func lookupMetaCodec(codecs []MetaCodec, name string) MetaCodec {
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec
+		}
+	}
+	return nil
+}
+
+// pickMetaCodec returns the first of local, in order of priority, the peer
+// supports. Without local codecs, the first of peer known to the tracker is
+// picked. ThriftMetaCodec is returned if both sides have none in common.
+func pickMetaCodec(local []MetaCodec, peer []string) MetaCodec {
+	if len(local) == 0 {
+		for _, name := range peer {
+			if codec, ok := metaCodecs[name]; ok {
+				return codec
+			}
+		}
+		return ThriftMetaCodec
+	}
+	for _, codec := range local {
+		for _, name := range peer {
+			if codec.Name() == name {
+				return codec
+			}
+		}
+	}
+	return ThriftMetaCodec
+}
+
+// replyMetaCodec returns the codec picked in reply on the server side.
+func (t *SimpleTracker) replyMetaCodec(reply *tracking.UpgradeReply) MetaCodec {
+	if !reply.IsSetMetaCodec() {
+		return nil
+	}
+	if codec := lookupMetaCodec(t.metaCodecs, reply.GetMetaCodec()); codec != nil {
+		return codec
+	}
+	return metaCodecs[reply.GetMetaCodec()]
+}
+
+// NegotiatedMetaCodec returns the codec picked during the handshake for the
+// meta of the request headers on the connection, ThriftMetaCodec if none was.
+func (t *SimpleTracker) NegotiatedMetaCodec() MetaCodec {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.negotiatedCodec == nil {
+		return ThriftMetaCodec
+	}
+	return t.negotiatedCodec
+}
+
+// writeMetaCodec returns the codec to write the meta with: the negotiated one
+// if the tracker has codecs to negotiate, the one of WithMetaCodec otherwise.
+func (t *SimpleTracker) writeMetaCodec() MetaCodec {
+	if len(t.metaCodecs) == 0 {
+		return t.metaCodec
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.negotiatedCodec
+}
+
+func (t *SimpleTracker) encodeMeta(header *tracking.RequestHeader) error {
+	codec := t.writeMetaCodec()
+	if codec == nil || codec.Name() == ThriftMetaCodec.Name() {
+		return nil
+	}
+	meta, reserved := make(map[string]string, len(header.Meta)), make(map[string]string)
+	for k, v := range header.Meta {
+		if isReservedMetaKey(k) {
+			reserved[k] = v
+		} else {
+			meta[k] = v
+		}
+	}
+	if len(meta) == 0 { // the reserved keys only, an unsampled header for one
+		return nil
+	}
+	blob, err := codec.Encode(meta)
+	if err != nil {
+		return err
+	}
+	header.Meta = reserved
+	header.MetaBlob = blob
+	header.MetaCodec = thrift.StringPtr(codec.Name())
+	return nil
+}
+
+func (t *SimpleTracker) decodeMeta(header *tracking.RequestHeader, drops metaDrops) (map[string]string, error) {
+	if !header.IsSetMetaCodec() {
+		return header.GetMeta(), nil
+	}
+	name := header.GetMetaCodec()
+	codec, ok := metaCodecs[name]
+	if t.metaCodec != nil && t.metaCodec.Name() == name {
+		codec, ok = t.metaCodec, true
+	}
+	if c := lookupMetaCodec(t.metaCodecs, name); c != nil {
+		codec, ok = c, true
+	}
+	if !ok && headerSchemaVersion(header) > HeaderSchemaVersion {
+		// A newer writer may use a codec unknown here, keep the reserved keys
+		// of the Thrift map rather than failing the request.
+		return header.GetMeta(), nil
+	}
+	if !ok {
+		return nil, thrift.NewTProtocolExceptionWithType(thrift.INVALID_DATA,
+			fmt.Errorf("unknown meta codec %q", name))
+	}
+	decoded, err := codec.Decode(header.GetMetaBlob())
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string]string, len(decoded)+len(header.Meta))
+	for k, v := range decoded {
+		if !isReservedMetaKey(k) { // only trusted from the Thrift map
+			meta[k] = v
+		} else {
+			drops.add(MetaDropReserved, k)
+		}
+	}
+	for k, v := range header.GetMeta() {
+		meta[k] = v
+	}
+	return meta, nil
+}
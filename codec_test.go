@@ -0,0 +1,161 @@
+package tracker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func TestMetaCodecRoundTrip(t *testing.T) {
+	metas := []map[string]string{
+		{},
+		{"": ""},
+		{"k": "v", "empty": ""},
+		{"键": "值", "ключ": "значение", "emoji🙂": "✓"},
+	}
+	for _, codec := range []MetaCodec{ThriftMetaCodec, JSONMetaCodec} {
+		for _, meta := range metas {
+			data, err := codec.Encode(meta)
+			if err != nil {
+				t.Fatalf("%s: encode %v: %v", codec.Name(), meta, err)
+			}
+			got, err := codec.Decode(data)
+			if err != nil {
+				t.Fatalf("%s: decode %v: %v", codec.Name(), meta, err)
+			}
+			if len(got) != len(meta) || (len(meta) > 0 && !reflect.DeepEqual(got, meta)) {
+				t.Fatalf("%s: expect %v, got %v", codec.Name(), meta, got)
+			}
+		}
+	}
+}
+
+func TestThriftMetaCodecRejectsOversizedMap(t *testing.T) {
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	prot.WriteMapBegin(thrift.STRING, thrift.STRING, 1<<20)
+	prot.Flush()
+	if _, err := ThriftMetaCodec.Decode(buf.Bytes()); err == nil {
+		t.Fatal("expect an error for a map larger than the data")
+	}
+}
+
+func TestMetaCodecOverTheWire(t *testing.T) {
+	client, server := upgradedPair(t, []Option{WithMetaCodec(JSONMetaCodec)}, nil)
+	meta := map[string]string{"键": "值", "k": "v"}
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, meta)
+
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	header := tracking.NewRequestHeader()
+	if err := header.Read(prot); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := header.Meta["k"]; ok || header.GetMetaCodec() != JSONMetaCodec.Name() {
+		t.Fatalf("expect the meta to be in a JSON blob, got %v, codec %q", header.Meta, header.GetMetaCodec())
+	}
+
+	sctx := passRequestHeader(t, ctx, client, server)
+	got := metaFromContext(sctx)
+	for k, v := range meta {
+		if got[k] != v {
+			t.Fatalf("expect %s=%s, got %v", k, v, got)
+		}
+	}
+}
+
+func TestMetaCodecUnknown(t *testing.T) {
+	tracker := NewSimpleTracker("server").(*SimpleTracker)
+	header := tracking.NewRequestHeader()
+	header.MetaCodec = thrift.StringPtr("unknown")
+	header.MetaBlob = []byte("blob")
+	if _, err := tracker.decodeMeta(header, nil); err == nil {
+		t.Fatal("expect an unknown codec to fail")
+	}
+}
+
+func TestMetaCodecKeepsReservedKeysInMap(t *testing.T) {
+	client := NewSimpleTracker("client", WithMetaCodec(JSONMetaCodec)).(*SimpleTracker)
+	client.upgradeProtocol(IDFormatOpaque, 0, nil)
+	ctx := WithBudget(context.Background(), 3)
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"k": "v"})
+
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	header := tracking.NewRequestHeader()
+	header.Read(prot)
+	if header.Meta[MetaKeyBudget] != "3" || header.Meta["k"] != "" {
+		t.Fatalf("expect only the reserved keys in the map, got %v", header.Meta)
+	}
+}
+
+func negotiatedCodecs(t *testing.T, clientOpts, serverOpts []Option) (client, server string) {
+	t.Helper()
+	c, s := upgradedPair(t, clientOpts, serverOpts)
+	return c.(*SimpleTracker).NegotiatedMetaCodec().Name(), s.(*SimpleTracker).NegotiatedMetaCodec().Name()
+}
+
+func TestMetaCodecNegotiation(t *testing.T) {
+	cases := []struct {
+		client, server []MetaCodec
+		expect         string
+	}{
+		// The priority of the server wins.
+		{[]MetaCodec{JSONMetaCodec, CBORMetaCodec}, []MetaCodec{CBORMetaCodec, JSONMetaCodec}, "cbor"},
+		{[]MetaCodec{CBORMetaCodec, JSONMetaCodec}, []MetaCodec{JSONMetaCodec}, "json"},
+		// The server without codecs follows the client.
+		{[]MetaCodec{CBORMetaCodec, JSONMetaCodec}, nil, "cbor"},
+		// Nothing in common.
+		{[]MetaCodec{JSONMetaCodec}, []MetaCodec{CBORMetaCodec}, "thrift"},
+		// The client does not negotiate.
+		{nil, []MetaCodec{CBORMetaCodec}, "thrift"},
+	}
+	for _, c := range cases {
+		client, server := negotiatedCodecs(t, []Option{WithMetaCodecs(c.client...)}, []Option{WithMetaCodecs(c.server...)})
+		if client != c.expect || server != c.expect {
+			t.Fatalf("%v/%v: expect %s, got client %s, server %s",
+				metaCodecNames(c.client), metaCodecNames(c.server), c.expect, client, server)
+		}
+	}
+}
+
+func TestMetaCodecNegotiatedOverTheWire(t *testing.T) {
+	client, server := upgradedPair(t,
+		[]Option{WithMetaCodec(JSONMetaCodec), WithMetaCodecs(JSONMetaCodec, CBORMetaCodec)},
+		[]Option{WithMetaCodecs(CBORMetaCodec)})
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"k": "v"})
+
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	header := tracking.NewRequestHeader()
+	if err := header.Read(prot); err != nil {
+		t.Fatal(err)
+	}
+	if header.GetMetaCodec() != CBORMetaCodec.Name() {
+		t.Fatalf("expect the negotiated codec over WithMetaCodec, got %q", header.GetMetaCodec())
+	}
+	if got := metaFromContext(passRequestHeader(t, ctx, client, server)); got["k"] != "v" {
+		t.Fatalf("expect k=v, got %v", got)
+	}
+
+	// Nothing in common, the meta stays in the map.
+	client, _ = upgradedPair(t, []Option{WithMetaCodecs(JSONMetaCodec)}, []Option{WithMetaCodecs(CBORMetaCodec)})
+	prot = thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	header = tracking.NewRequestHeader()
+	header.Read(prot)
+	if header.IsSetMetaCodec() || header.Meta["k"] != "v" {
+		t.Fatalf("expect the Thrift map, got %v, codec %q", header.Meta, header.GetMetaCodec())
+	}
+}
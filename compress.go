@@ -0,0 +1,127 @@
+package tracker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// Compressor compresses the meta blobs of a CompressedMetaCodec, the name
+// tells the compression apart in the name of the codec.
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCompressor compresses with gzip, from the standard library. Snappy, or
+// any other algorithm, is plugged through a Compressor of its own.
+var GzipCompressor Compressor = gzipCompressor{}
+
+// MaxDecompressedMetaSize is the maximum size, in bytes, of a meta blob once
+// decompressed, larger ones are rejected rather than read into memory.
+const MaxDecompressedMetaSize = 1 << 20
+
+var errDecompressedMetaTooLarge = errors.New("compressed meta codec: decompressed meta too large")
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readAllLimited(r, MaxDecompressedMetaSize)
+}
+
+// readAllLimited reads r to its end, failing past limit bytes.
+func readAllLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errDecompressedMetaTooLarge
+	}
+	return data, nil
+}
+
+// The first byte of the blobs of a CompressedMetaCodec.
+const (
+	blobRaw        byte = 0
+	blobCompressed byte = 1
+)
+
+// CompressedMetaCodec returns codec whose blobs of more than threshold bytes
+// are compressed with c, for the services passing large baggage along. It is
+// named "<codec>+<compressor>", "json+gzip" for one: set it with
+// WithMetaCodecs on both sides, the handshake makes sure the peer decodes it
+// before it is used, see WithMetaCodecs. The small blobs are sent as is,
+// behind a marker byte, compressing them would only make them larger.
+func CompressedMetaCodec(codec MetaCodec, c Compressor, threshold int) MetaCodec {
+	return compressedMetaCodec{codec: codec, compressor: c, threshold: threshold}
+}
+
+type compressedMetaCodec struct {
+	codec      MetaCodec
+	compressor Compressor
+	threshold  int
+}
+
+func (c compressedMetaCodec) Name() string {
+	return c.codec.Name() + "+" + c.compressor.Name()
+}
+
+func (c compressedMetaCodec) Encode(meta map[string]string) ([]byte, error) {
+	data, err := c.codec.Encode(meta)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= c.threshold {
+		return append([]byte{blobRaw}, data...), nil
+	}
+	compressed, err := c.compressor.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed) >= len(data) { // incompressible
+		return append([]byte{blobRaw}, data...), nil
+	}
+	return append([]byte{blobCompressed}, compressed...), nil
+}
+
+func (c compressedMetaCodec) Decode(data []byte) (map[string]string, error) {
+	if len(data) == 0 {
+		return nil, errors.New("compressed meta codec: empty data")
+	}
+	switch data[0] {
+	case blobRaw:
+		return c.codec.Decode(data[1:])
+	case blobCompressed:
+		decompressed, err := c.compressor.Decompress(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		return c.codec.Decode(decompressed)
+	}
+	return nil, errors.New("compressed meta codec: unknown marker")
+}
@@ -0,0 +1,88 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func largeMeta() map[string]string {
+	meta := make(map[string]string)
+	for i := 0; i < 32; i++ {
+		meta[fmt.Sprintf("flag-%d", i)] = strings.Repeat("enabled,", 8)
+	}
+	return meta
+}
+
+func TestCompressedMetaCodec(t *testing.T) {
+	codec := CompressedMetaCodec(JSONMetaCodec, GzipCompressor, 64)
+	if name := codec.Name(); name != "json+gzip" {
+		t.Fatalf("expect json+gzip, got %s", name)
+	}
+	for _, meta := range []map[string]string{{"k": "v"}, largeMeta()} {
+		blob, err := codec.Encode(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, _ := JSONMetaCodec.Encode(meta)
+		if compressed := blob[0] == blobCompressed; compressed != (len(plain) > 64) {
+			t.Fatalf("expect compression above the threshold only, %d bytes compressed: %v", len(plain), compressed)
+		}
+		got, err := codec.Decode(blob)
+		if err != nil || !reflect.DeepEqual(got, meta) {
+			t.Fatalf("expect %v back, got %v %v", meta, got, err)
+		}
+	}
+	for _, blob := range [][]byte{nil, {7}, {blobCompressed, 1, 2, 3}} {
+		if _, err := codec.Decode(blob); err == nil {
+			t.Fatalf("expect %v rejected", blob)
+		}
+	}
+}
+
+func TestCompressedMetaCodecBomb(t *testing.T) {
+	bomb, err := GzipCompressor.Compress(bytes.Repeat([]byte{'a'}, MaxDecompressedMetaSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := CompressedMetaCodec(JSONMetaCodec, GzipCompressor, 0)
+	if _, err := codec.Decode(append([]byte{blobCompressed}, bomb...)); err != errDecompressedMetaTooLarge {
+		t.Fatalf("expect the decompressed size bounded, got %v", err)
+	}
+}
+
+func TestCompressedMetaCodecNegotiated(t *testing.T) {
+	gzipped := WithMetaCodecs(CompressedMetaCodec(JSONMetaCodec, GzipCompressor, 64))
+	client, server := upgradedPair(t, []Option{gzipped}, []Option{gzipped})
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, largeMeta())
+	got := passRequestHeader(t, ctx, client, server)
+	if meta, _ := got.Value(CtxKeyRequestMeta).(map[string]string); meta["flag-0"] != largeMeta()["flag-0"] {
+		t.Fatalf("expect the meta across, got %v", meta)
+	}
+
+	// A server unaware of the compression never gets compressed data.
+	client, _ = upgradedPair(t, []Option{gzipped}, nil)
+	if codec := client.(*SimpleTracker).NegotiatedMetaCodec(); codec.Name() != ThriftMetaCodec.Name() {
+		t.Fatalf("expect the fallback to the Thrift map, got %s", codec.Name())
+	}
+}
+
+func BenchmarkCompressedMeta(b *testing.B) {
+	meta := largeMeta()
+	for _, codec := range []MetaCodec{JSONMetaCodec, CompressedMetaCodec(JSONMetaCodec, GzipCompressor, 256)} {
+		b.Run(codec.Name(), func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				blob, err := codec.Encode(meta)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(blob)
+			}
+			b.ReportMetric(float64(size), "bytes/header")
+		})
+	}
+}
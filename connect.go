@@ -0,0 +1,28 @@
+package tracker
+
+import (
+	"context"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// HandshakeSeqID is the seqID NegotiateConnection sends the handshake with,
+// the first of the range of the handshakes, see SeqIDAllocator: it never
+// collides with the calls of a thrift client, counting up from 1.
+const HandshakeSeqID int32 = -1
+
+// NegotiateConnection runs the handshake of a new connection, right after
+// connecting and before any call, with NegotiationContext if h is a
+// ContextHandShaker, it gives up once ctx is done otherwise. It returns the
+// seqID of the handshake, HandshakeSeqID, the sequence of the calls of the
+// client is left alone. It does nothing for a SimpleTracker negotiated
+// already.
+func NegotiateConnection(ctx context.Context, h HandShaker, iprot, oprot thrift.TProtocol) (int32, error) {
+	if ch, ok := h.(ContextHandShaker); ok {
+		return HandshakeSeqID, ch.NegotiationContext(ctx, HandshakeSeqID, iprot, oprot)
+	}
+	if err := ctx.Err(); err != nil {
+		return HandshakeSeqID, errNegotiationAborted()
+	}
+	return HandshakeSeqID, h.Negotiation(HandshakeSeqID, iprot, oprot)
+}
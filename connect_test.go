@@ -0,0 +1,46 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func TestNegotiateConnection(t *testing.T) {
+	cprot, sprot := newProtocolPair(t)
+	server := NewSimpleTracker("server")
+	done := make(chan error, 1)
+	go func() {
+		name, _, seqID, err := sprot.ReadMessageBegin()
+		if err == nil && (name != TrackingAPIName || seqID != HandshakeSeqID) {
+			err = thrift.NewTApplicationException(thrift.BAD_SEQUENCE_ID, name)
+		}
+		if err == nil {
+			_, err = server.TryUpgrade(seqID, sprot, sprot)
+		}
+		done <- err
+	}()
+	client := NewSimpleTracker("client")
+	seqID, err := NegotiateConnection(context.Background(), client, cprot, cprot)
+	if err != nil || seqID != HandshakeSeqID {
+		t.Fatalf("expect the handshake with %d, got %d %v", HandshakeSeqID, seqID, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !client.RequestHeaderSupported() {
+		t.Fatal("expect the client upgraded")
+	}
+}
+
+func TestNegotiateConnectionCanceled(t *testing.T) {
+	cprot, _ := newProtocolPair(t) // any write would block forever
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, h := range []HandShaker{NewSimpleTracker("client"), NoopTracker{}} {
+		if _, err := NegotiateConnection(ctx, h, cprot, cprot); err == nil {
+			t.Fatalf("%T: expect the handshake to be aborted", h)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package tracker
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// MetaKeyTotalDeadline is the reserved meta key carrying, in epoch
+// milliseconds, the deadline of the original caller across all the attempts
+// and hops of a request. It differs from the deadline of a context, which
+// bounds a single attempt and is not propagated.
+const MetaKeyTotalDeadline = "total_deadline"
+
+const ctxKeyTotalDeadline ctxKey = "__thrift_tracking_total_deadline"
+
+// WithTotalDeadline returns a context with the total deadline of the request
+// set, it is set once: an earlier deadline already in ctx is kept.
+func WithTotalDeadline(ctx context.Context, deadline time.Time) context.Context {
+	if cur, ok := TotalDeadlineFromContext(ctx); ok && !deadline.Before(cur) {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKeyTotalDeadline, deadline)
+}
+
+// TotalDeadlineFromContext returns the total deadline of the current request.
+func TotalDeadlineFromContext(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Value(ctxKeyTotalDeadline).(time.Time)
+	return deadline, ok
+}
+
+// AttemptContext returns a context for one attempt of a call, lasting at most
+// timeout and never past the total deadline of ctx, so retries and hops only
+// get what is left of the time of the original caller.
+func AttemptContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	deadline := time.Now().Add(timeout)
+	if total, ok := TotalDeadlineFromContext(ctx); ok && total.Before(deadline) {
+		deadline = total
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+func extractTotalDeadline(ctx context.Context, meta map[string]string) (context.Context, error) {
+	ms, err := strconv.ParseInt(meta[MetaKeyTotalDeadline], 10, 64)
+	if err != nil || ms <= 0 {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, ctxKeyTotalDeadline, time.Unix(0, ms*int64(time.Millisecond))), nil
+}
+
+func injectTotalDeadline(ctx context.Context, meta map[string]string) error {
+	if deadline, ok := TotalDeadlineFromContext(ctx); ok {
+		meta[MetaKeyTotalDeadline] = strconv.FormatInt(deadline.UnixNano()/int64(time.Millisecond), 10)
+	} else {
+		delete(meta, MetaKeyTotalDeadline)
+	}
+	return nil
+}
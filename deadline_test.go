@@ -0,0 +1,64 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTotalDeadlineSetOnce(t *testing.T) {
+	total := time.Now().Add(time.Second).Truncate(time.Millisecond)
+	ctx := WithTotalDeadline(context.Background(), total)
+	if got, _ := TotalDeadlineFromContext(WithTotalDeadline(ctx, total.Add(time.Hour))); !got.Equal(total) {
+		t.Fatalf("expect a later deadline not to extend the total one, got %v", got)
+	}
+	earlier := total.Add(-time.Millisecond * 500)
+	if got, _ := TotalDeadlineFromContext(WithTotalDeadline(ctx, earlier)); !got.Equal(earlier) {
+		t.Fatalf("expect an earlier deadline to win, got %v", got)
+	}
+}
+
+func TestTotalDeadlineAcrossRetries(t *testing.T) {
+	total := time.Now().Add(200 * time.Millisecond).Truncate(time.Millisecond)
+	ctx := WithTotalDeadline(context.Background(), total)
+	for attempt := 0; attempt < 3; attempt++ {
+		actx, cancel := AttemptContext(ctx, time.Hour)
+		client, server := upgradedPair(t, nil, nil)
+		sctx := passRequestHeader(t, actx, client, server)
+		cancel()
+		if got, ok := TotalDeadlineFromContext(sctx); !ok || !got.Equal(total) {
+			t.Fatalf("attempt %d: expect total deadline %v, got %v(%v)", attempt, total, got, ok)
+		}
+		if deadline, ok := actx.Deadline(); !ok || deadline.After(total) {
+			t.Fatalf("attempt %d: expect the attempt to end by the total deadline, got %v", attempt, deadline)
+		}
+	}
+}
+
+func TestTotalDeadlineDownstream(t *testing.T) {
+	total := time.Now().Add(100 * time.Millisecond).Truncate(time.Millisecond)
+	ctx := WithTotalDeadline(context.Background(), total)
+	client, server := upgradedPair(t, nil, nil)
+	sctx := passRequestHeader(t, ctx, client, server)
+
+	time.Sleep(30 * time.Millisecond) // the work of the server
+	dctx, cancel := AttemptContext(sctx, time.Second)
+	defer cancel()
+	deadline, _ := dctx.Deadline()
+	if !deadline.Equal(total) {
+		t.Fatalf("expect the downstream call to get what is left, got %v", time.Until(deadline))
+	}
+	if left := time.Until(deadline); left > 70*time.Millisecond {
+		t.Fatalf("expect the budget to shrink, %v left", left)
+	}
+}
+
+func TestTotalDeadlineAbsent(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	sctx := passRequestHeader(t, ctx, client, server)
+	if _, ok := TotalDeadlineFromContext(sctx); ok {
+		t.Fatal("expect the per-attempt deadline not to be propagated")
+	}
+}
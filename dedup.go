@@ -0,0 +1,92 @@
+package tracker
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// HandshakeDedup lets the handshakes of a client opening many connections at
+// once share a single decision: the reply computed for an UpgradeArgs_ is
+// reused by the identical ones from the same AppID arriving within window.
+// Every connection still has its own tracker, only the decision is shared.
+//
+// Share one HandshakeDedup among the server trackers of the same service
+// (configured alike) with WithHandshakeDedup.
+type HandshakeDedup struct {
+	window  time.Duration
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	done     chan struct{}
+	reply    tracking.UpgradeReply
+	computed bool
+}
+
+func NewHandshakeDedup(window time.Duration) *HandshakeDedup {
+	return &HandshakeDedup{
+		window:  window,
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+// do returns the reply for args, calling compute only if there is no reply
+// in flight or computed within the window for identical args.
+func (d *HandshakeDedup) do(args *tracking.UpgradeArgs_, compute func() *tracking.UpgradeReply) *tracking.UpgradeReply {
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	keyed := *args
+	keyed.Meta = nil // the order of a map is random, see below
+	if err := keyed.Write(prot); err != nil {
+		return compute() // can not happen with a memory buffer, do not share then
+	}
+	keys := make([]string, 0, len(args.Meta))
+	for k := range args.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		prot.WriteString(k)
+		prot.WriteString(args.Meta[k])
+	}
+	key := buf.String() // the args as a whole, every field takes part
+
+	d.mu.Lock()
+	e, ok := d.entries[key]
+	if !ok {
+		e = &dedupEntry{done: make(chan struct{})}
+		d.entries[key] = e
+	}
+	d.mu.Unlock()
+
+	if !ok {
+		defer func() { // even if compute panics, the waiters would hang otherwise
+			close(e.done)
+			time.AfterFunc(d.window, func() {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				if d.entries[key] == e {
+					delete(d.entries, key)
+				}
+			})
+		}()
+		e.reply = *compute()
+		e.computed = true
+		return d.copyReply(e)
+	}
+	<-e.done
+	if !e.computed { // compute panicked, try on our own
+		return compute()
+	}
+	return d.copyReply(e)
+}
+
+func (d *HandshakeDedup) copyReply(e *dedupEntry) *tracking.UpgradeReply {
+	reply := e.reply // the optional fields point to values never modified
+	return &reply
+}
@@ -0,0 +1,142 @@
+package tracker
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func newArgs(appID string, maxConcurrent int32) *tracking.UpgradeArgs_ {
+	args := tracking.NewUpgradeArgs_()
+	args.AppID = appID
+	args.MaxConcurrent = thrift.Int32Ptr(maxConcurrent)
+	return args
+}
+
+func TestHandshakeDedupComputesOnce(t *testing.T) {
+	dedup := NewHandshakeDedup(time.Minute)
+	var computed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply := dedup.do(newArgs("client", 4), func() *tracking.UpgradeReply {
+				atomic.AddInt32(&computed, 1)
+				time.Sleep(10 * time.Millisecond) // let the others pile up
+				reply := tracking.NewUpgradeReply()
+				reply.MaxConcurrent = thrift.Int32Ptr(4)
+				return reply
+			})
+			if reply.GetMaxConcurrent() != 4 {
+				t.Errorf("expect the shared decision, got %v", reply)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&computed); n != 1 {
+		t.Fatalf("expect the decision to be computed once, got %d", n)
+	}
+}
+
+func TestHandshakeDedupDistinctArgs(t *testing.T) {
+	dedup := NewHandshakeDedup(time.Minute)
+	computed := 0
+	compute := func() *tracking.UpgradeReply {
+		computed++
+		return tracking.NewUpgradeReply()
+	}
+	dedup.do(newArgs("a", 4), compute)
+	dedup.do(newArgs("b", 4), compute)
+	dedup.do(newArgs("a", 8), compute)
+	dedup.do(newArgs("a", 4), compute)
+	if computed != 3 {
+		t.Fatalf("expect 3 decisions, got %d", computed)
+	}
+}
+
+func TestHandshakeDedupWindow(t *testing.T) {
+	dedup := NewHandshakeDedup(10 * time.Millisecond)
+	computed := 0
+	compute := func() *tracking.UpgradeReply {
+		computed++
+		return tracking.NewUpgradeReply()
+	}
+	dedup.do(newArgs("a", 0), compute)
+	time.Sleep(50 * time.Millisecond)
+	dedup.do(newArgs("a", 0), compute)
+	if computed != 2 {
+		t.Fatalf("expect the decision to expire, computed %d", computed)
+	}
+}
+
+func TestHandshakeDedupPanic(t *testing.T) {
+	dedup := NewHandshakeDedup(time.Minute)
+	started := make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		dedup.do(newArgs("a", 0), func() *tracking.UpgradeReply {
+			close(started)
+			time.Sleep(20 * time.Millisecond)
+			panic("boom")
+		})
+	}()
+	<-started
+
+	done := make(chan *tracking.UpgradeReply, 1)
+	go func() {
+		done <- dedup.do(newArgs("a", 0), func() *tracking.UpgradeReply {
+			reply := tracking.NewUpgradeReply()
+			reply.MaxConcurrent = thrift.Int32Ptr(1)
+			return reply
+		})
+	}()
+	select {
+	case reply := <-done:
+		if reply.GetMaxConcurrent() != 1 {
+			t.Fatalf("expect the waiter to compute its own decision, got %v", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the waiter not to hang")
+	}
+}
+
+func TestHandshakeDedupTrackers(t *testing.T) {
+	dedup := NewHandshakeDedup(time.Minute)
+	for i := 0; i < 3; i++ {
+		client := NewSimpleTracker("client", WithMaxConcurrentStreams(8))
+		server := NewSimpleTracker("server", WithHandshakeDedup(dedup), WithMaxConcurrentStreams(4)).(*SimpleTracker)
+		handshake(t, client, server)
+		if server.MaxConcurrentStreams() != 4 || server.PeerAppID() != "client" || !server.RequestHeaderSupported() {
+			t.Fatalf("expect every tracker to be upgraded with the shared decision")
+		}
+	}
+}
+
+func TestHandshakeDedupMetaOrder(t *testing.T) {
+	dedup := NewHandshakeDedup(time.Minute)
+	var computed int32
+	compute := func() *tracking.UpgradeReply {
+		atomic.AddInt32(&computed, 1)
+		return tracking.NewUpgradeReply()
+	}
+	meta := make(map[string]string)
+	for i := 0; i < 16; i++ {
+		meta[string(rune('a'+i))] = "v"
+	}
+	for i := 0; i < 8; i++ {
+		args := newArgs("client", 4)
+		args.Meta = meta
+		dedup.do(args, compute)
+	}
+	args := newArgs("client", 4)
+	args.Meta = map[string]string{"a": "other"}
+	dedup.do(args, compute)
+	if n := atomic.LoadInt32(&computed); n != 2 {
+		t.Fatalf("expect one decision per distinct meta, got %d", n)
+	}
+}
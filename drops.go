@@ -0,0 +1,56 @@
+package tracker
+
+import (
+	"sort"
+)
+
+// The reasons meta can be dropped for, passed to the observer registered with
+// WithMetaDroppedObserver.
+const (
+	// MetaDropCollision: keys collapsing into the same canonical key, all but
+	// the winner are dropped.
+	MetaDropCollision = "collision"
+	// MetaDropTransform: keys removed by a MetaTransform, or reserved ones it
+	// tried to add without AllowReservedMetaTransform.
+	MetaDropTransform = "transform"
+	// MetaDropSizeLimit: reserved values over their size limit, dropped or
+	// truncated, or meta over the limits of WithMetaLimits or
+	// WithMaxMetaEntries.
+	MetaDropSizeLimit = "size_limit"
+	// MetaDropReserved: reserved keys found in a meta blob, they are only
+	// trusted from the Thrift map.
+	MetaDropReserved = "reserved"
+)
+
+// metaDrops aggregates the meta keys dropped while reading or writing a
+// request header, by reason. A nil metaDrops records nothing.
+type metaDrops map[string][]string
+
+func (d metaDrops) add(reason string, keys ...string) {
+	if d != nil {
+		d[reason] = append(d[reason], keys...)
+	}
+}
+
+// metaDrops returns where to record the drops of a read or write, nil unless
+// they are observed.
+func (t *SimpleTracker) metaDrops() metaDrops {
+	if t.onMetaDropped == nil {
+		return nil
+	}
+	return make(metaDrops)
+}
+
+// reportMetaDrops calls the observer once per reason, the keys sorted.
+func (t *SimpleTracker) reportMetaDrops(d metaDrops) {
+	reasons := make([]string, 0, len(d))
+	for reason := range d {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		keys := d[reason]
+		sort.Strings(keys)
+		t.onMetaDropped(reason, keys)
+	}
+}
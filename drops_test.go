@@ -0,0 +1,94 @@
+package tracker
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+type droppedMeta struct {
+	reason string
+	keys   []string
+}
+
+func observeDrops(drops *[]droppedMeta) Option {
+	return WithMetaDroppedObserver(func(reason string, keys []string) {
+		*drops = append(*drops, droppedMeta{reason, keys})
+	})
+}
+
+func TestMetaDroppedSizeLimit(t *testing.T) {
+	var drops []droppedMeta
+	_, server := upgradedPair(t, nil, []Option{observeDrops(&drops)})
+
+	header := tracking.NewRequestHeader()
+	header.Meta = map[string]string{
+		MetaKeyOriginRoute: strings.Repeat("r", MaxOriginRouteLength+1),
+		MetaKeyExperiments: "a=" + strings.Repeat("x", MaxExperimentsSize),
+		MetaKeyLocale:      "en-US",
+		"user":             "kept",
+	}
+	for name, read := range map[string]func(thrift.TProtocol) error{
+		"TryReadRequestHeader": func(prot thrift.TProtocol) error {
+			_, err := server.TryReadRequestHeader(prot)
+			return err
+		},
+		"ForEachRequestMeta": func(prot thrift.TProtocol) error {
+			_, err := server.(*SimpleTracker).ForEachRequestMeta(prot, func(k, v string) bool { return true })
+			return err
+		},
+	} {
+		drops = nil
+		prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+		if err := header.Write(prot); err != nil {
+			t.Fatal(err)
+		}
+		if err := read(prot); err != nil {
+			t.Fatal(err)
+		}
+		want := []droppedMeta{{MetaDropSizeLimit, []string{MetaKeyExperiments, MetaKeyOriginRoute}}}
+		if !reflect.DeepEqual(drops, want) {
+			t.Fatalf("%s: expect one call with the keys over their limit, got %+v", name, drops)
+		}
+	}
+}
+
+func TestMetaDroppedOnWrite(t *testing.T) {
+	var drops []droppedMeta
+	transform := func(in map[string]string) map[string]string {
+		delete(in, "secret")
+		in[MetaKeyShardKey] = "forged"
+		return in
+	}
+	client, server := upgradedPair(t, []Option{WithCanonicalMetaKeys(nil)}, []Option{WithMetaTransform(transform)})
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"secret": "s", "k": "v"})
+	sctx := passRequestHeader(t, ctx, client, server)
+
+	downstream := NewSimpleTracker("downstream", WithCanonicalMetaKeys(nil), observeDrops(&drops))
+	handshake(t, downstream, NewSimpleTracker("next"))
+	meta := metaFromContext(sctx)
+	meta["K"], meta["Secret"] = "collides", "collides"
+	sctx = context.WithValue(sctx, CtxKeyRequestMeta, meta)
+	if err := downstream.TryWriteRequestHeader(sctx, thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())); err != nil {
+		t.Fatal(err)
+	}
+	want := []droppedMeta{
+		{MetaDropCollision, []string{"K", "Secret"}},
+		{MetaDropTransform, []string{"secret", MetaKeyShardKey}},
+	}
+	if !reflect.DeepEqual(drops, want) {
+		t.Fatalf("expect the drops aggregated by reason, got %+v", drops)
+	}
+
+	drops = nil
+	if err := downstream.TryWriteRequestHeader(context.Background(), thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())); err != nil {
+		t.Fatal(err)
+	}
+	if len(drops) != 0 {
+		t.Fatalf("expect no call without drops, got %+v", drops)
+	}
+}
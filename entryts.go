@@ -0,0 +1,52 @@
+package tracker
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// MetaKeyEntryTimestamp is the reserved meta key carrying the time, in epoch
+// milliseconds, at which a request entered the system. It is stamped once by
+// the edge, the first caller without an incoming request header, and never
+// overwritten by the intermediate hops.
+//
+// The timestamp comes from the clock of the edge host, end-to-end latencies
+// computed against it on other hosts are only as accurate as their clocks are
+// in sync, they can even be negative.
+const MetaKeyEntryTimestamp = "entry_ts"
+
+const ctxKeyEntryTimestamp ctxKey = "__thrift_tracking_entry_ts"
+
+// WithEntryTimestamp returns a context stamped with the time at which the
+// request entered the system. The edge should stamp its context once, so that
+// all the calls made for the same request carry the same timestamp, a call
+// made with no timestamp in its context is stamped with the time of the call.
+func WithEntryTimestamp(ctx context.Context, ts time.Time) context.Context {
+	return context.WithValue(ctx, ctxKeyEntryTimestamp, ts)
+}
+
+// EntryTimestampFromContext returns the time at which the current request
+// entered the system, ok is false at the edge unless stamped by
+// WithEntryTimestamp.
+func EntryTimestampFromContext(ctx context.Context) (ts time.Time, ok bool) {
+	ts, ok = ctx.Value(ctxKeyEntryTimestamp).(time.Time)
+	return
+}
+
+func extractEntryTimestamp(ctx context.Context, meta map[string]string) (context.Context, error) {
+	ms, err := strconv.ParseInt(meta[MetaKeyEntryTimestamp], 10, 64)
+	if err != nil || ms <= 0 {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, ctxKeyEntryTimestamp, time.Unix(0, ms*int64(time.Millisecond))), nil
+}
+
+func injectEntryTimestamp(ctx context.Context, meta map[string]string) error {
+	ts, ok := EntryTimestampFromContext(ctx)
+	if !ok {
+		ts = time.Now()
+	}
+	meta[MetaKeyEntryTimestamp] = strconv.FormatInt(ts.UnixNano()/int64(time.Millisecond), 10)
+	return nil
+}
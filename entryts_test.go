@@ -0,0 +1,59 @@
+package tracker
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEntryTimestampSetOnceAtEdge(t *testing.T) {
+	edge := WithEntryTimestamp(context.Background(), time.Now())
+	var stamps []string
+	for i := 0; i < 2; i++ {
+		client, server := upgradedPair(t, nil, nil)
+		sctx := passRequestHeader(t, edge, client, server)
+		stamps = append(stamps, metaFromContext(sctx)[MetaKeyEntryTimestamp])
+	}
+	if stamps[0] == "" || stamps[0] != stamps[1] {
+		t.Fatalf("expect the calls of the same edge context to carry the same timestamp, got %v", stamps)
+	}
+}
+
+func TestEntryTimestampNotOverwritten(t *testing.T) {
+	entry := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	ctx := WithEntryTimestamp(context.Background(), entry)
+	for hop := 0; hop < 3; hop++ {
+		client, server := upgradedPair(t, nil, nil)
+		ctx = passRequestHeader(t, ctx, client, server)
+		ts, ok := EntryTimestampFromContext(ctx)
+		if !ok || !ts.Equal(entry) {
+			t.Fatalf("hop %d: expect entry timestamp %v, got %v(%v)", hop, entry, ts, ok)
+		}
+	}
+}
+
+func TestEntryTimestampStampedWithoutOne(t *testing.T) {
+	before := time.Now().Truncate(time.Millisecond)
+	client, server := upgradedPair(t, nil, nil)
+	sctx := passRequestHeader(t, context.Background(), client, server)
+	ts, ok := EntryTimestampFromContext(sctx)
+	if !ok || ts.Before(before) || ts.After(time.Now()) {
+		t.Fatalf("expect the call to be stamped, got %v(%v)", ts, ok)
+	}
+}
+
+func TestEntryTimestampGarbageIgnored(t *testing.T) {
+	meta := map[string]string{MetaKeyEntryTimestamp: "garbage"}
+	ctx, err := extractEntryTimestamp(context.Background(), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts, ok := EntryTimestampFromContext(ctx); ok {
+		t.Fatalf("expect no entry timestamp, got %v", ts)
+	}
+	meta[MetaKeyEntryTimestamp] = strconv.FormatInt(-1, 10)
+	if ctx, _ = extractEntryTimestamp(context.Background(), meta); ctx.Value(ctxKeyEntryTimestamp) != nil {
+		t.Fatal("expect a negative timestamp to be ignored")
+	}
+}
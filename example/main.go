@@ -19,6 +19,9 @@ const (
 )
 
 func ppCtx(name string, ctx context.Context) {
+	if tracker.NoLogFromContext(ctx) {
+		return
+	}
 	fmt.Printf("server(%v):\n", name)
 	fmt.Printf("  - RequestID: %#+v\n", ctx.Value(tracker.CtxKeyRequestID))
 	fmt.Printf("  - SequenceID: %#+v\n", ctx.Value(tracker.CtxKeySequenceID))
@@ -32,26 +35,14 @@ type handlerB struct {
 
 func (h *handlerB) Ping(ctx context.Context) (bool, error) {
 	ppCtx(ServerB, ctx)
-	meta := map[string]string{"clientB": "ping"}
-	if origin, ok := ctx.Value(tracker.CtxKeyRequestMeta).(map[string]string); ok {
-		for k, v := range origin {
-			meta[k] = v
-		}
-	}
-	ctx = context.WithValue(ctx, tracker.CtxKeyRequestMeta, meta)
+	ctx = tracker.WithRequestMeta(ctx, "clientB", "ping")
 	h.client.Ping(ctx)
 	return true, nil
 }
 
 func (h *handlerB) Add(ctx context.Context, num1, num2 int32) (int32, error) {
 	ppCtx(ServerB, ctx)
-	meta := map[string]string{"clientB": "add"}
-	if origin, ok := ctx.Value(tracker.CtxKeyRequestMeta).(map[string]string); ok {
-		for k, v := range origin {
-			meta[k] = v
-		}
-	}
-	ctx = context.WithValue(ctx, tracker.CtxKeyRequestMeta, meta)
+	ctx = tracker.WithRequestMeta(ctx, "clientB", "add")
 	h.client.Add(ctx, num1+1, num2+2)
 	return num1 + num2, nil
 }
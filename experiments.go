@@ -0,0 +1,110 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MetaKeyExperiments is the reserved meta key carrying the A/B experiment
+// assignments of a request, so that every service serves the same variant.
+// It is encoded as "experiment=variant" pairs sorted by experiment and
+// separated by ';', propagated unchanged through all the hops.
+const MetaKeyExperiments = "experiments"
+
+// MaxExperimentsSize is the maximum size, in bytes, of the encoded
+// assignments, which travel along with every call of the request.
+const MaxExperimentsSize = 512
+
+const ctxKeyExperiments ctxKey = "__thrift_tracking_experiments"
+
+// ErrExperimentsTooLarge is returned by WithExperiments when the encoded
+// assignments exceed MaxExperimentsSize.
+var ErrExperimentsTooLarge = errors.New("thrift tracker: experiment assignments too large")
+
+// WithExperiments returns a context that attaches the experiment to variant
+// assignments to the requests made with it, they replace any from ctx.
+// Experiments and variants must be non-empty and contain neither '=' nor ';'.
+func WithExperiments(ctx context.Context, assignments map[string]string) (context.Context, error) {
+	encoded, err := encodeExperiments(assignments)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, ctxKeyExperiments, encoded), nil
+}
+
+// ExperimentsFromContext returns the experiment assignments of the current
+// request, nil if there are none.
+func ExperimentsFromContext(ctx context.Context) map[string]string {
+	encoded, _ := ctx.Value(ctxKeyExperiments).(string)
+	assignments, _ := decodeExperiments(encoded)
+	return assignments
+}
+
+func encodeExperiments(assignments map[string]string) (string, error) {
+	names := make([]string, 0, len(assignments))
+	for name, variant := range assignments {
+		if !isExperimentToken(name) || !isExperimentToken(variant) {
+			return "", fmt.Errorf("malformed experiment assignment %q=%q", name, variant)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(assignments[name])
+	}
+	if b.Len() > MaxExperimentsSize {
+		return "", ErrExperimentsTooLarge
+	}
+	return b.String(), nil
+}
+
+func decodeExperiments(encoded string) (map[string]string, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	if len(encoded) > MaxExperimentsSize {
+		return nil, ErrExperimentsTooLarge
+	}
+	assignments := make(map[string]string)
+	for _, pair := range strings.Split(encoded, ";") {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 || !isExperimentToken(pair[:i]) || !isExperimentToken(pair[i+1:]) {
+			return nil, fmt.Errorf("malformed experiment assignment %q", pair)
+		}
+		assignments[pair[:i]] = pair[i+1:]
+	}
+	return assignments, nil
+}
+
+func isExperimentToken(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "=;")
+}
+
+func extractExperiments(ctx context.Context, meta map[string]string) (context.Context, error) {
+	encoded, ok := meta[MetaKeyExperiments]
+	if !ok {
+		return ctx, nil
+	}
+	if _, err := decodeExperiments(encoded); err != nil { // garbage or oversized, ignore it
+		return ctx, nil
+	}
+	return context.WithValue(ctx, ctxKeyExperiments, encoded), nil
+}
+
+func injectExperiments(ctx context.Context, meta map[string]string) error {
+	if encoded, _ := ctx.Value(ctxKeyExperiments).(string); encoded != "" {
+		meta[MetaKeyExperiments] = encoded
+	} else {
+		delete(meta, MetaKeyExperiments)
+	}
+	return nil
+}
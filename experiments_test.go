@@ -0,0 +1,87 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func TestExperimentsEncoding(t *testing.T) {
+	assignments := map[string]string{"checkout": "b", "banner": "control", "search.rank": "v2"}
+	encoded, err := encodeExperiments(assignments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded != "banner=control;checkout=b;search.rank=v2" {
+		t.Fatalf("expect a sorted compact encoding, got %q", encoded)
+	}
+	decoded, err := decodeExperiments(encoded)
+	if err != nil || !reflect.DeepEqual(decoded, assignments) {
+		t.Fatalf("expect a round trip, got %v, %v", decoded, err)
+	}
+
+	for _, bad := range []map[string]string{{"": "a"}, {"a": ""}, {"a=b": "c"}, {"a": "b;c"}} {
+		if _, err := WithExperiments(context.Background(), bad); err == nil {
+			t.Fatalf("expect %v to be rejected", bad)
+		}
+	}
+	for _, bad := range []string{"a", "a=b;", "=b", "a=b=c"} {
+		if _, err := decodeExperiments(bad); err == nil {
+			t.Fatalf("expect %q not to decode", bad)
+		}
+	}
+}
+
+func TestExperimentsPropagation(t *testing.T) {
+	assignments := map[string]string{"checkout": "b", "banner": "control"}
+	ctx, err := WithExperiments(context.Background(), assignments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hop := 0; hop < 3; hop++ {
+		client, server := upgradedPair(t, nil, nil)
+		ctx = passRequestHeader(t, ctx, client, server)
+		if got := ExperimentsFromContext(ctx); !reflect.DeepEqual(got, assignments) {
+			t.Fatalf("hop %d: expect %v, got %v", hop, assignments, got)
+		}
+		if got := metaFromContext(ctx)[MetaKeyExperiments]; got != "banner=control;checkout=b" {
+			t.Fatalf("hop %d: expect the entry to be propagated unchanged, got %q", hop, got)
+		}
+	}
+
+	client, server := upgradedPair(t, nil, nil)
+	if got := ExperimentsFromContext(passRequestHeader(t, context.Background(), client, server)); got != nil {
+		t.Fatalf("expect no experiments, got %v", got)
+	}
+}
+
+func TestExperimentsSizeCap(t *testing.T) {
+	assignments := make(map[string]string)
+	for i := 0; len(assignments)*len("experiment-000=variant;") <= MaxExperimentsSize; i++ {
+		assignments[fmt.Sprintf("experiment-%03d", i)] = "variant"
+	}
+	if _, err := WithExperiments(context.Background(), assignments); err != ErrExperimentsTooLarge {
+		t.Fatalf("expect ErrExperimentsTooLarge, got %v", err)
+	}
+
+	// An oversized entry from a peer is dropped.
+	_, server := upgradedPair(t, nil, nil)
+	header := tracking.NewRequestHeader()
+	header.Meta = map[string]string{MetaKeyExperiments: "a=" + strings.Repeat("x", MaxExperimentsSize)}
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := header.Write(prot); err != nil {
+		t.Fatal(err)
+	}
+	sctx, err := server.TryReadRequestHeader(prot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ExperimentsFromContext(sctx); got != nil {
+		t.Fatalf("expect an oversized entry to be dropped, got %v", got)
+	}
+}
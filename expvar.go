@@ -0,0 +1,60 @@
+package tracker
+
+import (
+	"expvar"
+)
+
+// The process-wide metrics of the trackers, published through expvar under
+// "thrift_tracker", on /debug/vars once net/http/pprof or expvar's handler is
+// served:
+//
+//   - handshakes: the handshakes that upgraded a connection, either side;
+//   - handshake_failures: the handshakes that failed, either side;
+//   - downgrades: the connections going on without tracking after a
+//     handshake, the server did not support it or rejected it;
+//   - header_bytes_written, header_bytes_read: the size of the request
+//     headers, as serialized by the protocol of the connection;
+//   - meta_truncations: the request headers written with meta dropped to fit
+//     the limits of WithMetaLimits;
+//   - failed_open: the request header errors gone past with WithFailOpen;
+//   - breaker_skips: the handshakes skipped by a HandshakeBreaker;
+//   - live_connections, upgraded_connections: see CurrentConnectionStats.
+var (
+	statHandshakes         expvar.Int
+	statHandshakeFailures  expvar.Int
+	statDowngrades         expvar.Int
+	statHeaderBytesWritten expvar.Int
+	statHeaderBytesRead    expvar.Int
+	statMetaTruncations    expvar.Int
+	statFailedOpen         expvar.Int
+	statBreakerSkips       expvar.Int
+)
+
+func init() {
+	publishStats("thrift_tracker")
+}
+
+// publishStats sets the metrics into the expvar map name. It takes over the
+// map already published under name, by another copy of this package vendored
+// elsewhere for example, where expvar.NewMap would panic. Nothing is
+// published if name holds another kind of var.
+func publishStats(name string) {
+	v := expvar.Get(name)
+	m, ok := v.(*expvar.Map)
+	if !ok {
+		if v != nil {
+			return
+		}
+		m = expvar.NewMap(name)
+	}
+	m.Set("handshakes", &statHandshakes)
+	m.Set("handshake_failures", &statHandshakeFailures)
+	m.Set("downgrades", &statDowngrades)
+	m.Set("header_bytes_written", &statHeaderBytesWritten)
+	m.Set("header_bytes_read", &statHeaderBytesRead)
+	m.Set("meta_truncations", &statMetaTruncations)
+	m.Set("failed_open", &statFailedOpen)
+	m.Set("breaker_skips", &statBreakerSkips)
+	m.Set("live_connections", expvar.Func(func() interface{} { return CurrentConnectionStats().Live }))
+	m.Set("upgraded_connections", expvar.Func(func() interface{} { return CurrentConnectionStats().Upgraded }))
+}
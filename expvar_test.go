@@ -0,0 +1,97 @@
+package tracker
+
+import (
+	"context"
+	"expvar"
+	"strconv"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func expvarSnapshot(t *testing.T) map[string]int64 {
+	t.Helper()
+	m, ok := expvar.Get("thrift_tracker").(*expvar.Map)
+	if !ok {
+		t.Fatal("expect thrift_tracker to be published")
+	}
+	vars := make(map[string]int64)
+	m.Do(func(kv expvar.KeyValue) {
+		n, err := strconv.ParseInt(kv.Value.String(), 10, 64)
+		if err != nil {
+			t.Fatalf("%s: %v", kv.Key, err)
+		}
+		vars[kv.Key] = n
+	})
+	return vars
+}
+
+func TestExpvarStats(t *testing.T) {
+	base := expvarSnapshot(t)
+	delta := func(key string) int64 {
+		return expvarSnapshot(t)[key] - base[key]
+	}
+
+	client, server := upgradedPair(t, nil, nil)
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"k": "v"})
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	size := int64(buf.Len())
+	if _, err := server.TryReadRequestHeader(prot); err != nil {
+		t.Fatal(err)
+	}
+
+	// A downgrade: the server does not support tracking.
+	cprot, sprot := newProtocolPair(t)
+	go func() {
+		_, _, seqID, _ := sprot.ReadMessageBegin()
+		sprot.Skip(thrift.STRUCT)
+		sprot.ReadMessageEnd()
+		writeUpgradeException(seqID, sprot, thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "unknown method"))
+	}()
+	if err := NewSimpleTracker("client").Negotiation(1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	// A failure: the connection is gone.
+	cprot, sprot = newProtocolPair(t)
+	sprot.Transport().Close()
+	if err := NewSimpleTracker("client").Negotiation(1, cprot, cprot); err == nil {
+		t.Fatal("expect the handshake to fail")
+	}
+
+	expect := map[string]int64{
+		"handshakes":           2, // both ends
+		"handshake_failures":   1,
+		"downgrades":           1,
+		"header_bytes_written": size,
+		"header_bytes_read":    size,
+		"upgraded_connections": 2,
+	}
+	for key, n := range expect {
+		if got := delta(key); got != n {
+			t.Fatalf("expect %s to grow by %d, got %d", key, n, got)
+		}
+	}
+	if size == 0 {
+		t.Fatal("expect a header to be written")
+	}
+	client.(*SimpleTracker).Close()
+	server.(*SimpleTracker).Close()
+}
+
+func TestPublishStatsTwice(t *testing.T) {
+	publishStats("thrift_tracker") // must not panic
+	if _, ok := expvarSnapshot(t)["handshakes"]; !ok {
+		t.Fatal("expect the metrics still published")
+	}
+
+	taken := expvar.NewString("thrift_tracker_test_taken")
+	taken.Set("kept")
+	publishStats("thrift_tracker_test_taken")
+	if got := expvar.Get("thrift_tracker_test_taken"); got != taken || taken.Value() != "kept" {
+		t.Fatalf("expect a var of another kind left alone, got %v", got)
+	}
+}
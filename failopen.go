@@ -0,0 +1,37 @@
+package tracker
+
+import (
+	"context"
+
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// failedOpen reports err, a request header failure the tracker went on
+// despite, see WithFailOpen.
+func (t *SimpleTracker) failedOpen(err error) {
+	statFailedOpen.Add(1)
+	if t.onFailOpen != nil {
+		t.onFailOpen(err)
+	}
+}
+
+// failOpenWrite strips header, failing to be built, down to the request ID
+// and seq and returns nil if the tracker fails open, err otherwise.
+func (t *SimpleTracker) failOpenWrite(header *tracking.RequestHeader, err error) error {
+	if !t.failOpen {
+		return err
+	}
+	t.failedOpen(err)
+	header.Meta, header.MetaBlob, header.MetaCodec = nil, nil, nil
+	return nil
+}
+
+// failOpenRead returns ctx, the context of a request header read in full but
+// failing to decode, and nil if the tracker fails open, err otherwise.
+func (t *SimpleTracker) failOpenRead(ctx context.Context, err error) (context.Context, error) {
+	if !t.failOpen {
+		return ctx, err
+	}
+	t.failedOpen(err)
+	return ctx, nil
+}
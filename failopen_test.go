@@ -0,0 +1,94 @@
+package tracker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// writeUndecodableCall writes a request header with a meta blob of a codec
+// unknown to the server, followed by the call to method.
+func writeUndecodableCall(t *testing.T, prot thrift.TProtocol, method string) {
+	t.Helper()
+	header := tracking.NewRequestHeader()
+	header.RequestID, header.Seq = "req", "1.1"
+	header.MetaCodec = thrift.StringPtr("nope")
+	header.MetaBlob = []byte("?")
+	if err := header.Write(prot); err != nil {
+		t.Fatal(err)
+	}
+	if err := prot.WriteMessageBegin(method, thrift.CALL, 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFailOpenRead(t *testing.T) {
+	var reported []error
+	server := NewSimpleTracker("server", WithFailOpen(func(err error) { reported = append(reported, err) }))
+	handshake(t, NewSimpleTracker("client"), server)
+	prot := newMemoryProtocol()
+	writeUndecodableCall(t, prot, "add")
+
+	ctx, err := server.TryReadRequestHeader(prot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), "nope") {
+		t.Fatalf("expect the error reported, got %v", reported)
+	}
+	if ctx.Value(CtxKeyRequestID) != "req" || ctx.Value(CtxKeySequenceID) != "1.1" {
+		t.Fatal("expect the request ID and seq kept")
+	}
+	if meta, ok := ctx.Value(CtxKeyRequestMeta).(map[string]string); ok {
+		t.Fatalf("expect no meta, got %v", meta)
+	}
+	if name, _, seqID, err := prot.ReadMessageBegin(); err != nil || name != "add" || seqID != 2 {
+		t.Fatalf("expect the call right after the header, got %q %d %v", name, seqID, err)
+	}
+
+	closed := NewSimpleTracker("server")
+	handshake(t, NewSimpleTracker("client"), closed)
+	prot = newMemoryProtocol()
+	writeUndecodableCall(t, prot, "add")
+	if _, err := closed.TryReadRequestHeader(prot); err == nil {
+		t.Fatal("expect the error without WithFailOpen")
+	}
+}
+
+func TestFailOpenWrite(t *testing.T) {
+	var reported []error
+	client, server := upgradedPair(t, []Option{
+		WithMetaLimits(8, 0, MetaLimitError),
+		WithFailOpen(func(err error) { reported = append(reported, err) }),
+	}, nil)
+	ctx := WithRequestID(context.Background(), "req")
+	ctx = WithRequestMeta(ctx, "big", strings.Repeat("x", 64))
+	got := passRequestHeader(t, ctx, client, server)
+	if len(reported) != 1 || reported[0] != ErrMetaTooLarge {
+		t.Fatalf("expect ErrMetaTooLarge reported, got %v", reported)
+	}
+	if got.Value(CtxKeyRequestID) != "req" {
+		t.Fatal("expect the request ID written")
+	}
+	if meta, _ := got.Value(CtxKeyRequestMeta).(map[string]string); meta["big"] != "" {
+		t.Fatalf("expect no meta written, got %v", meta)
+	}
+}
+
+func TestFailOpenWireError(t *testing.T) {
+	server := NewSimpleTracker("server", WithFailOpen(nil))
+	handshake(t, NewSimpleTracker("client"), server)
+	prot := newMemoryProtocol()
+	prot.WriteStructBegin("RequestHeader")
+	prot.WriteFieldBegin("request_id", thrift.STRING, 1) // no value follows
+	prot.Flush()
+	if _, err := server.TryReadRequestHeader(prot); err == nil {
+		t.Fatal("expect a truncated header to fail even open")
+	}
+	if !server.(*SimpleTracker).Poisoned() {
+		t.Fatal("expect the connection poisoned")
+	}
+}
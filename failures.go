@@ -0,0 +1,35 @@
+package tracker
+
+import (
+	"sync/atomic"
+)
+
+// FailureChannel carries the errors of failed handshakes, from Negotiation
+// and TryUpgrade, to a monitoring goroutine. Publishing never blocks, errors
+// are dropped and counted while C is full.
+//
+// The channel belongs to whoever creates it, the trackers never close it.
+// Closing it while trackers may still publish panics, close it only after
+// every tracker using it is gone, or leave it to the garbage collector.
+type FailureChannel struct {
+	C       chan error
+	dropped uint64
+}
+
+// NewFailureChannel returns a FailureChannel buffering up to size errors.
+func NewFailureChannel(size int) *FailureChannel {
+	return &FailureChannel{C: make(chan error, size)}
+}
+
+// Dropped returns the number of errors dropped since C was full.
+func (c *FailureChannel) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+func (c *FailureChannel) publish(err error) {
+	select {
+	case c.C <- err:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
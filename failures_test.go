@@ -0,0 +1,33 @@
+package tracker
+
+import (
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func TestFailureChannel(t *testing.T) {
+	failures := NewFailureChannel(1)
+	tracker := NewSimpleTracker("client", WithFailureChannel(failures))
+
+	for i := 0; i < 3; i++ {
+		// Nothing to read, the handshake fails with EOF.
+		iprot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+		oprot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+		if err := tracker.Negotiation(1, iprot, oprot); err == nil {
+			t.Fatal("expect negotiation to fail")
+		}
+	}
+
+	select {
+	case err := <-failures.C:
+		if err == nil {
+			t.Fatal("expect a non-nil error")
+		}
+	default:
+		t.Fatal("expect a failure on the channel")
+	}
+	if n := failures.Dropped(); n != 2 {
+		t.Fatalf("expect 2 dropped failures, got %d", n)
+	}
+}
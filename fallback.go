@@ -0,0 +1,33 @@
+package tracker
+
+import (
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// Passthrough tells whether the handshake completed on the connection
+// without upgrading it: the peer does not support tracking, or failed the
+// handshake under WithNegotiationFallback. The requests go through untouched,
+// no request header is written.
+func (t *SimpleTracker) Passthrough() bool {
+	return t.Negotiated() && !t.RequestHeaderSupported()
+}
+
+// fallback returns err, or nil under WithNegotiationFallback if the server
+// answered the handshake in full, the connection is fine for the requests.
+// The tracker passes them through then.
+func (t *SimpleTracker) fallback(trans thrift.TTransport, err error) error {
+	if !t.negotiationFallback {
+		return err
+	}
+	switch e := err.(type) {
+	case *HandshakeRejectedError:
+	case *NegotiationError:
+		if e.Reason != ReasonPeerException {
+			return err
+		}
+	default:
+		return err
+	}
+	t.setNegotiated(trans)
+	return nil
+}
@@ -0,0 +1,63 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// failingServer answers the handshake with x.
+func failingServer(t *testing.T, x thrift.TApplicationException) (cprot thrift.TProtocol) {
+	cprot, sprot := newProtocolPair(t)
+	go func() {
+		_, _, seqID, _ := sprot.ReadMessageBegin()
+		sprot.Skip(thrift.STRUCT)
+		sprot.ReadMessageEnd()
+		writeUpgradeException(seqID, sprot, x)
+	}()
+	return cprot
+}
+
+func TestNegotiationFallback(t *testing.T) {
+	for name, x := range map[string]thrift.TApplicationException{
+		"rejected":  newHandshakeRejectedException(time.Second),
+		"exception": thrift.NewTApplicationException(thrift.PROTOCOL_ERROR, "bad magic"),
+	} {
+		cprot := failingServer(t, x)
+		client := NewSimpleTracker("client", WithNegotiationFallback()).(*SimpleTracker)
+		if err := client.Negotiation(1, cprot, cprot); err != nil {
+			t.Fatalf("%s: expect a fallback, got %v", name, err)
+		}
+		if !client.Passthrough() || client.RequestHeaderSupported() {
+			t.Fatalf("%s: expect the tracker to pass through", name)
+		}
+		if err := client.Negotiation(2, cprot, cprot); err != nil { // not run again
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		cprot = failingServer(t, x)
+		if err := NewSimpleTracker("client").Negotiation(1, cprot, cprot); err == nil {
+			t.Fatalf("%s: expect an error without fallback", name)
+		}
+	}
+}
+
+func TestNegotiationFallbackTransport(t *testing.T) {
+	cprot, sprot := newProtocolPair(t)
+	sprot.Transport().Close()
+	client := NewSimpleTracker("client", WithNegotiationFallback()).(*SimpleTracker)
+	if err := client.Negotiation(1, cprot, cprot); err == nil {
+		t.Fatal("expect the I/O error to be returned")
+	}
+	if client.Passthrough() {
+		t.Fatal("expect no passthrough over a broken connection")
+	}
+}
+
+func TestPassthroughUpgraded(t *testing.T) {
+	client, _ := upgradedPair(t, nil, nil)
+	if client.(*SimpleTracker).Passthrough() {
+		t.Fatal("expect an upgraded tracker not to pass through")
+	}
+}
@@ -0,0 +1,192 @@
+package tracker
+
+import (
+	"fmt"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// NegotiationState is the state of the client side of a handshake:
+//
+//	Idle     --EventStart-->        Sent      (ActionWriteArgs)
+//	Sent     --EventArgsWritten-->  Awaiting  (ActionReadMessageBegin)
+//	Awaiting --EventMessageBegin--> Awaiting  (ActionReadReply or ActionReadException)
+//	Awaiting --EventReply-->        Done      (ActionUpgrade)
+//	Awaiting --EventException-->    Done      (ActionNone, the peer does not support tracking)
+//
+// Any error, either carried by an event or detected by the FSM, moves it to
+// Failed, as does an event not expected in the current state. Done and Failed
+// are final.
+type NegotiationState int
+
+const (
+	StateIdle NegotiationState = iota
+	StateSent
+	StateAwaiting
+	StateDone
+	StateFailed
+)
+
+func (s NegotiationState) String() string {
+	switch s {
+	case StateIdle:
+		return "Idle"
+	case StateSent:
+		return "Sent"
+	case StateAwaiting:
+		return "Awaiting"
+	case StateDone:
+		return "Done"
+	case StateFailed:
+		return "Failed"
+	}
+	return fmt.Sprintf("NegotiationState(%d)", int(s))
+}
+
+// NegotiationEventKind tells what has happened on the I/O side.
+type NegotiationEventKind int
+
+const (
+	EventStart NegotiationEventKind = iota
+	EventArgsWritten
+	EventMessageBegin
+	EventReply
+	EventException
+)
+
+func (k NegotiationEventKind) String() string {
+	switch k {
+	case EventStart:
+		return "Start"
+	case EventArgsWritten:
+		return "ArgsWritten"
+	case EventMessageBegin:
+		return "MessageBegin"
+	case EventReply:
+		return "Reply"
+	case EventException:
+		return "Exception"
+	}
+	return fmt.Sprintf("NegotiationEventKind(%d)", int(k))
+}
+
+// NegotiationEvent is fed into NegotiationFSM.Step, Err is the error of the
+// I/O the event results from, if any.
+type NegotiationEvent struct {
+	Kind NegotiationEventKind
+	Err  error
+
+	// Set with EventMessageBegin.
+	Method string
+	TypeID thrift.TMessageType
+	SeqID  int32
+	// Set with EventException.
+	Exception thrift.TApplicationException
+}
+
+// NegotiationAction tells the driver of a NegotiationFSM what to do next.
+type NegotiationAction int
+
+const (
+	// ActionNone: nothing left to do, the handshake is over.
+	ActionNone NegotiationAction = iota
+	// ActionWriteArgs: write the upgrade call, feed EventArgsWritten once flushed.
+	ActionWriteArgs
+	// ActionReadMessageBegin: read the message header, feed EventMessageBegin.
+	ActionReadMessageBegin
+	// ActionReadReply: read an UpgradeReply and the message end, feed EventReply.
+	ActionReadReply
+	// ActionReadException: read a TApplicationException and the message end,
+	// feed EventException.
+	ActionReadException
+	// ActionUpgrade: the handshake succeeded, the protocol can be upgraded.
+	ActionUpgrade
+)
+
+// NegotiationFSM is the client side of a handshake as a state machine, for
+// frameworks driving the I/O on their own. Negotiation is built on top of it.
+// The server side has no state to drive: TryUpgrade reads the call, decides
+// and writes the reply in one go, frameworks call it as is.
+type NegotiationFSM struct {
+	seqID  int32
+	state  NegotiationState
+	expect NegotiationEventKind
+	err    error
+}
+
+// NewNegotiationFSM returns a NegotiationFSM in Idle state for the handshake
+// sent with seqID.
+func NewNegotiationFSM(seqID int32) *NegotiationFSM {
+	return &NegotiationFSM{seqID: seqID, state: StateIdle, expect: EventStart}
+}
+
+// State returns the current state.
+func (m *NegotiationFSM) State() NegotiationState {
+	return m.state
+}
+
+// Err returns the error that moved the FSM into Failed.
+func (m *NegotiationFSM) Err() error {
+	return m.err
+}
+
+// Step feeds ev into the FSM and returns the next action, it returns an error
+// once the FSM is Failed, a *NegotiationError.
+func (m *NegotiationFSM) Step(ev NegotiationEvent) (NegotiationAction, error) {
+	if m.state == StateFailed {
+		return ActionNone, m.err
+	}
+	if m.state == StateDone || ev.Kind != m.expect {
+		return m.fail(ReasonUnexpectedEvent, fmt.Errorf("tracker negotiation failed: unexpected event %v in state %v", ev.Kind, m.state))
+	}
+	if ev.Err != nil {
+		return m.fail(ReasonTransport, ev.Err)
+	}
+
+	switch ev.Kind {
+	case EventStart:
+		m.state, m.expect = StateSent, EventArgsWritten
+		return ActionWriteArgs, nil
+	case EventArgsWritten:
+		m.state, m.expect = StateAwaiting, EventMessageBegin
+		return ActionReadMessageBegin, nil
+	case EventMessageBegin:
+		if ev.Method != TrackingAPIName {
+			return m.fail(ReasonWrongMethod, thrift.NewTApplicationException(thrift.WRONG_METHOD_NAME,
+				"tracker negotiation failed: wrong method name"))
+		}
+		if ev.SeqID != m.seqID {
+			return m.fail(ReasonBadSequenceID, thrift.NewTApplicationException(thrift.BAD_SEQUENCE_ID,
+				"tracker negotiation failed: out of sequence response"))
+		}
+		switch ev.TypeID {
+		case thrift.EXCEPTION:
+			m.expect = EventException
+			return ActionReadException, nil
+		case thrift.REPLY:
+			m.expect = EventReply
+			return ActionReadReply, nil
+		}
+		return m.fail(ReasonInvalidMessageType, thrift.NewTApplicationException(thrift.INVALID_MESSAGE_TYPE_EXCEPTION,
+			"tracker negotiation failed: invalid message type"))
+	case EventReply:
+		m.state = StateDone
+		return ActionUpgrade, nil
+	case EventException:
+		if ev.Exception != nil && ev.Exception.TypeId() == thrift.UNKNOWN_METHOD { // server does not support tracker, ignore
+			m.state = StateDone
+			return ActionNone, nil
+		}
+		if ev.Exception == nil {
+			return m.fail(ReasonPeerException, thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION,
+				"Unknown Exception"))
+		}
+		return m.fail(ReasonPeerException, ev.Exception)
+	}
+	return m.fail(ReasonUnexpectedEvent, fmt.Errorf("tracker negotiation failed: unknown event %v", ev.Kind))
+}
+
+func (m *NegotiationFSM) fail(reason NegotiationErrorReason, err error) (NegotiationAction, error) {
+	m.state, m.err = StateFailed, &NegotiationError{Reason: reason, Err: err}
+	return ActionNone, m.err
+}
@@ -0,0 +1,97 @@
+package tracker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func stepFSM(t *testing.T, m *NegotiationFSM, ev NegotiationEvent, action NegotiationAction, state NegotiationState) {
+	t.Helper()
+	got, err := m.Step(ev)
+	if err != nil {
+		t.Fatalf("step %v: %v", ev.Kind, err)
+	}
+	if got != action || m.State() != state {
+		t.Fatalf("step %v: expect action %d in state %v, got action %d in state %v", ev.Kind, action, state, got, m.State())
+	}
+}
+
+func toAwaiting(t *testing.T, seqID int32) *NegotiationFSM {
+	m := NewNegotiationFSM(seqID)
+	if m.State() != StateIdle {
+		t.Fatalf("expect Idle, got %v", m.State())
+	}
+	stepFSM(t, m, NegotiationEvent{Kind: EventStart}, ActionWriteArgs, StateSent)
+	stepFSM(t, m, NegotiationEvent{Kind: EventArgsWritten}, ActionReadMessageBegin, StateAwaiting)
+	return m
+}
+
+func TestNegotiationFSMReply(t *testing.T) {
+	m := toAwaiting(t, 3)
+	stepFSM(t, m, NegotiationEvent{Kind: EventMessageBegin, Method: TrackingAPIName, TypeID: thrift.REPLY, SeqID: 3},
+		ActionReadReply, StateAwaiting)
+	stepFSM(t, m, NegotiationEvent{Kind: EventReply}, ActionUpgrade, StateDone)
+}
+
+func TestNegotiationFSMNotSupported(t *testing.T) {
+	m := toAwaiting(t, 3)
+	stepFSM(t, m, NegotiationEvent{Kind: EventMessageBegin, Method: TrackingAPIName, TypeID: thrift.EXCEPTION, SeqID: 3},
+		ActionReadException, StateAwaiting)
+	stepFSM(t, m, NegotiationEvent{
+		Kind:      EventException,
+		Exception: thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "unknown method"),
+	}, ActionNone, StateDone)
+}
+
+func TestNegotiationFSMFailures(t *testing.T) {
+	cases := map[string]struct {
+		ev     NegotiationEvent
+		reason NegotiationErrorReason
+	}{
+		"wrong method":  {NegotiationEvent{Kind: EventMessageBegin, Method: "other", TypeID: thrift.REPLY, SeqID: 3}, ReasonWrongMethod},
+		"bad sequence":  {NegotiationEvent{Kind: EventMessageBegin, Method: TrackingAPIName, TypeID: thrift.REPLY, SeqID: 4}, ReasonBadSequenceID},
+		"invalid type":  {NegotiationEvent{Kind: EventMessageBegin, Method: TrackingAPIName, TypeID: thrift.CALL, SeqID: 3}, ReasonInvalidMessageType},
+		"I/O error":     {NegotiationEvent{Kind: EventMessageBegin, Err: errors.New("broken pipe")}, ReasonTransport},
+		"out of order":  {NegotiationEvent{Kind: EventReply}, ReasonUnexpectedEvent},
+		"unknown event": {NegotiationEvent{Kind: NegotiationEventKind(42)}, ReasonUnexpectedEvent},
+	}
+	for name, c := range cases {
+		m := toAwaiting(t, 3)
+		_, err := m.Step(c.ev)
+		var nerr *NegotiationError
+		if !errors.As(err, &nerr) || nerr.Reason != c.reason {
+			t.Fatalf("%s: expect a %v error, got %#v", name, c.reason, err)
+		}
+		if nerr.Temporary() != (c.reason == ReasonTransport) {
+			t.Fatalf("%s: unexpected Temporary %v", name, nerr.Temporary())
+		}
+		if m.State() != StateFailed || m.Err() == nil {
+			t.Fatalf("%s: expect Failed with an error, got %v", name, m.State())
+		}
+		// Failed is final.
+		if _, err := m.Step(NegotiationEvent{Kind: EventStart}); err != m.Err() {
+			t.Fatalf("%s: expect the same error once failed, got %v", name, err)
+		}
+	}
+}
+
+func TestNegotiationFSMException(t *testing.T) {
+	m := toAwaiting(t, 3)
+	m.Step(NegotiationEvent{Kind: EventMessageBegin, Method: TrackingAPIName, TypeID: thrift.EXCEPTION, SeqID: 3})
+	x := thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "boom")
+	_, err := m.Step(NegotiationEvent{Kind: EventException, Exception: x})
+	if nerr, ok := err.(*NegotiationError); !ok || nerr.Reason != ReasonPeerException || nerr.Err != x {
+		t.Fatalf("expect the exception to be returned, got %#v", err)
+	}
+}
+
+func TestNegotiationFSMEventNames(t *testing.T) {
+	m := NewNegotiationFSM(1)
+	_, err := m.Step(NegotiationEvent{Kind: EventReply})
+	if err == nil || !strings.Contains(err.Error(), "Reply") || !strings.Contains(err.Error(), "Idle") {
+		t.Fatalf("expect the event and state names in the error, got %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package tracker
+
+import (
+	"context"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// DecodeRequestHeader parses a RequestHeader off the start of data, written
+// with the protocol of protoFactory, for tools working on captured bytes. It
+// returns the header as is, meta blob included, and the number of bytes it
+// takes, the message follows.
+func DecodeRequestHeader(data []byte, protoFactory thrift.TProtocolFactory) (*tracking.RequestHeader, int, error) {
+	buf := thrift.NewTMemoryBufferLen(len(data))
+	buf.Write(data)
+	header := tracking.NewRequestHeader()
+	if err := readRequestHeader(protoFactory.GetProtocol(buf), header, false); err != nil {
+		return nil, 0, err
+	}
+	return header, len(data) - buf.Len(), nil
+}
+
+const (
+	ctxKeyRequestHeader ctxKey = "__thrift_tracking_request_header"
+	ctxKeyPeerAppID     ctxKey = "__thrift_tracking_peer_app_id"
+)
+
+// RequestHeaderFromContext returns the request header of the current request
+// as read off the wire by TryReadRequestHeader, meta blob included, the
+// decoded meta is under CtxKeyRequestMeta. The header is shared, it must not
+// be modified.
+func RequestHeaderFromContext(ctx context.Context) (*tracking.RequestHeader, bool) {
+	header, ok := ctx.Value(ctxKeyRequestHeader).(*tracking.RequestHeader)
+	return header, ok
+}
+
+// PeerAppIDFromContext returns the AppID the client of the current request
+// reported during the handshake, see SimpleTracker.PeerAppID.
+func PeerAppIDFromContext(ctx context.Context) (string, bool) {
+	appID, ok := ctx.Value(ctxKeyPeerAppID).(string)
+	return appID, ok
+}
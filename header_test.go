@@ -0,0 +1,71 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func TestDecodeRequestHeader(t *testing.T) {
+	factories := map[string]thrift.TProtocolFactory{
+		"binary":  thrift.NewTBinaryProtocolFactoryDefault(),
+		"compact": thrift.NewTCompactProtocolFactory(),
+	}
+	for name, factory := range factories {
+		client, _ := upgradedPair(t, nil, nil)
+		ctx := context.WithValue(context.Background(), CtxKeyRequestID, "captured")
+		ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"k": "v"})
+
+		buf := thrift.NewTMemoryBuffer()
+		prot := factory.GetProtocol(buf)
+		if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+			t.Fatal(err)
+		}
+		headerSize := buf.Len()
+		prot.WriteMessageBegin("Ping", thrift.CALL, 1)
+		prot.WriteMessageEnd()
+		prot.Flush()
+
+		header, n, err := DecodeRequestHeader(buf.Bytes(), factory)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n != headerSize {
+			t.Fatalf("%s: expect %d bytes consumed, got %d", name, headerSize, n)
+		}
+		if header.GetRequestID() != "captured" || header.GetSeq() != "1.1" || header.GetMeta()["k"] != "v" {
+			t.Fatalf("%s: unexpected header %v", name, header)
+		}
+	}
+}
+
+func TestDecodeRequestHeaderTruncated(t *testing.T) {
+	client, _ := upgradedPair(t, nil, nil)
+	data := writeRequestHeader(t, client, context.Background())
+	if _, _, err := DecodeRequestHeader(data[:len(data)-1], thrift.NewTBinaryProtocolFactoryDefault()); err == nil {
+		t.Fatal("expect an error decoding a truncated header")
+	}
+}
+
+func TestRequestHeaderFromContext(t *testing.T) {
+	client, server := upgradedPair(t, []Option{WithMetaCodec(JSONMetaCodec)}, nil)
+	ctx := WithRequestID(context.Background(), "req")
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"k": "v"})
+	sctx := passRequestHeader(t, ctx, client, server)
+
+	header, ok := RequestHeaderFromContext(sctx)
+	if !ok || header.GetRequestID() != "req" || header.GetSeq() != "1.1" || header.GetMetaCodec() != JSONMetaCodec.Name() {
+		t.Fatalf("expect the header as read, got %+v", header)
+	}
+	if appID, ok := PeerAppIDFromContext(sctx); !ok || appID != "client" {
+		t.Fatalf("expect the AppID of the client, got %q", appID)
+	}
+
+	if _, ok := RequestHeaderFromContext(context.Background()); ok {
+		t.Fatal("expect no header")
+	}
+	if _, ok := PeerAppIDFromContext(context.Background()); ok {
+		t.Fatal("expect no AppID")
+	}
+}
@@ -0,0 +1,84 @@
+package tracker
+
+import (
+	"sync"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// pooledHeader is a request header to write recycled along with its schema
+// version and meta map, for the header of a call not to be allocated anew.
+type pooledHeader struct {
+	header    tracking.RequestHeader
+	schemaVer int32
+	meta      map[string]string
+}
+
+// maxPooledMeta is the number of meta entries past which a map is dropped
+// rather than recycled, not to keep the memory of an outlier.
+const maxPooledMeta = 64
+
+var headerPool = sync.Pool{
+	New: func() interface{} { return &pooledHeader{meta: make(map[string]string)} },
+}
+
+// getRequestHeader returns an empty header, of the current schema version,
+// with an empty meta map.
+func getRequestHeader() *pooledHeader {
+	p := headerPool.Get().(*pooledHeader)
+	p.schemaVer = HeaderSchemaVersion
+	p.header.SchemaVer = &p.schemaVer
+	p.header.Meta = p.meta
+	return p
+}
+
+// putRequestHeader recycles p once its header is written, nothing may refer
+// to the header nor its meta anymore. Everything the request left in p is
+// cleared first, the next request gets a blank header.
+func putRequestHeader(p *pooledHeader) {
+	p.header = tracking.RequestHeader{}
+	if len(p.meta) > maxPooledMeta {
+		p.meta = make(map[string]string)
+	} else {
+		for k := range p.meta {
+			delete(p.meta, k)
+		}
+	}
+	headerPool.Put(p)
+}
+
+// maxPooledCounting is the size of the buffer past which a counting protocol
+// is dropped rather than recycled.
+const maxPooledCounting = 4 << 10
+
+// countingPool recycles the counting protocols of a binary shadow, which
+// keeps no state between two structs, unlike the compact and JSON ones.
+var countingPool = sync.Pool{
+	New: func() interface{} {
+		buf := thrift.NewTMemoryBuffer()
+		return &countingProtocol{buf: buf, shadow: thrift.NewTBinaryProtocolFactoryDefault().GetProtocol(buf)}
+	},
+}
+
+// getCountingProtocol is newCountingProtocol, recycled for the protocols of a
+// binary shadow, see putCountingProtocol.
+func getCountingProtocol(prot thrift.TProtocol) *countingProtocol {
+	switch prot.(type) {
+	case *thrift.TCompactProtocol, *thrift.TJSONProtocol, *thrift.TSimpleJSONProtocol:
+		return newCountingProtocol(prot)
+	}
+	p := countingPool.Get().(*countingProtocol)
+	p.TProtocol = prot
+	p.pooled = true
+	return p
+}
+
+func putCountingProtocol(p *countingProtocol) {
+	if !p.pooled || p.buf.Cap() > maxPooledCounting {
+		return
+	}
+	p.TProtocol = nil
+	p.buf.Reset()
+	countingPool.Put(p)
+}
@@ -0,0 +1,104 @@
+package tracker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func TestPooledHeaderReset(t *testing.T) {
+	p := getRequestHeader()
+	p.header = tracking.RequestHeader{
+		RequestID: "req",
+		Seq:       "1.1",
+		Meta:      p.meta,
+		MetaBlob:  []byte("blob"),
+		MetaCodec: thrift.StringPtr("json"),
+		SchemaVer: thrift.Int32Ptr(42),
+	}
+	p.meta["k"] = "v"
+	putRequestHeader(p)
+	if len(p.meta) != 0 || !reflect.DeepEqual(p.header, tracking.RequestHeader{}) {
+		t.Fatalf("expect a blank header once put, got %+v", p.header)
+	}
+
+	p = getRequestHeader()
+	defer putRequestHeader(p)
+	if p.header.RequestID != "" || p.header.Seq != "" || len(p.header.Meta) != 0 ||
+		p.header.MetaBlob != nil || p.header.MetaCodec != nil || p.header.GetSchemaVer() != HeaderSchemaVersion {
+		t.Fatalf("expect a blank header, got %+v", p.header)
+	}
+}
+
+// TestPooledHeaderNoLeak alternates the requests of trackers sharing the pool
+// of headers, none may get anything of the one before.
+func TestPooledHeaderNoLeak(t *testing.T) {
+	coded := NewSimpleTracker("coded").(*SimpleTracker)
+	coded.upgradeProtocol(IDFormatOpaque, 0, JSONMetaCodec)
+	plain := NewSimpleTracker("plain").(*SimpleTracker)
+	plain.upgradeProtocol(IDFormatOpaque, 0, nil)
+
+	withMeta := WithRequestMeta(WithRequestID(context.Background(), "req-meta"), "secret", "v")
+	unsampled := WithSampled(WithRequestID(context.Background(), "req-unsampled"), false)
+	bare := WithRequestID(context.Background(), "req-bare")
+	for i := 0; i < 10; i++ {
+		for _, client := range []*SimpleTracker{coded, plain} {
+			for _, ctx := range []context.Context{withMeta, unsampled} {
+				writeRequestHeader(t, client, ctx)
+			}
+			header, _, err := DecodeRequestHeader(writeRequestHeader(t, plain, bare), thrift.NewTBinaryProtocolFactoryDefault())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.RequestID != "req-bare" || header.Seq != "1.1" || header.IsSetMetaBlob() || header.IsSetMetaCodec() {
+				t.Fatalf("expect a header of its own, got %+v", header)
+			}
+			for k := range header.Meta {
+				if !isReservedMetaKey(k) || k == MetaKeySampled {
+					t.Fatalf("expect the reserved meta only, got %v", header.Meta)
+				}
+			}
+		}
+	}
+}
+
+func TestPooledHeaderBeforeWrite(t *testing.T) {
+	var kept []*tracking.RequestHeader
+	client := NewSimpleTracker("client", WithBeforeWriteRequestHeader(func(ctx context.Context, header *tracking.RequestHeader) error {
+		kept = append(kept, header)
+		return nil
+	})).(*SimpleTracker)
+	client.upgradeProtocol(IDFormatOpaque, 0, nil)
+	for _, id := range []string{"req-1", "req-2"} {
+		writeRequestHeader(t, client, WithRequestMeta(WithRequestID(context.Background(), id), "k", id))
+	}
+	for i, id := range []string{"req-1", "req-2"} {
+		if kept[i].RequestID != id || kept[i].Meta["k"] != id {
+			t.Fatalf("expect the headers fn kept intact, got %+v", kept[i])
+		}
+	}
+}
+
+func BenchmarkTryWriteRequestHeader(b *testing.B) {
+	client := NewSimpleTracker("client").(*SimpleTracker)
+	client.upgradeProtocol(IDFormatOpaque, 0, nil)
+	buf := thrift.NewTMemoryBufferLen(1024)
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	for name, ctx := range map[string]context.Context{
+		"no meta": WithRequestID(context.Background(), "req"),
+		"meta":    WithRequestMeta(WithRequestID(context.Background(), "req"), "k", "v"),
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package tracker
+
+import (
+	"context"
+	"strconv"
+)
+
+// MetaKeyHopCount is the reserved meta key carrying the number of servers a
+// request has gone through. Unlike the depth of the sequence ID, which only
+// grows with nested calls made by the same caller, it is bumped on every hop.
+const MetaKeyHopCount = "hop_count"
+
+const ctxKeyHopCount ctxKey = "__thrift_tracking_hop_count"
+
+// HopCountFromContext returns the number of hops the current request has
+// traversed, it is 0 at the edge where no request header has been read.
+func HopCountFromContext(ctx context.Context) int {
+	n, _ := ctx.Value(ctxKeyHopCount).(int)
+	return n
+}
+
+func extractHopCount(ctx context.Context, meta map[string]string) (context.Context, error) {
+	n, err := strconv.Atoi(meta[MetaKeyHopCount])
+	if err != nil || n < 0 { // absent or garbage, count from here
+		n = 0
+	}
+	return context.WithValue(ctx, ctxKeyHopCount, n+1), nil
+}
+
+func injectHopCount(ctx context.Context, meta map[string]string) error {
+	if n := HopCountFromContext(ctx); n > 0 {
+		meta[MetaKeyHopCount] = strconv.Itoa(n)
+	} else { // the edge, do not trust a count set by the user
+		delete(meta, MetaKeyHopCount)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHopCount(t *testing.T) {
+	ctx := context.Background()
+	if n := HopCountFromContext(ctx); n != 0 {
+		t.Fatalf("expect hop count 0 at the edge, got %d", n)
+	}
+	for hop := 1; hop <= 3; hop++ {
+		client, server := upgradedPair(t, nil, nil)
+		ctx = passRequestHeader(t, ctx, client, server)
+		if n := HopCountFromContext(ctx); n != hop {
+			t.Fatalf("expect hop count %d, got %d", hop, n)
+		}
+	}
+}
+
+func TestHopCountNotTrustedAtEdge(t *testing.T) {
+	for _, key := range []string{MetaKeyHopCount, "Hop_Count"} {
+		client, server := upgradedPair(t, nil, nil)
+		ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{key: "100"})
+		ctx = passRequestHeader(t, ctx, client, server)
+		if n := HopCountFromContext(ctx); n != 1 {
+			t.Fatalf("%s: expect hop count 1, got %d", key, n)
+		}
+	}
+}
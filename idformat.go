@@ -0,0 +1,39 @@
+package tracker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IDFormat tells what the request IDs generated by a tracker look like. Peers
+// agree on one during the handshake so that parsers downstream don't break,
+// any disagreement falls back to IDFormatOpaque.
+type IDFormat int32
+
+const (
+	// IDFormatOpaque is a random UUID.
+	IDFormatOpaque IDFormat = 0
+	// IDFormatStructured is "<app id>:<unix millis>:<16 random hex digits>".
+	IDFormatStructured IDFormat = 1
+)
+
+func (f IDFormat) newRequestID(appID string, now time.Time) string {
+	switch f {
+	case IDFormatStructured:
+		random := strings.Replace(uuid.New().String(), "-", "", -1)[:16]
+		return fmt.Sprintf("%s:%d:%s", appID, now.UnixNano()/int64(time.Millisecond), random)
+	default:
+		return uuid.New().String()
+	}
+}
+
+// agreeIDFormat returns the format to use given what the peer offered.
+func agreeIDFormat(local IDFormat, peerSet bool, peer int32) IDFormat {
+	if peerSet && IDFormat(peer) == local {
+		return local
+	}
+	return IDFormatOpaque
+}
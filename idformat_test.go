@@ -0,0 +1,132 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestIDFormatStructured(t *testing.T) {
+	opts := []Option{WithIDFormat(IDFormatStructured)}
+	client, server := upgradedPair(t, opts, opts)
+	for _, tr := range []Tracker{client, server} {
+		if format := tr.(*SimpleTracker).IDFormat(); format != IDFormatStructured {
+			t.Fatalf("expect structured format, got %v", format)
+		}
+	}
+
+	sctx := passRequestHeader(t, context.Background(), client, server)
+	id, _ := sctx.Value(CtxKeyRequestID).(string)
+	parts := strings.Split(id, ":")
+	if len(parts) != 3 || parts[0] != "client" || len(parts[2]) != 16 {
+		t.Fatalf("expect a structured request ID, got %q", id)
+	}
+}
+
+func TestIDFormatFallbackOnMismatch(t *testing.T) {
+	client, server := upgradedPair(t, []Option{WithIDFormat(IDFormatStructured)}, nil)
+	for _, tr := range []Tracker{client, server} {
+		if format := tr.(*SimpleTracker).IDFormat(); format != IDFormatOpaque {
+			t.Fatalf("expect opaque format, got %v", format)
+		}
+	}
+
+	sctx := passRequestHeader(t, context.Background(), client, server)
+	id, _ := sctx.Value(CtxKeyRequestID).(string)
+	if _, err := uuid.Parse(id); err != nil {
+		t.Fatalf("expect an opaque request ID, got %q", id)
+	}
+}
+
+func TestAgreeIDFormat(t *testing.T) {
+	cases := []struct {
+		local   IDFormat
+		peerSet bool
+		peer    int32
+		want    IDFormat
+	}{
+		{IDFormatStructured, true, int32(IDFormatStructured), IDFormatStructured},
+		{IDFormatStructured, true, int32(IDFormatOpaque), IDFormatOpaque},
+		{IDFormatStructured, false, 0, IDFormatOpaque},
+		{IDFormatOpaque, true, int32(IDFormatStructured), IDFormatOpaque},
+		{IDFormatStructured, true, 42, IDFormatOpaque},
+	}
+	for _, c := range cases {
+		if got := agreeIDFormat(c.local, c.peerSet, c.peer); got != c.want {
+			t.Fatalf("agreeIDFormat(%v, %v, %v): expect %v, got %v", c.local, c.peerSet, c.peer, c.want, got)
+		}
+	}
+}
+
+func TestRequestIDGenerator(t *testing.T) {
+	calls := 0
+	slow := func(ctx context.Context) string {
+		calls++
+		time.Sleep(50 * time.Millisecond)
+		return "allocated"
+	}
+	client := NewSimpleTracker("client", WithRequestIDGenerator(slow))
+	if reqID, _ := client.RequestSeqIDFromCtx(context.Background()); reqID != "allocated" {
+		t.Fatalf("expect the generator to be used, got %q", reqID)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	reqID, _ := client.RequestSeqIDFromCtx(ctx)
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Fatalf("expect the fast fallback, took %v", elapsed)
+	}
+	if reqID == "allocated" || reqID == "" || calls != 1 {
+		t.Fatalf("expect a local ID without calling the generator, got %q after %d calls", reqID, calls)
+	}
+
+	ctx = context.WithValue(context.Background(), CtxKeyRequestID, "incoming")
+	if reqID, _ := client.RequestSeqIDFromCtx(ctx); reqID != "incoming" || calls != 1 {
+		t.Fatalf("expect an incoming ID to be kept, got %q", reqID)
+	}
+}
+
+func TestEnsureRequestID(t *testing.T) {
+	generated := 0
+	client, server := upgradedPair(t, []Option{WithRequestIDGenerator(func(context.Context) string {
+		generated++
+		return fmt.Sprintf("gen-%d", generated)
+	})}, nil)
+	ctx, id := client.(*SimpleTracker).EnsureRequestID(context.Background())
+	if id != "gen-1" || ctx.Value(CtxKeyRequestID) != "gen-1" {
+		t.Fatalf("expect a generated ID stored into ctx, got %q", id)
+	}
+	for i := 0; i < 2; i++ { // calls of the same request
+		if reqID := passRequestHeader(t, ctx, client, server).Value(CtxKeyRequestID); reqID != "gen-1" {
+			t.Fatalf("expect every call to share the request ID, got %v", reqID)
+		}
+	}
+	if _, again := client.(*SimpleTracker).EnsureRequestID(ctx); again != "gen-1" || generated != 1 {
+		t.Fatalf("expect the stored ID to be reused, got %q after %d generations", again, generated)
+	}
+
+	ctx = WithRequestID(context.Background(), "set")
+	if reqID := passRequestHeader(t, ctx, client, server).Value(CtxKeyRequestID); reqID != "set" {
+		t.Fatalf("expect the ID set with WithRequestID, got %v", reqID)
+	}
+}
+
+func TestWithRequestIDDeterministic(t *testing.T) {
+	opts := []Option{WithIDFormat(IDFormatStructured), WithRequestIDGenerator(func(context.Context) string { return "gen" })}
+	ctx := WithRequestID(context.Background(), "test-42")
+	for hop := 0; hop < 3; hop++ {
+		client, server := upgradedPair(t, opts, opts)
+		if ctx = passRequestHeader(t, ctx, client, server); ctx.Value(CtxKeyRequestID) != "test-42" {
+			t.Fatalf("hop %d: expect the ID set unchanged, got %v", hop, ctx.Value(CtxKeyRequestID))
+		}
+	}
+	client, server := upgradedPair(t, opts, opts)
+	if reqID := passRequestHeader(t, WithRequestID(context.Background(), ""), client, server).Value(CtxKeyRequestID); reqID != "gen" {
+		t.Fatalf("expect an empty ID to be generated, got %v", reqID)
+	}
+}
@@ -0,0 +1,148 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// RequestID identifies a request across all the hops serving it. It travels
+// as a string, under CtxKeyRequestID too, the type gives it a parser.
+type RequestID string
+
+// ParseRequestID returns s as a RequestID, it must not be empty nor hold
+// control characters, which would break the logs it ends up in.
+func ParseRequestID(s string) (RequestID, error) {
+	if s == "" {
+		return "", errors.New("tracker: empty request ID")
+	}
+	if strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return "", errors.New("tracker: control character in request ID")
+	}
+	return RequestID(s), nil
+}
+
+func (id RequestID) String() string {
+	return string(id)
+}
+
+// Structured splits an ID of IDFormatStructured into the app ID of the
+// tracker which generated it and the time it did, ok is false for the IDs in
+// any other format.
+func (id RequestID) Structured() (appID string, at time.Time, ok bool) {
+	s := string(id)
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 || len(s)-i-1 != 16 {
+		return "", time.Time{}, false
+	}
+	j := strings.LastIndexByte(s[:i], ':')
+	if j < 0 {
+		return "", time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(s[j+1:i], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return s[:j], time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// RequestIDFromContext returns the request ID of the current request, ok is
+// false if there is none.
+func RequestIDFromContext(ctx context.Context) (id RequestID, ok bool) {
+	s, _ := ctx.Value(CtxKeyRequestID).(string)
+	return RequestID(s), s != ""
+}
+
+// Seq is the position of a call in the tree of the calls made for a request:
+// "1" at the edge, "1.2" for the second call it makes, "1.2.1" for the first
+// call made by the server of that one. It travels as a string, under
+// CtxKeySequenceID too.
+//
+// The copies of a Seq share the counter numbering its children, the zero Seq
+// is the root without one: its children are all numbered 1.
+type Seq struct {
+	path  string
+	calls *seqCounter
+}
+
+// RootSeq returns the seq of a request at the edge, "1", numbering its
+// children.
+func RootSeq() Seq {
+	return Seq{path: "1", calls: new(seqCounter)}
+}
+
+// ParseSeq returns the seq of s, positive numbers separated by dots. The
+// children of the seq are numbered from 1.
+func ParseSeq(s string) (Seq, error) {
+	if s == "" {
+		return Seq{}, errors.New("tracker: empty seq")
+	}
+	for _, n := range strings.Split(s, ".") {
+		if v, err := strconv.ParseUint(n, 10, 32); err != nil || v == 0 {
+			return Seq{}, errors.New("tracker: malformed seq " + strconv.Quote(s))
+		}
+	}
+	return Seq{path: s, calls: new(seqCounter)}, nil
+}
+
+// SeqFromContext returns the seq of the current request, its children are
+// numbered with the counter of ctx, see RequestSeqIDFromCtx.
+func SeqFromContext(ctx context.Context) Seq {
+	path, _ := ctx.Value(CtxKeySequenceID).(string)
+	calls, _ := ctx.Value(ctxKeySeqCounter).(*seqCounter)
+	return Seq{path: path, calls: calls}
+}
+
+func (s Seq) String() string {
+	if s.path == "" {
+		return "1"
+	}
+	return s.path
+}
+
+// Child returns the seq of the next call made for s.
+func (s Seq) Child() Seq {
+	child := s.child()
+	child.calls = new(seqCounter)
+	return child
+}
+
+// child is Child without a counter, for the seqs written right away.
+func (s Seq) child() Seq {
+	n := uint32(1)
+	if s.calls != nil {
+		n = atomic.AddUint32(&s.calls.n, 1)
+	}
+	return Seq{path: s.String() + "." + strconv.FormatUint(uint64(n), 10)}
+}
+
+// Parent returns the seq s is a child of, ok is false for a root.
+func (s Seq) Parent() (parent Seq, ok bool) {
+	i := strings.LastIndexByte(s.path, '.')
+	if i < 0 {
+		return Seq{}, false
+	}
+	return Seq{path: s.path[:i], calls: new(seqCounter)}, true
+}
+
+// IsChildOf tells whether s is parent followed by one more number.
+func (s Seq) IsChildOf(parent Seq) bool {
+	p := parent.String()
+	if !strings.HasPrefix(s.path, p+".") {
+		return false
+	}
+	n := s.path[len(p)+1:]
+	if n == "" {
+		return false
+	}
+	for _, c := range n {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseRequestID(t *testing.T) {
+	if id, err := ParseRequestID("req-1"); err != nil || id.String() != "req-1" {
+		t.Fatalf("expect req-1, got %q %v", id, err)
+	}
+	for _, s := range []string{"", "req\n1"} {
+		if _, err := ParseRequestID(s); err == nil {
+			t.Fatalf("expect %q rejected", s)
+		}
+	}
+}
+
+func TestRequestIDStructured(t *testing.T) {
+	now := time.Unix(1500000000, 123000000)
+	id := RequestID(IDFormatStructured.newRequestID("app:v2", now))
+	appID, at, ok := id.Structured()
+	if !ok || appID != "app:v2" || !at.Equal(now) {
+		t.Fatalf("expect app:v2 at %v, got %q %v %v", now, appID, at, ok)
+	}
+	if _, _, ok := RequestID(IDFormatOpaque.newRequestID("app", now)).Structured(); ok {
+		t.Fatal("expect an opaque ID not to be structured")
+	}
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	if _, ok := RequestIDFromContext(WithRequestID(context.Background(), "")); ok {
+		t.Fatal("expect an empty ID to stand for none")
+	}
+	if id, ok := RequestIDFromContext(WithRequestID(context.Background(), "req")); !ok || id != "req" {
+		t.Fatalf("expect req, got %q", id)
+	}
+}
+
+func TestParseSeq(t *testing.T) {
+	for _, s := range []string{"1", "1.2", "1.12.3"} {
+		if seq, err := ParseSeq(s); err != nil || seq.String() != s {
+			t.Fatalf("expect %s, got %v %v", s, seq, err)
+		}
+	}
+	for _, s := range []string{"", "1.", ".1", "1..2", "0", "1.x", "1.+2", "1.-2"} {
+		if _, err := ParseSeq(s); err == nil {
+			t.Fatalf("expect %q rejected", s)
+		}
+	}
+}
+
+func TestSeqChild(t *testing.T) {
+	root := RootSeq()
+	copied := root
+	first, second := root.Child(), copied.Child()
+	if first.String() != "1.1" || second.String() != "1.2" {
+		t.Fatalf("expect the copies to share the counter, got %v %v", first, second)
+	}
+	if grandchild := first.Child(); grandchild.String() != "1.1.1" || !grandchild.IsChildOf(first) {
+		t.Fatalf("expect 1.1.1, got %v", grandchild)
+	}
+	if parent, ok := second.Parent(); !ok || parent.String() != "1" {
+		t.Fatalf("expect 1, got %v %v", parent, ok)
+	}
+	if _, ok := root.Parent(); ok {
+		t.Fatal("expect the root to have no parent")
+	}
+	var zero Seq
+	if zero.Child().String() != "1.1" || zero.Child().String() != "1.1" {
+		t.Fatal("expect the children of the zero seq all numbered 1")
+	}
+	if second.IsChildOf(first) || first.IsChildOf(first) {
+		t.Fatal("expect siblings and self not to be children")
+	}
+}
+
+func TestSeqFromContext(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ctx := passRequestHeader(t, WithRequestID(context.Background(), "req"), client, server)
+	seq := SeqFromContext(ctx)
+	if seq.String() != "1.1" {
+		t.Fatalf("expect 1.1, got %v", seq)
+	}
+	if child := seq.Child(); child.String() != "1.1.1" {
+		t.Fatalf("expect 1.1.1, got %v", child)
+	}
+	// The calls made with ctx go on with the same counter.
+	if _, next := server.RequestSeqIDFromCtx(ctx); next != "1.1.2" {
+		t.Fatalf("expect 1.1.2, got %s", next)
+	}
+}
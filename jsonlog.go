@@ -0,0 +1,147 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// JSONTracker wraps a Tracker to write every handshake and request header
+// read as a JSON object, one per line, to a writer, for a log aggregator:
+//
+//	{"event":"handshake","side":"client","upgraded":true,...}
+//	{"event":"request_header","request_id":"...","seq":"1.1","meta":{...},...}
+//
+// The request headers carry the fields of LogFields and the meta, the ones
+// flagged by WithNoLog are not written, nor are the handshakes skipped by a
+// tracker negotiated already. The trackers of a NewJSONTrackerFactory share
+// their writer, the writes are serialized: JSONTrackers made otherwise must
+// not share a writer unless it is safe for concurrent use.
+type JSONTracker struct {
+	Tracker
+	sink *jsonSink
+}
+
+type jsonSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONTracker returns inner writing its events to w.
+func NewJSONTracker(inner Tracker, w io.Writer) *JSONTracker {
+	return &JSONTracker{Tracker: inner, sink: &jsonSink{enc: json.NewEncoder(w)}}
+}
+
+// NewJSONTrackerFactory wraps the trackers of factory into JSONTrackers
+// writing to w, the trackers of all the connections of a server.
+func NewJSONTrackerFactory(factory func() Tracker, w io.Writer) func() Tracker {
+	sink := &jsonSink{enc: json.NewEncoder(w)}
+	return func() Tracker {
+		return &JSONTracker{Tracker: factory(), sink: sink}
+	}
+}
+
+func (s *jsonSink) write(event map[string]interface{}) {
+	s.mu.Lock()
+	s.enc.Encode(event) // a broken sink must not break the calls
+	s.mu.Unlock()
+}
+
+func (j *JSONTracker) event(name string, fields map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	now := time.Now()
+	if c, ok := j.Tracker.(clocked); ok {
+		now = c.now()
+	}
+	fields["event"] = name
+	fields["time"] = now.UTC().Format(time.RFC3339Nano)
+	return fields
+}
+
+func (j *JSONTracker) handshake(side string, err error) {
+	fields := LogFields(context.Background(), j.Tracker)
+	fields["side"] = side
+	fields["upgraded"] = j.Tracker.RequestHeaderSupported()
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	j.sink.write(j.event("handshake", fields))
+}
+
+func (j *JSONTracker) Negotiation(curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	negotiated := j.negotiated()
+	err := j.Tracker.Negotiation(curSeqID, iprot, oprot)
+	if !negotiated {
+		j.handshake("client", err)
+	}
+	return err
+}
+
+// NegotiationContext calls the NegotiationContext of the inner tracker, or its
+// Negotiation if it is not a ContextHandShaker.
+func (j *JSONTracker) NegotiationContext(ctx context.Context, curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	h, ok := j.Tracker.(ContextHandShaker)
+	if !ok {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return j.Negotiation(curSeqID, iprot, oprot)
+	}
+	negotiated := j.negotiated()
+	err := h.NegotiationContext(ctx, curSeqID, iprot, oprot)
+	if !negotiated {
+		j.handshake("client", err)
+	}
+	return err
+}
+
+// negotiated tells whether the inner tracker skips the handshake, see
+// SimpleTracker.Negotiated.
+func (j *JSONTracker) negotiated() bool {
+	n, ok := j.Tracker.(interface{ Negotiated() bool })
+	return ok && n.Negotiated()
+}
+
+func (j *JSONTracker) TryUpgrade(seqID int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	ok, err := j.Tracker.TryUpgrade(seqID, iprot, oprot)
+	var e error
+	if err != nil {
+		e = err
+	}
+	j.handshake("server", e)
+	return ok, err
+}
+
+func (j *JSONTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
+	ctx, err := j.Tracker.TryReadRequestHeader(iprot)
+	if err != nil {
+		j.sink.write(j.event("request_header", map[string]interface{}{"error": err.Error()}))
+		return ctx, err
+	}
+	if !j.Tracker.RequestHeaderSupported() {
+		return ctx, nil
+	}
+	fields := LogFields(ctx, j.Tracker)
+	if fields == nil { // WithNoLog
+		return ctx, nil
+	}
+	if meta, ok := ctx.Value(CtxKeyRequestMeta).(map[string]string); ok {
+		fields["meta"] = meta
+	}
+	j.sink.write(j.event("request_header", fields))
+	return ctx, nil
+}
+
+// Close closes the inner tracker if it is an io.Closer, SimpleTracker is one.
+func (j *JSONTracker) Close() error {
+	if c, ok := j.Tracker.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package tracker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func decodeEvents(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var events []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("expect a JSON object per line, got %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestJSONTracker(t *testing.T) {
+	var cbuf, buf bytes.Buffer
+	client := NewJSONTracker(NewSimpleTracker("client"), &cbuf)
+	server := NewJSONTracker(NewSimpleTracker("server"), &buf)
+	handshake(t, client, server)
+
+	ctx := WithRequestID(context.Background(), "req")
+	ctx = WithRequestMeta(ctx, "user", "42")
+	passRequestHeader(t, ctx, client, server)
+	passRequestHeader(t, WithNoLog(ctx), client, server)
+
+	events := append(decodeEvents(t, &cbuf), decodeEvents(t, &buf)...)
+	if len(events) != 3 {
+		t.Fatalf("expect 2 handshakes and 1 request header, got %v", events)
+	}
+	sides := map[interface{}]bool{events[0]["side"]: true, events[1]["side"]: true}
+	for _, e := range events[:2] {
+		if e["event"] != "handshake" || e["upgraded"] != true || e["time"] == nil {
+			t.Fatalf("expect an upgrading handshake, got %v", e)
+		}
+	}
+	if !sides["client"] || !sides["server"] {
+		t.Fatalf("expect a handshake of each side, got %v", events[:2])
+	}
+	header := events[2]
+	meta, _ := header["meta"].(map[string]interface{})
+	if header["event"] != "request_header" || header["request_id"] != "req" || header["seq"] != "1.1" ||
+		header["peer_app_id"] != "client" || meta["user"] != "42" {
+		t.Fatalf("expect the request header, got %v", header)
+	}
+}
+
+func TestJSONTrackerFactoryConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	newTracker := NewJSONTrackerFactory(NewSimpleTrackerFactory("server"), &buf)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		client, server := NewSimpleTracker("client"), newTracker()
+		handshake(t, client, server)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				prot := newMemoryProtocol()
+				ctx := WithRequestID(context.Background(), fmt.Sprintf("req-%d-%d", i, j))
+				if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := server.TryReadRequestHeader(prot); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if events := decodeEvents(t, &buf); len(events) != 4+40 {
+		t.Fatalf("expect every event on its own line, got %d", len(events))
+	}
+}
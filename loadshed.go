@@ -0,0 +1,56 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// MetaKeyLoadShedHint is the reserved meta key carrying how close the caller
+// is to shedding load itself, from 0 (healthy) to 1 (its circuit breaker is
+// open), so the callee may shed proactively. It is only propagated a single
+// hop: the hint read by a server is never forwarded to its downstream calls.
+const MetaKeyLoadShedHint = "load_shed_hint"
+
+const (
+	ctxKeyLoadShedHint         ctxKey = "__thrift_tracking_load_shed_hint"
+	ctxKeyOutgoingLoadShedHint ctxKey = "__thrift_tracking_outgoing_load_shed_hint"
+)
+
+// WithLoadShedHint returns a context that attaches hint to the requests made
+// with it, hint must be within [0, 1].
+func WithLoadShedHint(ctx context.Context, hint float64) (context.Context, error) {
+	if !(hint >= 0 && hint <= 1) {
+		return ctx, fmt.Errorf("load shed hint %v out of range [0, 1]", hint)
+	}
+	return context.WithValue(ctx, ctxKeyOutgoingLoadShedHint, hint), nil
+}
+
+// LoadShedHintFromContext returns the hint sent by the caller of the current
+// request, if any.
+func LoadShedHintFromContext(ctx context.Context) (float64, bool) {
+	hint, ok := ctx.Value(ctxKeyLoadShedHint).(float64)
+	return hint, ok
+}
+
+func extractLoadShedHint(ctx context.Context, meta map[string]string) (context.Context, error) {
+	v, ok := meta[MetaKeyLoadShedHint]
+	if !ok {
+		return ctx, nil
+	}
+	hint, err := strconv.ParseFloat(v, 64)
+	if err != nil || !(hint >= 0 && hint <= 1) { // garbage, ignore it
+		return ctx, nil
+	}
+	return context.WithValue(ctx, ctxKeyLoadShedHint, hint), nil
+}
+
+func injectLoadShedHint(ctx context.Context, meta map[string]string) error {
+	hint, ok := ctx.Value(ctxKeyOutgoingLoadShedHint).(float64)
+	if !ok {
+		delete(meta, MetaKeyLoadShedHint) // inherited from the incoming request
+		return nil
+	}
+	meta[MetaKeyLoadShedHint] = strconv.FormatFloat(hint, 'g', -1, 64)
+	return nil
+}
@@ -0,0 +1,48 @@
+package tracker
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestLoadShedHint(t *testing.T) {
+	ctx, err := WithLoadShedHint(context.Background(), 0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := upgradedPair(t, nil, nil)
+	sctx := passRequestHeader(t, ctx, client, server)
+	if hint, ok := LoadShedHintFromContext(sctx); !ok || hint != 0.25 {
+		t.Fatalf("expect hint 0.25, got %v(%v)", hint, ok)
+	}
+
+	// Single hop: not forwarded by the server.
+	client, server = upgradedPair(t, nil, nil)
+	dctx := passRequestHeader(t, sctx, client, server)
+	if hint, ok := LoadShedHintFromContext(dctx); ok {
+		t.Fatalf("expect no hint downstream, got %v", hint)
+	}
+	if _, ok := metaFromContext(dctx)[MetaKeyLoadShedHint]; ok {
+		t.Fatal("expect the hint to be removed from the meta downstream")
+	}
+}
+
+func TestLoadShedHintRange(t *testing.T) {
+	for _, hint := range []float64{0, 0.5, 1} {
+		if _, err := WithLoadShedHint(context.Background(), hint); err != nil {
+			t.Fatalf("expect %v to be valid: %v", hint, err)
+		}
+	}
+	for _, hint := range []float64{-0.1, 1.1, math.NaN(), math.Inf(1)} {
+		if _, err := WithLoadShedHint(context.Background(), hint); err == nil {
+			t.Fatalf("expect %v to be invalid", hint)
+		}
+	}
+	for _, v := range []string{"2", "-1", "NaN", "garbage"} {
+		ctx, _ := extractLoadShedHint(context.Background(), map[string]string{MetaKeyLoadShedHint: v})
+		if hint, ok := LoadShedHintFromContext(ctx); ok {
+			t.Fatalf("expect %q to be ignored, got %v", v, hint)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+)
+
+// MetaKeyLocale is the reserved meta key carrying the locale preferred by the
+// end user, a BCP-47 language tag such as "en-US", propagated unchanged
+// through all the hops.
+const MetaKeyLocale = "locale"
+
+const ctxKeyLocale ctxKey = "__thrift_tracking_locale"
+
+// WithLocale returns a context that attaches locale to the requests made with
+// it, locale must be well-formed BCP-47.
+func WithLocale(ctx context.Context, locale string) (context.Context, error) {
+	if !isLanguageTag(locale) {
+		return ctx, fmt.Errorf("malformed BCP-47 language tag %q", locale)
+	}
+	return context.WithValue(ctx, ctxKeyLocale, locale), nil
+}
+
+// LocaleFromContext returns the locale of the current request, it is empty
+// if none has been set.
+func LocaleFromContext(ctx context.Context) string {
+	locale, _ := ctx.Value(ctxKeyLocale).(string)
+	return locale
+}
+
+// isLanguageTag checks the syntax of a BCP-47 tag loosely: a primary language
+// subtag of 2 to 8 letters, followed by subtags of 1 to 8 letters or digits
+// (a single "x" for the private use ones). Whether the subtags are registered
+// is not checked.
+func isLanguageTag(tag string) bool {
+	n, start := 0, 0
+	for i := 0; i <= len(tag); i++ {
+		if i < len(tag) && tag[i] != '-' {
+			continue
+		}
+		sub := tag[start:i]
+		if len(sub) < 1 || len(sub) > 8 {
+			return false
+		}
+		for j := 0; j < len(sub); j++ {
+			c := sub[j] | 0x20 // lower case letters, leaves digits alone
+			isLetter := c >= 'a' && c <= 'z'
+			isDigit := sub[j] >= '0' && sub[j] <= '9'
+			if !isLetter && !(isDigit && n > 0) {
+				return false
+			}
+		}
+		if n == 0 && len(sub) < 2 && sub != "x" && sub != "X" {
+			return false
+		}
+		n, start = n+1, i+1
+	}
+	return true
+}
+
+func extractLocale(ctx context.Context, meta map[string]string) (context.Context, error) {
+	locale, ok := meta[MetaKeyLocale]
+	if !ok || !isLanguageTag(locale) {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, ctxKeyLocale, locale), nil
+}
+
+func injectLocale(ctx context.Context, meta map[string]string) error {
+	if locale := LocaleFromContext(ctx); locale != "" {
+		meta[MetaKeyLocale] = locale
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLocalePropagation(t *testing.T) {
+	ctx, err := WithLocale(context.Background(), "zh-Hant-TW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hop := 0; hop < 3; hop++ {
+		client, server := upgradedPair(t, nil, nil)
+		ctx = passRequestHeader(t, ctx, client, server)
+		if locale := LocaleFromContext(ctx); locale != "zh-Hant-TW" {
+			t.Fatalf("hop %d: expect the locale unchanged, got %q", hop, locale)
+		}
+	}
+}
+
+func TestLocaleUnset(t *testing.T) {
+	if locale := LocaleFromContext(context.Background()); locale != "" {
+		t.Fatalf("expect no locale, got %q", locale)
+	}
+	client, server := upgradedPair(t, nil, nil)
+	sctx := passRequestHeader(t, context.Background(), client, server)
+	if _, ok := metaFromContext(sctx)[MetaKeyLocale]; ok {
+		t.Fatal("expect no locale in the meta")
+	}
+}
+
+func TestLocaleValidation(t *testing.T) {
+	for _, tag := range []string{"en", "en-US", "zh-Hant-TW", "de-CH-1996", "x-private", "sr-Latn-RS", "es-419"} {
+		if _, err := WithLocale(context.Background(), tag); err != nil {
+			t.Fatalf("expect %q to be valid: %v", tag, err)
+		}
+	}
+	for _, tag := range []string{"", "e", "en-", "-en", "en_US", "1en", "toolongtag", "en--US", "en-US!", "en US"} {
+		if _, err := WithLocale(context.Background(), tag); err == nil {
+			t.Fatalf("expect %q to be invalid", tag)
+		}
+	}
+
+	// A malformed locale from the wire is ignored.
+	ctx, _ := extractLocale(context.Background(), map[string]string{MetaKeyLocale: "en_US"})
+	if locale := LocaleFromContext(ctx); locale != "" {
+		t.Fatalf("expect a malformed locale to be ignored, got %q", locale)
+	}
+}
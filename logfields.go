@@ -0,0 +1,41 @@
+package tracker
+
+import (
+	"context"
+)
+
+// LogFields assembles the tracking information of the current request, as
+// known by ctx and the tracker of the connection, for one structured log line.
+// The request ID, seq and sampling decision are left out when ctx has none. It
+// returns nil for the requests flagged by WithNoLog, not to be logged.
+func LogFields(ctx context.Context, t Tracker) map[string]interface{} {
+	if NoLogFromContext(ctx) {
+		return nil
+	}
+	fields := map[string]interface{}{
+		"hop_count":        HopCountFromContext(ctx),
+		"header_supported": t.RequestHeaderSupported(),
+	}
+	if id, ok := ctx.Value(CtxKeyRequestID).(string); ok {
+		fields["request_id"] = id
+	}
+	if seq, ok := ctx.Value(CtxKeySequenceID).(string); ok {
+		fields["seq"] = seq
+	}
+	if sampled, ok := SampledFromContext(ctx); ok {
+		fields["sampled"] = sampled
+	}
+	if p, ok := t.(interface {
+		PeerAppID() string
+	}); ok {
+		fields["peer_app_id"] = p.PeerAppID()
+	}
+	if n, ok := t.(interface {
+		IDFormat() IDFormat
+		MaxConcurrentStreams() int
+	}); ok {
+		fields["id_format"] = n.IDFormat()
+		fields["max_concurrent"] = n.MaxConcurrentStreams()
+	}
+	return fields
+}
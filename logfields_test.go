@@ -0,0 +1,44 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLogFields(t *testing.T) {
+	opts := []Option{WithIDFormat(IDFormatStructured), WithMaxConcurrentStreams(8)}
+	client, server := upgradedPair(t, opts, opts)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestID, "req")
+	sctx := passRequestHeader(t, ctx, client, server)
+
+	fields := LogFields(sctx, server)
+	want := map[string]interface{}{
+		"request_id":       "req",
+		"seq":              "1.1",
+		"hop_count":        1,
+		"header_supported": true,
+		"peer_app_id":      "client",
+		"id_format":        IDFormatStructured,
+		"max_concurrent":   8,
+	}
+	if len(fields) != len(want) {
+		t.Fatalf("expect %d fields, got %v", len(want), fields)
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Fatalf("expect %s to be %v, got %v", k, v, fields[k])
+		}
+	}
+}
+
+func TestLogFieldsAbsent(t *testing.T) {
+	fields := LogFields(context.Background(), NewSimpleTracker("server"))
+	for _, k := range []string{"request_id", "seq"} {
+		if v, ok := fields[k]; ok {
+			t.Fatalf("expect %s to be left out, got %v", k, v)
+		}
+	}
+	if fields["header_supported"] != false {
+		t.Fatalf("expect header_supported to be false, got %v", fields["header_supported"])
+	}
+}
@@ -0,0 +1,55 @@
+package tracker
+
+import (
+	"fmt"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// TrackingMagic is sent by clients in the handshake for servers to tell a
+// peer speaking another protocol on TrackingAPIName, "TRK1".
+const TrackingMagic int32 = 0x54524b31
+
+// ProtocolMagicError is returned by TryUpgrade when the handshake carries a
+// magic number other than TrackingMagic: the peer speaks another protocol,
+// or another version of it, on the tracking method. The client gets it as a
+// PROTOCOL_ERROR exception with the same message. It is ErrNegotiationFailed.
+type ProtocolMagicError struct {
+	Magic int32
+}
+
+func (e *ProtocolMagicError) Is(target error) bool {
+	return target == ErrNegotiationFailed
+}
+
+func (e *ProtocolMagicError) TypeId() int32 {
+	return thrift.PROTOCOL_ERROR
+}
+
+func (e *ProtocolMagicError) Error() string {
+	return fmt.Sprintf("tracker handshake: protocol magic mismatch, got %#x, want %#x, the peer speaks another protocol",
+		uint32(e.Magic), uint32(TrackingMagic))
+}
+
+// checkMagic validates the magic number of args, clients predating it send
+// none.
+func checkMagic(args *tracking.UpgradeArgs_) error {
+	if args.IsSetMagic() && args.GetMagic() != TrackingMagic {
+		return &ProtocolMagicError{Magic: args.GetMagic()}
+	}
+	return nil
+}
+
+func writeUpgradeException(seqID int32, oprot thrift.TProtocol, x thrift.TApplicationException) error {
+	if err := oprot.WriteMessageBegin(TrackingAPIName, thrift.EXCEPTION, seqID); err != nil {
+		return err
+	}
+	if err := x.Write(oprot); err != nil {
+		return err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return oprot.Flush()
+}
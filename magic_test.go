@@ -0,0 +1,79 @@
+package tracker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// writeArgs writes an upgrade call as a client would, with magic if not nil.
+func writeArgs(prot thrift.TProtocol, magic *int32) {
+	args := tracking.NewUpgradeArgs_()
+	args.AppID = "peer"
+	args.Magic = magic
+	prot.WriteMessageBegin(TrackingAPIName, thrift.CALL, 1)
+	args.Write(prot)
+	prot.WriteMessageEnd()
+	prot.Flush()
+}
+
+func TestHandshakeMagic(t *testing.T) {
+	for name, magic := range map[string]*int32{
+		"current": thrift.Int32Ptr(TrackingMagic),
+		"older":   nil,
+	} {
+		server := NewSimpleTracker("server")
+		cprot, sprot := newProtocolPair(t)
+		go func(magic *int32) {
+			writeArgs(cprot, magic)
+			cprot.ReadMessageBegin()
+			tracking.NewUpgradeReply().Read(cprot)
+			cprot.ReadMessageEnd()
+		}(magic)
+		if err := serveUpgrade(server, sprot); err != nil {
+			t.Fatalf("%s: expect the handshake to proceed, got %v", name, err)
+		}
+		if !server.RequestHeaderSupported() {
+			t.Fatalf("%s: expect the server to upgrade", name)
+		}
+	}
+}
+
+func TestHandshakeMagicMismatch(t *testing.T) {
+	server := NewSimpleTracker("server")
+	cprot, sprot := newProtocolPair(t)
+	go writeArgs(cprot, thrift.Int32Ptr(0x12345678))
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+
+	fsm := NewNegotiationFSM(1)
+	fsm.Step(NegotiationEvent{Kind: EventStart})
+	fsm.Step(NegotiationEvent{Kind: EventArgsWritten})
+	var ev NegotiationEvent
+	ev.Kind = EventMessageBegin
+	ev.Method, ev.TypeID, ev.SeqID, ev.Err = cprot.ReadMessageBegin()
+	fsm.Step(ev)
+	x, err := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, "").Read(cprot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cprot.ReadMessageEnd()
+	if x.TypeId() != thrift.PROTOCOL_ERROR || !strings.Contains(x.Error(), "protocol magic mismatch, got 0x12345678") {
+		t.Fatalf("expect the client to get a magic mismatch, got %v", x)
+	}
+
+	err = <-done
+	merr, ok := err.(*ProtocolMagicError)
+	if !ok || merr.Magic != 0x12345678 {
+		t.Fatalf("expect a ProtocolMagicError, got %#v", err)
+	}
+	if !errors.Is(err, ErrNegotiationFailed) || merr.TypeId() != x.TypeId() {
+		t.Fatalf("expect a negotiation failure of the type sent, got %#v", err)
+	}
+	if server.RequestHeaderSupported() {
+		t.Fatal("expect the server not to upgrade")
+	}
+}
@@ -0,0 +1,211 @@
+package tracker
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// reservedMeta is a meta key owned by the tracker. The value is parsed out of
+// an incoming request header by extract and written into an outgoing one by
+// inject, so it survives the user replacing the meta in the context.
+//
+// Values longer than maxLen, if not 0, are reported as dropped for
+// MetaDropSizeLimit, extract drops or truncates them.
+type reservedMeta struct {
+	key     string
+	extract func(ctx context.Context, meta map[string]string) (context.Context, error)
+	inject  func(ctx context.Context, meta map[string]string) error
+	maxLen  int
+}
+
+var reservedMetas = []reservedMeta{
+	{key: MetaKeyHopCount, extract: extractHopCount, inject: injectHopCount},
+	{key: MetaKeyEntryTimestamp, extract: extractEntryTimestamp, inject: injectEntryTimestamp},
+	{key: MetaKeyLoadShedHint, extract: extractLoadShedHint, inject: injectLoadShedHint},
+	{key: MetaKeyBudget, extract: extractBudget, inject: injectBudget},
+	{key: MetaKeyLocale, extract: extractLocale, inject: injectLocale},
+	{key: MetaKeyTotalDeadline, extract: extractTotalDeadline, inject: injectTotalDeadline},
+	{key: MetaKeyExperiments, extract: extractExperiments, inject: injectExperiments, maxLen: MaxExperimentsSize},
+	{key: MetaKeyShardKey, extract: extractShardKey, inject: injectShardKey},
+	{key: MetaKeyOriginRoute, extract: extractOriginRoute, inject: injectOriginRoute, maxLen: MaxOriginRouteLength},
+	{key: MetaKeyNoLog, extract: extractNoLog, inject: injectNoLog},
+	{key: MetaKeySampled, extract: extractSampled, inject: injectSampled},
+	{key: MetaKeyParentSeq, extract: extractParentSeq, inject: injectParentSeq},
+	{key: MetaKeyAppChain, extract: extractAppChain, inject: injectAppChain},
+	{key: MetaKeyDeadlineBudget, extract: extractDeadlineBudget, inject: injectDeadlineBudget},
+}
+
+// isReservedMetaKey tells whether key is reserved, whatever its case.
+func isReservedMetaKey(key string) bool {
+	for _, m := range reservedMetas {
+		if strings.EqualFold(m.key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func extractReservedMeta(ctx context.Context, meta map[string]string, drops metaDrops) (context.Context, error) {
+	var err error
+	for _, m := range reservedMetas {
+		if v, ok := meta[m.key]; ok && m.maxLen > 0 && len(v) > m.maxLen {
+			drops.add(MetaDropSizeLimit, m.key)
+		}
+		if ctx, err = m.extract(ctx, meta); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func injectReservedMeta(ctx context.Context, meta map[string]string) error {
+	for _, m := range reservedMetas {
+		if err := m.inject(ctx, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithRequestMeta returns a context with key set to value in the meta under
+// CtxKeyRequestMeta, for the calls made with it. The map of ctx is copied,
+// never modified, the contexts derived from ctx concurrently do not share
+// their changes. Any key matching key regardless of case is replaced, see
+// WithCanonicalMetaKeys to lowercase the keys on the wire.
+func WithRequestMeta(ctx context.Context, key, value string) context.Context {
+	old, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+	meta := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		if !strings.EqualFold(k, key) {
+			meta[k] = v
+		}
+	}
+	meta[key] = value
+	return context.WithValue(ctx, CtxKeyRequestMeta, meta)
+}
+
+const ctxKeyInheritedMeta ctxKey = "__thrift_tracking_inherited_meta"
+
+// mergeMeta returns the meta of a call made with ctx: the meta of the incoming
+// request, the baggage, with the meta under CtxKeyRequestMeta set over it, so
+// a handler replacing the meta in the context does not drop the baggage. The
+// meta of the context wins over the baggage, any inherited key matching one of
+// its keys regardless of case is dropped, as with WithRequestMeta. The
+// reserved keys set by the tracker win over both. The result may be one of
+// the maps of ctx.
+func mergeMeta(ctx context.Context) map[string]string {
+	local, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+	inherited, _ := ctx.Value(ctxKeyInheritedMeta).(map[string]string)
+	if len(inherited) == 0 {
+		return local
+	}
+	if len(local) == 0 {
+		return inherited
+	}
+	overridden := make(map[string]struct{}, len(local))
+	for k := range local {
+		overridden[strings.ToLower(k)] = struct{}{}
+	}
+	merged := make(map[string]string, len(inherited)+len(local))
+	for k, v := range inherited {
+		if _, ok := overridden[strings.ToLower(k)]; !ok {
+			merged[k] = v
+		}
+	}
+	for k, v := range local {
+		merged[k] = v
+	}
+	return merged
+}
+
+// MetaTransform rewrites the meta a server propagates to the downstream calls
+// made with the context of an incoming request, the handler itself still sees
+// the meta as it was received. The input is a copy, with the reserved keys
+// already set for the downstream call, and can be modified in place.
+type MetaTransform func(in map[string]string) map[string]string
+
+const ctxKeyMetaTransform ctxKey = "__thrift_tracking_meta_transform"
+
+// metaTransformer is the MetaTransform of a server, carried along with the
+// context of the incoming request until the meta gets written downstream.
+type metaTransformer struct {
+	fn              MetaTransform
+	reservedAllowed bool
+}
+
+// apply runs the transform on a copy of meta. Unless allowed, the reserved
+// keys are kept as they were: restored if removed or modified, and removed
+// if added, so they can not be forged either.
+func (m *metaTransformer) apply(meta map[string]string, drops metaDrops) map[string]string {
+	in := make(map[string]string, len(meta))
+	for k, v := range meta {
+		in[k] = v
+	}
+	out := m.fn(in)
+	for k := range meta {
+		if _, ok := out[k]; !ok && (m.reservedAllowed || !isReservedMetaKey(k)) {
+			drops.add(MetaDropTransform, k)
+		}
+	}
+	if m.reservedAllowed {
+		return out
+	}
+	for k := range out {
+		if _, ok := meta[k]; !ok && isReservedMetaKey(k) {
+			delete(out, k)
+			drops.add(MetaDropTransform, k)
+		}
+	}
+	for k, v := range meta {
+		if !isReservedMetaKey(k) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// canonicalizeMeta returns a copy of meta with the keys in canonical form,
+// when keys collapse into the same one the value of the key already in that
+// form wins. Reserved keys are always matched case insensitively, the other
+// keys are only rewritten under WithCanonicalMetaKeys.
+func (t *SimpleTracker) canonicalizeMeta(meta map[string]string, drops metaDrops) map[string]string {
+	if meta == nil {
+		return nil
+	}
+	out := make(map[string]string, len(meta))
+	var rest []string
+	for k, v := range meta {
+		if t.canonicalMetaKey(k) == k {
+			out[k] = v
+		} else {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		ck := t.canonicalMetaKey(k)
+		if _, ok := out[ck]; !ok {
+			out[ck] = meta[k]
+		} else {
+			drops.add(MetaDropCollision, k)
+		}
+	}
+	return out
+}
+
+func (t *SimpleTracker) canonicalMetaKey(key string) string {
+	for _, m := range reservedMetas {
+		if strings.EqualFold(m.key, key) {
+			return m.key
+		}
+	}
+	if t.canonicalKey == nil {
+		return key
+	}
+	return t.canonicalKey(key)
+}
@@ -0,0 +1,198 @@
+package tracker
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func metaFromContext(ctx context.Context) map[string]string {
+	meta, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+	return meta
+}
+
+// relay passes a request with meta from an edge client to a server, then the
+// request made downstream with the server context to another server.
+func relay(t *testing.T, meta map[string]string, serverOpts ...Option) (handlerCtx, downstreamCtx context.Context) {
+	t.Helper()
+	client, server := upgradedPair(t, nil, serverOpts)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, meta)
+	handlerCtx = passRequestHeader(t, ctx, client, server)
+
+	relayClient, downstream := upgradedPair(t, nil, nil)
+	downstreamCtx = passRequestHeader(t, handlerCtx, relayClient, downstream)
+	return handlerCtx, downstreamCtx
+}
+
+func TestMetaTransformRename(t *testing.T) {
+	rename := WithMetaTransform(func(in map[string]string) map[string]string {
+		if v, ok := in["user"]; ok {
+			delete(in, "user")
+			in["x-user"] = v
+		}
+		return in
+	})
+	handlerCtx, downstreamCtx := relay(t, map[string]string{"user": "u", "other": "o"}, rename)
+
+	if meta := metaFromContext(handlerCtx); meta["user"] != "u" {
+		t.Fatalf("expect the handler to see the meta as received, got %v", meta)
+	}
+	meta := metaFromContext(downstreamCtx)
+	if _, ok := meta["user"]; ok || meta["x-user"] != "u" || meta["other"] != "o" {
+		t.Fatalf("expect user to be renamed downstream, got %v", meta)
+	}
+}
+
+func TestMetaTransformDrop(t *testing.T) {
+	drop := WithMetaTransform(func(in map[string]string) map[string]string {
+		return map[string]string{}
+	})
+	_, downstreamCtx := relay(t, map[string]string{"internal": "i", MetaKeyLocale: "en-US"}, drop)
+
+	meta := metaFromContext(downstreamCtx)
+	if _, ok := meta["internal"]; ok {
+		t.Fatalf("expect internal to be dropped, got %v", meta)
+	}
+	if meta[MetaKeyLocale] != "en-US" {
+		t.Fatalf("expect the reserved keys to be kept, got %v", meta)
+	}
+	if LocaleFromContext(downstreamCtx) != "en-US" {
+		t.Fatal("expect the locale to be propagated")
+	}
+}
+
+func TestMetaTransformCanNotForgeReservedKeys(t *testing.T) {
+	forge := WithMetaTransform(func(in map[string]string) map[string]string {
+		in[MetaKeyBudget] = "1000"
+		in["Hop_Count"] = "100"
+		return in
+	})
+	_, downstreamCtx := relay(t, map[string]string{"k": "v"}, forge)
+
+	if n, ok := BudgetFromContext(downstreamCtx); ok {
+		t.Fatalf("expect no budget, got %d", n)
+	}
+	if n := HopCountFromContext(downstreamCtx); n != 2 {
+		t.Fatalf("expect hop count 2, got %d", n)
+	}
+}
+
+func TestAllowReservedMetaTransform(t *testing.T) {
+	var seen map[string]string
+	drop := WithMetaTransform(func(in map[string]string) map[string]string {
+		seen = in
+		delete(in, MetaKeyLocale)
+		return in
+	})
+	_, downstreamCtx := relay(t, map[string]string{MetaKeyLocale: "en-US"}, drop, AllowReservedMetaTransform())
+
+	if seen == nil {
+		t.Fatal("expect the transform to be called")
+	}
+	if locale := LocaleFromContext(downstreamCtx); locale != "" {
+		t.Fatalf("expect the locale to be dropped, got %q", locale)
+	}
+}
+
+func TestCanonicalMetaKeys(t *testing.T) {
+	tracker := NewSimpleTracker("server", WithCanonicalMetaKeys(nil)).(*SimpleTracker)
+	got := tracker.canonicalizeMeta(map[string]string{"Trace-ID": "a", "trace-id": "b", "TRACE-ID": "c"}, nil)
+	if len(got) != 1 || got["trace-id"] != "b" {
+		t.Fatalf("expect the keys to collapse into trace-id, keeping its value, got %v", got)
+	}
+	got = tracker.canonicalizeMeta(map[string]string{"Trace-ID": "a", "TRACE-ID": "c"}, nil)
+	if len(got) != 1 || got["trace-id"] != "c" { // the first one in sorted order
+		t.Fatalf("expect a deterministic collapse, got %v", got)
+	}
+}
+
+func TestCanonicalMetaKeysRoundTrip(t *testing.T) {
+	upper := WithCanonicalMetaKeys(strings.ToUpper)
+	client, server := upgradedPair(t, []Option{upper}, []Option{WithCanonicalMetaKeys(nil)})
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"Trace-ID": "a", "x": "1"})
+	meta := metaFromContext(passRequestHeader(t, ctx, client, server))
+	if meta["trace-id"] != "a" || meta["x"] != "1" {
+		t.Fatalf("expect lower case keys on the server, got %v", meta)
+	}
+	for k := range meta {
+		if k != strings.ToLower(k) {
+			t.Fatalf("expect no mixed case key, got %v", meta)
+		}
+	}
+}
+
+func TestReservedMetaKeysAlwaysCanonical(t *testing.T) {
+	tracker := NewSimpleTracker("server").(*SimpleTracker)
+	got := tracker.canonicalizeMeta(map[string]string{"Locale": "en", "Trace-ID": "a"}, nil)
+	if got[MetaKeyLocale] != "en" || got["Trace-ID"] != "a" || len(got) != 2 {
+		t.Fatalf("expect only the reserved keys to be canonical, got %v", got)
+	}
+}
+
+func TestMetaBaggageMerge(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"tenant": "a", "user": "u1"})
+	sctx := passRequestHeader(t, ctx, client, server)
+
+	// A handler setting its own meta for the downstream calls.
+	local := context.WithValue(sctx, CtxKeyRequestMeta, map[string]string{"user": "u2", "step": "checkout"})
+	downstream, next := upgradedPair(t, nil, nil)
+	got := metaFromContext(passRequestHeader(t, local, downstream, next))
+	want := map[string]string{"tenant": "a", "user": "u2", "step": "checkout"}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("expect %s=%s, the local meta over the baggage, got %v", k, v, got)
+		}
+	}
+
+	// Untouched, the baggage goes on as is over the next hops.
+	got = metaFromContext(passRequestHeader(t, sctx, downstream, next))
+	if got["tenant"] != "a" || got["user"] != "u1" || got["step"] != "" {
+		t.Fatalf("expect the baggage unchanged, got %v", got)
+	}
+
+	// The reserved keys set by the tracker win over both.
+	forged := context.WithValue(sctx, CtxKeyRequestMeta, map[string]string{MetaKeyHopCount: "99"})
+	if n := HopCountFromContext(passRequestHeader(t, forged, downstream, next)); n != 2 {
+		t.Fatalf("expect the hop count of the tracker, got %d", n)
+	}
+}
+
+func TestWithRequestMeta(t *testing.T) {
+	base := WithRequestMeta(context.Background(), "Trace-Id", "a")
+	left := WithRequestMeta(base, "trace-id", "b")
+	right := WithRequestMeta(base, "user", "c")
+
+	if got := metaFromContext(base); len(got) != 1 || got["Trace-Id"] != "a" {
+		t.Fatalf("expect the parent untouched, got %v", got)
+	}
+	if got := metaFromContext(left); len(got) != 1 || got["trace-id"] != "b" {
+		t.Fatalf("expect the key replaced regardless of case, got %v", got)
+	}
+	if got := metaFromContext(right); len(got) != 2 || got["Trace-Id"] != "a" || got["user"] != "c" {
+		t.Fatalf("expect the siblings not to share changes, got %v", got)
+	}
+
+	client, server := upgradedPair(t, nil, []Option{WithCanonicalMetaKeys(nil)})
+	if got := metaFromContext(passRequestHeader(t, right, client, server)); got["trace-id"] != "a" || got["user"] != "c" {
+		t.Fatalf("expect the meta propagated, got %v", got)
+	}
+}
+
+func TestWithRequestMetaOverridesBaggage(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"Trace-Id": "old", "user": "u"})
+	handlerCtx, err := server.(*SimpleTracker).TryReadRequestHeaderContext(context.Background(),
+		protocolOf(writeRequestHeader(t, client, ctx)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handlerCtx = WithRequestMeta(handlerCtx, "trace-id", "new")
+	relayClient, downstream := upgradedPair(t, nil, nil)
+	meta := nonReservedMeta(metaFromContext(passRequestHeader(t, handlerCtx, relayClient, downstream)))
+	if want := map[string]string{"trace-id": "new", "user": "u"}; !reflect.DeepEqual(meta, want) {
+		t.Fatalf("expect the inherited key overridden regardless of case, got %v", meta)
+	}
+}
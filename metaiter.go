@@ -0,0 +1,228 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// ForEachRequestMeta is TryReadRequestHeader for the handlers only looking
+// for a few meta keys: it calls fn for every meta entry of the request, with
+// the key in canonical form, by key order, until fn returns false. The header
+// is read by TryReadRequestHeader and goes through the same steps, limits,
+// fail-open and propagators included, the context returned is the same.
+func (t *SimpleTracker) ForEachRequestMeta(iprot thrift.TProtocol, fn func(k, v string) bool) (context.Context, error) {
+	if !t.RequestHeaderSupported() {
+		return context.TODO(), nil
+	}
+	ctx, err := t.TryReadRequestHeaderContext(context.Background(), iprot)
+	if err != nil {
+		return ctx, err
+	}
+	meta, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !fn(k, meta[k]) {
+			break
+		}
+	}
+	return ctx, nil
+}
+
+// UnknownHeaderFieldError is returned by trackers with
+// WithStrictRequestHeader reading a request header with a field unknown to
+// this package.
+type UnknownHeaderFieldError struct {
+	FieldID   int16
+	FieldType thrift.TType
+}
+
+func (e *UnknownHeaderFieldError) Error() string {
+	return fmt.Sprintf("RequestHeader has unknown field %d of type %v", e.FieldID, e.FieldType)
+}
+
+// readRequestHeader reads a RequestHeader into header like header.Read does,
+// except that the meta map is read entry by entry, see readMetaEntries.
+// Unknown fields are skipped, or rejected with an UnknownHeaderFieldError if
+// strict: the header is still read to its end, the stream stays usable, but
+// no entry is kept after the rejection.
+func readRequestHeader(iprot thrift.TProtocol, header *tracking.RequestHeader, strict bool) error {
+	var (
+		unknown *UnknownHeaderFieldError
+		visit   func(k, v string)
+	)
+	if _, err := iprot.ReadStructBegin(); err != nil {
+		return thrift.PrependError("RequestHeader read error: ", err)
+	}
+	for {
+		_, fieldTypeId, fieldId, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return thrift.PrependError("RequestHeader field read error: ", err)
+		}
+		if fieldTypeId == thrift.STOP {
+			break
+		}
+		switch fieldId {
+		case 1:
+			err = header.ReadField1(iprot)
+		case 2:
+			err = header.ReadField2(iprot)
+		case 3:
+			if visit == nil {
+				header.Meta = make(map[string]string)
+				visit = func(k, v string) { header.Meta[k] = v }
+			}
+			err = readMetaEntries(iprot, visit)
+		case 4:
+			err = header.ReadField4(iprot)
+		case 5:
+			err = header.ReadField5(iprot)
+		case 6:
+			err = header.ReadField6(iprot)
+		default:
+			if strict && unknown == nil {
+				unknown = &UnknownHeaderFieldError{FieldID: fieldId, FieldType: fieldTypeId}
+				visit = func(k, v string) {}
+			}
+			err = skipValue(iprot, fieldTypeId, thrift.DEFAULT_RECURSION_DEPTH)
+		}
+		if err != nil {
+			return err
+		}
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := iprot.ReadStructEnd(); err != nil {
+		return thrift.PrependError("RequestHeader read struct end error: ", err)
+	}
+	if unknown != nil {
+		return unknown
+	}
+	return nil
+}
+
+// readMetaEntries reads the meta map off iprot, entry by entry: unlike the
+// generated code, no room is made for the size announced, which is checked
+// against the bytes left when the transport knows of them.
+func readMetaEntries(iprot thrift.TProtocol, visit func(k, v string)) error {
+	_, _, size, err := iprot.ReadMapBegin()
+	if err != nil {
+		return thrift.PrependError("error reading map begin: ", err)
+	}
+	if err := checkContainerSize(iprot, size); err != nil {
+		return err
+	}
+	for i := 0; i < size; i++ {
+		k, err := iprot.ReadString()
+		if err != nil {
+			return thrift.PrependError("error reading meta key: ", err)
+		}
+		v, err := iprot.ReadString()
+		if err != nil {
+			return thrift.PrependError("error reading meta value: ", err)
+		}
+		visit(k, v)
+	}
+	if err := iprot.ReadMapEnd(); err != nil {
+		return thrift.PrependError("error reading map end: ", err)
+	}
+	return nil
+}
+
+// checkContainerSize rejects a container announcing more elements than bytes
+// are left, every element takes one at least.
+func checkContainerSize(iprot thrift.TProtocol, size int) error {
+	if remaining := iprot.Transport().RemainingBytes(); remaining != math.MaxUint64 && uint64(size) > remaining {
+		return thrift.NewTProtocolExceptionWithType(thrift.SIZE_LIMIT,
+			fmt.Errorf("container of %d elements exceeds the %d bytes left", size, remaining))
+	}
+	return nil
+}
+
+// skipValue is thrift.Skip for the unknown fields of a request header, which
+// does not stop at the errors of its reads, nor at containers of types taking
+// no bytes: a few bytes would keep it looping for the billions of elements
+// they announce.
+func skipValue(iprot thrift.TProtocol, typ thrift.TType, depth int) error {
+	if depth <= 0 {
+		return thrift.NewTProtocolExceptionWithType(thrift.DEPTH_LIMIT, errors.New("depth limit exceeded"))
+	}
+	switch typ {
+	case thrift.BOOL, thrift.BYTE, thrift.I16, thrift.I32, thrift.I64, thrift.DOUBLE, thrift.STRING:
+		return iprot.Skip(typ)
+	case thrift.STRUCT:
+		if _, err := iprot.ReadStructBegin(); err != nil {
+			return err
+		}
+		for {
+			_, fieldType, _, err := iprot.ReadFieldBegin()
+			if err != nil {
+				return err
+			}
+			if fieldType == thrift.STOP {
+				break
+			}
+			if err := skipValue(iprot, fieldType, depth-1); err != nil {
+				return err
+			}
+			if err := iprot.ReadFieldEnd(); err != nil {
+				return err
+			}
+		}
+		return iprot.ReadStructEnd()
+	case thrift.MAP:
+		keyType, valueType, size, err := iprot.ReadMapBegin()
+		if err != nil {
+			return err
+		}
+		if err := checkContainerSize(iprot, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := skipValue(iprot, keyType, depth-1); err != nil {
+				return err
+			}
+			if err := skipValue(iprot, valueType, depth-1); err != nil {
+				return err
+			}
+		}
+		return iprot.ReadMapEnd()
+	case thrift.SET, thrift.LIST:
+		var (
+			elemType thrift.TType
+			size     int
+			err      error
+		)
+		if typ == thrift.SET {
+			elemType, size, err = iprot.ReadSetBegin()
+		} else {
+			elemType, size, err = iprot.ReadListBegin()
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkContainerSize(iprot, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := skipValue(iprot, elemType, depth-1); err != nil {
+				return err
+			}
+		}
+		if typ == thrift.SET {
+			return iprot.ReadSetEnd()
+		}
+		return iprot.ReadListEnd()
+	}
+	return thrift.NewTProtocolExceptionWithType(thrift.INVALID_DATA, fmt.Errorf("unknown field type %v", typ))
+}
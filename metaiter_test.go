@@ -0,0 +1,348 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func writeRequestHeader(tb testing.TB, client Tracker, ctx context.Context) []byte {
+	buf := thrift.NewTMemoryBuffer()
+	if err := client.TryWriteRequestHeader(ctx, thrift.NewTBinaryProtocolTransport(buf)); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func protocolOf(data []byte) thrift.TProtocol {
+	buf := thrift.NewTMemoryBufferLen(len(data))
+	buf.Write(data)
+	return thrift.NewTBinaryProtocolTransport(buf)
+}
+
+func TestForEachRequestMeta(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestID, "req")
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"a": "1", "b": "2", "Locale": "en-US"})
+	ctx = WithBudget(ctx, 3)
+	data := writeRequestHeader(t, client, ctx)
+
+	prot := protocolOf(data)
+	seen := make(map[string]string)
+	sctx, err := server.(*SimpleTracker).ForEachRequestMeta(prot, func(k, v string) bool {
+		seen[k] = v
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen["a"] != "1" || seen["b"] != "2" || seen[MetaKeyLocale] != "en-US" {
+		t.Fatalf("expect all the entries to be visited, got %v", seen)
+	}
+	if id := sctx.Value(CtxKeyRequestID); id != "req" {
+		t.Fatalf("expect request ID %q, got %v", "req", id)
+	}
+	if n := HopCountFromContext(sctx); n != 1 {
+		t.Fatalf("expect hop count 1, got %d", n)
+	}
+	if n, _ := BudgetFromContext(sctx); n != 2 {
+		t.Fatalf("expect budget 2, got %d", n)
+	}
+	if LocaleFromContext(sctx) != "en-US" {
+		t.Fatal("expect the locale to be extracted")
+	}
+	if prot.Transport().(*thrift.TMemoryBuffer).Len() != 0 {
+		t.Fatal("expect the whole header to be consumed")
+	}
+}
+
+func TestForEachRequestMetaEarlyTermination(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"a": "1", "b": "2", "c": "3"})
+	data := writeRequestHeader(t, client, ctx)
+
+	buf := thrift.NewTMemoryBuffer()
+	buf.Write(data)
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	prot.WriteI32(42)
+
+	calls := 0
+	sctx, err := server.(*SimpleTracker).ForEachRequestMeta(prot, func(k, v string) bool {
+		calls++
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("expect fn to be called once, got %d", calls)
+	}
+	if next, err := prot.ReadI32(); err != nil || next != 42 {
+		t.Fatalf("expect the protocol to stay in sync, got %d, %v", next, err)
+	}
+	if _, ok := EntryTimestampFromContext(sctx); !ok {
+		t.Fatal("expect the reserved meta to be extracted after termination")
+	}
+}
+
+func TestForEachRequestMetaMaxMetaEntries(t *testing.T) {
+	server := NewSimpleTracker("server").(*SimpleTracker)
+	server.setMaxMetaEntries(2)
+	server.upgradeProtocol(IDFormatOpaque, 0, nil)
+	client := NewSimpleTracker("client").(*SimpleTracker) // unaware of the limit
+	client.upgradeProtocol(IDFormatOpaque, 0, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"a": "1", "b": "2", "c": "3"})
+
+	var keys []string
+	sctx, err := server.ForEachRequestMeta(protocolOf(writeRequestHeader(t, client, ctx)), func(k, v string) bool {
+		if !isReservedMetaKey(k) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expect %v visited, got %v", want, keys)
+	}
+	if got := nonReservedMeta(metaFromContext(sctx)); !reflect.DeepEqual(got, map[string]string{"b": "2", "c": "3"}) {
+		t.Fatalf("expect the meta within the limit in the context, got %v", got)
+	}
+}
+
+func TestForEachRequestMetaFailOpen(t *testing.T) {
+	var reported []error
+	server := NewSimpleTracker("server", WithFailOpen(func(err error) { reported = append(reported, err) }))
+	handshake(t, NewSimpleTracker("client"), server)
+	prot := newMemoryProtocol()
+	writeUndecodableCall(t, prot, "add")
+
+	calls := 0
+	ctx, err := server.(*SimpleTracker).ForEachRequestMeta(prot, func(k, v string) bool {
+		calls++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || calls != 0 {
+		t.Fatalf("expect the error reported and no entry visited, got %v and %d calls", reported, calls)
+	}
+	if ctx.Value(CtxKeyRequestID) != "req" {
+		t.Fatal("expect the request ID kept")
+	}
+	if name, _, _, err := prot.ReadMessageBegin(); err != nil || name != "add" {
+		t.Fatalf("expect the call right after the header, got %q %v", name, err)
+	}
+
+	// A failed read poisons the connection for both read paths.
+	prot = newMemoryProtocol()
+	prot.WriteStructBegin("RequestHeader")
+	prot.WriteFieldBegin("request_id", thrift.STRING, 1) // no value follows
+	prot.Flush()
+	if _, err := server.(*SimpleTracker).ForEachRequestMeta(prot, func(k, v string) bool { return true }); !errors.Is(err, ErrHeaderReadFailed) {
+		t.Fatalf("expect a HeaderReadError, got %v", err)
+	}
+	if _, err := server.TryReadRequestHeader(newMemoryProtocol()); err != ErrConnectionPoisoned {
+		t.Fatalf("expect ErrConnectionPoisoned, got %v", err)
+	}
+}
+
+func benchmarkHeader(b *testing.B) (Tracker, []byte) {
+	server := NewSimpleTracker("server").(*SimpleTracker)
+	server.upgradeProtocol(IDFormatOpaque, 0, nil)
+	client := NewSimpleTracker("client").(*SimpleTracker)
+	client.upgradeProtocol(IDFormatOpaque, 0, nil)
+	meta := make(map[string]string)
+	for i := 0; i < 32; i++ {
+		meta[fmt.Sprintf("key-%02d", i)] = fmt.Sprintf("value-%02d", i)
+	}
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, meta)
+	return server, writeRequestHeader(b, client, ctx)
+}
+
+func BenchmarkTryReadRequestHeader(b *testing.B) {
+	server, data := benchmarkHeader(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx, err := server.TryReadRequestHeader(protocolOf(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = metaFromContext(ctx)["key-00"]
+	}
+}
+
+func BenchmarkForEachRequestMeta(b *testing.B) {
+	server, data := benchmarkHeader(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		found := false
+		_, err := server.(*SimpleTracker).ForEachRequestMeta(protocolOf(data), func(k, v string) bool {
+			found = k == "key-00"
+			return !found
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// writeExtendedHeader writes a current RequestHeader followed by a field
+// this package does not know of.
+func writeExtendedHeader(prot thrift.TProtocol) {
+	prot.WriteStructBegin("RequestHeader")
+	prot.WriteFieldBegin("request_id", thrift.STRING, 1)
+	prot.WriteString("extended")
+	prot.WriteFieldEnd()
+	prot.WriteFieldBegin("seq", thrift.STRING, 2)
+	prot.WriteString("1.1")
+	prot.WriteFieldEnd()
+	prot.WriteFieldBegin("meta", thrift.MAP, 3)
+	prot.WriteMapBegin(thrift.STRING, thrift.STRING, 1)
+	prot.WriteString("k")
+	prot.WriteString("v")
+	prot.WriteMapEnd()
+	prot.WriteFieldEnd()
+	prot.WriteFieldBegin("injected", thrift.STRING, 42)
+	prot.WriteString("?")
+	prot.WriteFieldEnd()
+	prot.WriteFieldStop()
+	prot.WriteStructEnd()
+}
+
+func TestStrictRequestHeader(t *testing.T) {
+	_, tolerant := upgradedPair(t, nil, nil)
+	_, strict := upgradedPair(t, nil, []Option{WithStrictRequestHeader()})
+
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	writeExtendedHeader(prot)
+	ctx, err := tolerant.TryReadRequestHeader(prot)
+	if err != nil {
+		t.Fatalf("expect the tolerant tracker to skip the unknown field, got %v", err)
+	}
+	if ctx.Value(CtxKeyRequestID) != "extended" || metaFromContext(ctx)["k"] != "v" {
+		t.Fatalf("unexpected header read: %v, %v", ctx.Value(CtxKeyRequestID), metaFromContext(ctx))
+	}
+
+	read := map[string]func(thrift.TProtocol) error{
+		"TryReadRequestHeader": func(prot thrift.TProtocol) error {
+			_, err := strict.TryReadRequestHeader(prot)
+			return err
+		},
+		"ForEachRequestMeta": func(prot thrift.TProtocol) error {
+			_, err := strict.(*SimpleTracker).ForEachRequestMeta(prot, func(k, v string) bool { return true })
+			return err
+		},
+	}
+	for name, fn := range read {
+		prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+		writeExtendedHeader(prot)
+		err := fn(prot)
+		x, ok := err.(*UnknownHeaderFieldError)
+		if !ok || x.FieldID != 42 || x.FieldType != thrift.STRING {
+			t.Fatalf("%s: expect an UnknownHeaderFieldError for field 42, got %#v", name, err)
+		}
+	}
+
+	client, _ := upgradedPair(t, nil, nil)
+	sctx := passRequestHeader(t, context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"k": "v"}), client, strict)
+	if metaFromContext(sctx)["k"] != "v" {
+		t.Fatalf("expect the strict tracker to read current headers, got %v", metaFromContext(sctx))
+	}
+}
+
+func TestReadRequestHeaderOversizedMeta(t *testing.T) {
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	prot.WriteStructBegin("RequestHeader")
+	prot.WriteFieldBegin("meta", thrift.MAP, 3)
+	prot.WriteMapBegin(thrift.STRING, thrift.STRING, 1<<30)
+	prot.WriteString("k")
+	prot.WriteString("v")
+
+	server := NewSimpleTracker("server").(*SimpleTracker)
+	server.upgradeProtocol(IDFormatOpaque, 0, nil)
+	_, err := server.TryReadRequestHeader(protocolOf(buf.Bytes()))
+	var header *HeaderReadError
+	if !errors.As(err, &header) {
+		t.Fatalf("expect a HeaderReadError, got %v", err)
+	}
+	if e, ok := header.Err.(thrift.TProtocolException); !ok || e.TypeId() != thrift.SIZE_LIMIT {
+		t.Fatalf("expect a size limit error, got %v", header.Err)
+	}
+}
+
+func FuzzTryReadRequestHeader(f *testing.F) {
+	client := NewSimpleTracker("client").(*SimpleTracker)
+	client.upgradeProtocol(IDFormatOpaque, 0, nil)
+	codecClient := NewSimpleTracker("client", WithMetaCodec(JSONMetaCodec)).(*SimpleTracker)
+	codecClient.upgradeProtocol(IDFormatOpaque, 0, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestID, "req")
+	f.Add(writeRequestHeader(f, client, ctx))
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"a": "1", "Locale": "en-US"})
+	ctx = WithBudget(ctx, 3)
+	f.Add(writeRequestHeader(f, client, ctx))
+	f.Add(writeRequestHeader(f, codecClient, ctx))
+	extended := thrift.NewTMemoryBuffer()
+	writeExtendedHeader(thrift.NewTBinaryProtocolTransport(extended))
+	f.Add(extended.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		server := NewSimpleTracker("server").(*SimpleTracker)
+		server.upgradeProtocol(IDFormatOpaque, 0, nil)
+		server.TryReadRequestHeader(protocolOf(data))
+
+		header := tracking.NewRequestHeader()
+		if err := readRequestHeader(protocolOf(data), header, false); err != nil {
+			return
+		}
+		if header.Meta == nil { // the map is written even if absent
+			header.Meta = map[string]string{}
+		}
+		buf := thrift.NewTMemoryBuffer()
+		if err := header.Write(thrift.NewTBinaryProtocolTransport(buf)); err != nil {
+			t.Fatalf("write back %v: %v", header, err)
+		}
+		again := tracking.NewRequestHeader()
+		if err := readRequestHeader(protocolOf(buf.Bytes()), again, false); err != nil {
+			t.Fatalf("read back %v: %v", header, err)
+		}
+		if !reflect.DeepEqual(header, again) {
+			t.Fatalf("expect %v back, got %v", header, again)
+		}
+	})
+}
+
+func TestReadRequestHeaderHostileUnknownField(t *testing.T) {
+	for name, write := range map[string]func(thrift.TProtocol){
+		"void list": func(prot thrift.TProtocol) {
+			prot.WriteFieldBegin("extra", thrift.LIST, 100)
+			prot.WriteListBegin(thrift.VOID, 1<<30)
+		},
+		"void map": func(prot thrift.TProtocol) {
+			prot.WriteFieldBegin("extra", thrift.MAP, 100)
+			prot.WriteMapBegin(thrift.I32, thrift.STOP, 1<<30)
+			prot.WriteI32(1)
+		},
+		"truncated struct": func(prot thrift.TProtocol) {
+			prot.WriteFieldBegin("extra", thrift.STRUCT, 100)
+		},
+	} {
+		buf := thrift.NewTMemoryBuffer()
+		prot := thrift.NewTBinaryProtocolTransport(buf)
+		prot.WriteStructBegin("RequestHeader")
+		write(prot)
+		if err := readRequestHeader(protocolOf(buf.Bytes()), tracking.NewRequestHeader(), false); err == nil {
+			t.Fatalf("%s: expect an error", name)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// MetaLimitPolicy tells what to do with the meta of a request header over the
+// limits of WithMetaLimits.
+type MetaLimitPolicy int
+
+const (
+	// MetaLimitDropOldest drops the meta inherited from the incoming request
+	// first, then the one set for the call, by key order.
+	MetaLimitDropOldest MetaLimitPolicy = iota
+	// MetaLimitDropLargest drops the largest entries first.
+	MetaLimitDropLargest
+	// MetaLimitError fails the call with ErrMetaTooLarge.
+	MetaLimitError
+)
+
+// ErrMetaTooLarge is returned by TryWriteRequestHeader when the meta exceeds
+// the limits of WithMetaLimits with MetaLimitError.
+var ErrMetaTooLarge = errors.New("thrift tracker: request meta too large")
+
+type metaLimits struct {
+	maxBytes int
+	maxKeys  int
+	policy   MetaLimitPolicy
+}
+
+// metaSize returns the number of keys and bytes of meta subject to limits,
+// the reserved keys have limits of their own.
+func metaSize(meta map[string]string) (keys, bytes int) {
+	for k, v := range meta {
+		if !isReservedMetaKey(k) {
+			keys++
+			bytes += len(k) + len(v)
+		}
+	}
+	return keys, bytes
+}
+
+// writeMetaLimits returns the limits of the meta written, those of
+// WithMetaLimits within the number of entries agreed during the handshake,
+// nil for none.
+func (t *SimpleTracker) writeMetaLimits() *metaLimits {
+	n := t.MaxMetaEntries()
+	if n == 0 {
+		return t.metaLimits
+	}
+	l := metaLimits{maxKeys: n}
+	if t.metaLimits != nil {
+		l = *t.metaLimits
+		l.maxKeys = minLimit(l.maxKeys, n)
+	}
+	return &l
+}
+
+func (l *metaLimits) exceeded(keys, bytes int) bool {
+	return (l.maxKeys > 0 && keys > l.maxKeys) || (l.maxBytes > 0 && bytes > l.maxBytes)
+}
+
+// enforce drops entries off meta until it fits the limits, see
+// MetaLimitPolicy.
+func (l *metaLimits) enforce(ctx context.Context, meta map[string]string, drops metaDrops) error {
+	keys, bytes := metaSize(meta)
+	if !l.exceeded(keys, bytes) {
+		return nil
+	}
+	if l.policy == MetaLimitError {
+		return ErrMetaTooLarge
+	}
+	candidates := make([]string, 0, keys)
+	for k := range meta {
+		if !isReservedMetaKey(k) {
+			candidates = append(candidates, k)
+		}
+	}
+	size := func(k string) int { return len(k) + len(meta[k]) }
+	inherited, _ := ctx.Value(ctxKeyInheritedMeta).(map[string]string)
+	oldest := func(k string) bool { // unchanged since the incoming request
+		v, ok := inherited[k]
+		return ok && v == meta[k]
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		switch {
+		case l.policy == MetaLimitDropLargest && size(a) != size(b):
+			return size(a) > size(b)
+		case l.policy == MetaLimitDropOldest && oldest(a) != oldest(b):
+			return oldest(a)
+		}
+		return a < b
+	})
+	for _, k := range candidates {
+		if !l.exceeded(keys, bytes) {
+			break
+		}
+		keys, bytes = keys-1, bytes-size(k)
+		delete(meta, k)
+		drops.add(MetaDropSizeLimit, k)
+	}
+	statMetaTruncations.Add(1)
+	return nil
+}
@@ -0,0 +1,56 @@
+package tracker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMetaLimitsDropLargest(t *testing.T) {
+	var drops []droppedMeta
+	client, _ := upgradedPair(t, []Option{WithMetaLimits(10, 0, MetaLimitDropLargest), observeDrops(&drops)}, nil)
+	ctx := WithBudget(context.Background(), 3)
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"a": "1", "big": "12345678", "c": "1"})
+
+	header := readHeader(t, client, ctx)
+	if _, ok := header.Meta["big"]; ok || header.Meta["a"] != "1" || header.Meta["c"] != "1" {
+		t.Fatalf("expect the largest entry dropped, got %v", header.Meta)
+	}
+	if header.Meta[MetaKeyBudget] != "3" {
+		t.Fatalf("expect the reserved keys kept, got %v", header.Meta)
+	}
+	if want := []droppedMeta{{MetaDropSizeLimit, []string{"big"}}}; !reflect.DeepEqual(drops, want) {
+		t.Fatalf("expect %v reported, got %v", want, drops)
+	}
+}
+
+func TestMetaLimitsDropOldest(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"b": "up", "c": "up"})
+	handlerCtx := passRequestHeader(t, ctx, client, server)
+	// The handler sets a key of its own and changes an inherited one.
+	handlerCtx = context.WithValue(handlerCtx, CtxKeyRequestMeta, map[string]string{"a": "own", "c": "own"})
+
+	relayClient, _ := upgradedPair(t, []Option{WithMetaLimits(0, 2, MetaLimitDropOldest)}, nil)
+	header := readHeader(t, relayClient, handlerCtx)
+	for k := range header.Meta {
+		if isReservedMetaKey(k) {
+			delete(header.Meta, k)
+		}
+	}
+	if want := map[string]string{"a": "own", "c": "own"}; !reflect.DeepEqual(header.Meta, want) {
+		t.Fatalf("expect the inherited entry dropped, got %v", header.Meta)
+	}
+}
+
+func TestMetaLimitsError(t *testing.T) {
+	client, _ := upgradedPair(t, []Option{WithMetaLimits(0, 1, MetaLimitError)}, nil)
+	within := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"a": "1"})
+	if err := client.TryWriteRequestHeader(within, newMemoryProtocol()); err != nil {
+		t.Fatal(err)
+	}
+	over := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"a": "1", "b": "2"})
+	if err := client.TryWriteRequestHeader(over, newMemoryProtocol()); err != ErrMetaTooLarge {
+		t.Fatalf("expect ErrMetaTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package tracker
+
+import (
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// MethodFilter tells whether the calls of method are tracked, health checks
+// and heartbeats are usually not worth it.
+type MethodFilter func(method string) bool
+
+// NewMethodFilterProtocol wraps the output protocol of a client so that only
+// the calls of the methods passing filter carry a request header. The
+// generated client writes the request header before the message header: the
+// protocol holds the header back until the method is known, then writes it,
+// or an empty header if the method is filtered out. The decision is the
+// client's and travels with the call, so the servers agree on it whatever
+// their own filter: an empty header gets them the context they read it with.
+//
+// The handshake, TrackingAPIName, always passes. Nothing is written for
+// connections not upgraded, the tracker writes no header in the first place.
+func NewMethodFilterProtocol(prot thrift.TProtocol, filter MethodFilter) thrift.TProtocol {
+	return &methodFilterProtocol{TProtocol: prot, filter: filter}
+}
+
+// methodFilterProtocol records the writes made before a message header, the
+// request header, to replay them on the wrapped protocol once the method
+// passes the filter. Replaying the calls rather than the bytes keeps the
+// state of stateful protocols, the JSON ones, right.
+//
+// WriteByte is left out since its signature upsets go vet, none of the
+// tracking structs has a byte field anyway.
+type methodFilterProtocol struct {
+	thrift.TProtocol
+	filter    MethodFilter
+	inMessage bool
+	pending   []func(thrift.TProtocol) error
+}
+
+func (p *methodFilterProtocol) WriteMessageBegin(name string, typeID thrift.TMessageType, seqID int32) error {
+	pending := p.pending
+	p.pending, p.inMessage = nil, true
+	if len(pending) > 0 {
+		if name == TrackingAPIName || p.filter(name) {
+			for _, write := range pending {
+				if err := write(p.TProtocol); err != nil {
+					return err
+				}
+			}
+		} else if err := writeUntrackedHeader(p.TProtocol); err != nil {
+			return err
+		}
+	}
+	return p.TProtocol.WriteMessageBegin(name, typeID, seqID)
+}
+
+func (p *methodFilterProtocol) WriteMessageEnd() error {
+	p.inMessage = false
+	return p.TProtocol.WriteMessageEnd()
+}
+
+// hold records write if it comes before a message header, it runs right away
+// otherwise.
+func (p *methodFilterProtocol) hold(write func(thrift.TProtocol) error) error {
+	if p.inMessage {
+		return write(p.TProtocol)
+	}
+	p.pending = append(p.pending, write)
+	return nil
+}
+
+func (p *methodFilterProtocol) WriteStructBegin(name string) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteStructBegin(name) })
+}
+
+func (p *methodFilterProtocol) WriteStructEnd() error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteStructEnd() })
+}
+
+func (p *methodFilterProtocol) WriteFieldBegin(name string, typeID thrift.TType, id int16) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteFieldBegin(name, typeID, id) })
+}
+
+func (p *methodFilterProtocol) WriteFieldEnd() error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteFieldEnd() })
+}
+
+func (p *methodFilterProtocol) WriteFieldStop() error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteFieldStop() })
+}
+
+func (p *methodFilterProtocol) WriteMapBegin(keyType, valueType thrift.TType, size int) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteMapBegin(keyType, valueType, size) })
+}
+
+func (p *methodFilterProtocol) WriteMapEnd() error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteMapEnd() })
+}
+
+func (p *methodFilterProtocol) WriteListBegin(elemType thrift.TType, size int) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteListBegin(elemType, size) })
+}
+
+func (p *methodFilterProtocol) WriteListEnd() error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteListEnd() })
+}
+
+func (p *methodFilterProtocol) WriteSetBegin(elemType thrift.TType, size int) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteSetBegin(elemType, size) })
+}
+
+func (p *methodFilterProtocol) WriteSetEnd() error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteSetEnd() })
+}
+
+func (p *methodFilterProtocol) WriteBool(value bool) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteBool(value) })
+}
+
+func (p *methodFilterProtocol) WriteI16(value int16) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteI16(value) })
+}
+
+func (p *methodFilterProtocol) WriteI32(value int32) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteI32(value) })
+}
+
+func (p *methodFilterProtocol) WriteI64(value int64) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteI64(value) })
+}
+
+func (p *methodFilterProtocol) WriteDouble(value float64) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteDouble(value) })
+}
+
+func (p *methodFilterProtocol) WriteString(value string) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteString(value) })
+}
+
+func (p *methodFilterProtocol) WriteBinary(value []byte) error {
+	return p.hold(func(prot thrift.TProtocol) error { return prot.WriteBinary(value) })
+}
+
+// writeUntrackedHeader writes a request header without any field, which a
+// tracker never writes: the seq of a call is never empty.
+func writeUntrackedHeader(prot thrift.TProtocol) error {
+	if err := prot.WriteStructBegin("RequestHeader"); err != nil {
+		return err
+	}
+	if err := prot.WriteFieldStop(); err != nil {
+		return err
+	}
+	return prot.WriteStructEnd()
+}
+
+func isUntrackedHeader(header *tracking.RequestHeader) bool {
+	return header.RequestID == "" && header.Seq == "" && len(header.Meta) == 0 &&
+		!header.IsSetMetaBlob() && !header.IsSetMetaCodec() && !header.IsSetSchemaVer()
+}
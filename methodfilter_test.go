@@ -0,0 +1,107 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func skipPing(method string) bool { return method != "ping" }
+
+func writeFilteredCall(t *testing.T, client Tracker, ctx context.Context, method string) *thrift.TMemoryBuffer {
+	t.Helper()
+	buf := thrift.NewTMemoryBuffer()
+	prot := NewMethodFilterProtocol(thrift.NewTBinaryProtocolTransport(buf), skipPing)
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	prot.WriteMessageBegin(method, thrift.CALL, 1)
+	prot.WriteStructBegin("args")
+	prot.WriteFieldBegin("a", thrift.I32, 1)
+	prot.WriteI32(42)
+	prot.WriteFieldEnd()
+	prot.WriteFieldStop()
+	prot.WriteStructEnd()
+	prot.WriteMessageEnd()
+	prot.Flush()
+	return buf
+}
+
+func TestMethodFilterProtocol(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ctx := WithRequestID(context.Background(), "req")
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"k": "v"})
+
+	for method, tracked := range map[string]bool{"add": true, "ping": false} {
+		buf := writeFilteredCall(t, client, ctx, method)
+		prot := thrift.NewTBinaryProtocolTransport(buf)
+		base := context.WithValue(context.Background(), ctxKey("base"), true)
+		sctx, err := server.(*SimpleTracker).TryReadRequestHeaderContext(base, prot)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if got := sctx.Value(CtxKeyRequestID) == "req"; got != tracked {
+			t.Fatalf("%s: expect tracked %v, got request ID %v", method, tracked, sctx.Value(CtxKeyRequestID))
+		}
+		if !tracked && sctx != base {
+			t.Fatalf("%s: expect the base context back", method)
+		}
+		var inner stockProcessor
+		if _, err := inner.Process(prot, nil); err != nil || inner.method != method {
+			t.Fatalf("%s: expect the call to follow, got %q %v", method, inner.method, err)
+		}
+	}
+
+	// An untracked header takes a couple of bytes.
+	if tracked, untracked := writeFilteredCall(t, client, ctx, "add").Len(), writeFilteredCall(t, client, ctx, "ping").Len(); untracked >= tracked {
+		t.Fatalf("expect the untracked call to be smaller, got %d >= %d", untracked, tracked)
+	}
+}
+
+func TestMethodFilterProtocolNotUpgraded(t *testing.T) {
+	withFilter := writeFilteredCall(t, NewSimpleTracker("client"), context.Background(), "ping").Bytes()
+	without := writeFilteredCall(t, NewSimpleTracker("client"), context.Background(), "add").Bytes()
+	if string(withFilter[:4]) != string(without[:4]) || withFilter[0] != 0x80 {
+		t.Fatalf("expect the message header first, got %x", withFilter)
+	}
+}
+
+func TestMethodFilterProtocolHandshake(t *testing.T) {
+	client, server := NewSimpleTracker("client"), NewSimpleTracker("server")
+	cprot, sprot := newProtocolPair(t)
+	cprot = NewMethodFilterProtocol(cprot, func(string) bool { return false })
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+	if err := client.Negotiation(1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !client.RequestHeaderSupported() || !server.RequestHeaderSupported() {
+		t.Fatal("expect the handshake to pass the filter")
+	}
+}
+
+func TestTrackedProcessorMethodFilter(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	inner := &stockContextProcessor{}
+	processor := NewTrackedProcessor(server, inner)
+	processor.MethodFilter = skipPing
+	var requested []string
+	processor.OnRequest = func(ctx context.Context, method string) { requested = append(requested, method) }
+
+	ctx := WithRequestID(context.Background(), "req")
+	for _, method := range []string{"ping", "add"} {
+		if _, err := processor.Process(writeCall(t, client, ctx, method, 1), newMemoryProtocol()); err != nil {
+			t.Fatal(err)
+		}
+		if tracked := inner.ctx.Value(CtxKeyRequestID) == "req"; tracked != (method == "add") {
+			t.Fatalf("%s: unexpected request ID %v", method, inner.ctx.Value(CtxKeyRequestID))
+		}
+	}
+	if len(requested) != 1 || requested[0] != "add" {
+		t.Fatalf("expect OnRequest for add only, got %v", requested)
+	}
+}
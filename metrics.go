@@ -0,0 +1,44 @@
+package tracker
+
+// Metrics receives the outcome of the handshakes and the size of the request
+// headers of a tracker, to export them to a monitoring system, see
+// WithMetrics. The methods are called inline, they must be cheap and safe for
+// concurrent use.
+type Metrics interface {
+	// IncHandshake counts a handshake, either side. A handshake leaving the
+	// connection without tracking does not succeed. The handshake negotiates
+	// no version: negotiatedVersion is HeaderSchemaVersion, the schema of the
+	// request headers this package writes, on success, 0 otherwise.
+	IncHandshake(success bool, negotiatedVersion int32)
+	// ObserveHeaderBytes observes the serialized size of a request header
+	// written.
+	ObserveHeaderBytes(n int)
+}
+
+func (t *SimpleTracker) observeHandshake(success bool) {
+	if t.metrics == nil {
+		return
+	}
+	if success {
+		t.metrics.IncHandshake(true, HeaderSchemaVersion)
+	} else {
+		t.metrics.IncHandshake(false, 0)
+	}
+}
+
+// recordHandshake counts the outcome of a handshake, either side, once it
+// returned err: a failure if err is set, otherwise an upgrade, or a downgrade
+// if the connection goes on without tracking.
+func (t *SimpleTracker) recordHandshake(err error) {
+	switch {
+	case err != nil:
+		statHandshakeFailures.Add(1)
+		t.observeHandshake(false)
+	case t.RequestHeaderSupported():
+		statHandshakes.Add(1)
+		t.observeHandshake(true)
+	default:
+		statDowngrades.Add(1)
+		t.observeHandshake(false)
+	}
+}
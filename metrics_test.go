@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+type recordingMetrics struct {
+	mu          sync.Mutex
+	handshakes  map[bool]int
+	versions    []int32
+	headerBytes []int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{handshakes: make(map[bool]int)}
+}
+
+func (m *recordingMetrics) IncHandshake(success bool, negotiatedVersion int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handshakes[success]++
+	m.versions = append(m.versions, negotiatedVersion)
+}
+
+func (m *recordingMetrics) ObserveHeaderBytes(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.headerBytes = append(m.headerBytes, n)
+}
+
+func TestMetrics(t *testing.T) {
+	clientMetrics, serverMetrics := newRecordingMetrics(), newRecordingMetrics()
+	client, _ := upgradedPair(t, []Option{WithMetrics(clientMetrics)}, []Option{WithMetrics(serverMetrics)})
+	for _, m := range []*recordingMetrics{clientMetrics, serverMetrics} {
+		if m.handshakes[true] != 1 || m.handshakes[false] != 0 || m.versions[0] != HeaderSchemaVersion {
+			t.Fatalf("expect a successful handshake, got %v %v", m.handshakes, m.versions)
+		}
+	}
+
+	buf := thrift.NewTMemoryBuffer()
+	if err := client.TryWriteRequestHeader(context.Background(), thrift.NewTBinaryProtocolTransport(buf)); err != nil {
+		t.Fatal(err)
+	}
+	if len(clientMetrics.headerBytes) != 1 || clientMetrics.headerBytes[0] != buf.Len() {
+		t.Fatalf("expect %d header bytes, got %v", buf.Len(), clientMetrics.headerBytes)
+	}
+
+	// A server rejecting the handshake.
+	rejected := newRecordingMetrics()
+	reject := AdmissionFunc(func(string) (bool, time.Duration) { return false, time.Second })
+	cprot, sprot := newProtocolPair(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUpgrade(NewSimpleTracker("server", WithMetrics(rejected), WithAdmissionController(reject)), sprot)
+	}()
+	failed := newRecordingMetrics()
+	if err := NewSimpleTracker("client", WithMetrics(failed)).Negotiation(1, cprot, cprot); err == nil {
+		t.Fatal("expect the handshake to be rejected")
+	}
+	<-done
+	if rejected.handshakes[false] != 1 || failed.handshakes[false] != 1 || failed.versions[0] != 0 {
+		t.Fatalf("expect a failed handshake on both sides, got %v %v", rejected.handshakes, failed.handshakes)
+	}
+}
+
+// unflushable fails to send what is written to it.
+type unflushable struct {
+	*thrift.TMemoryBuffer
+}
+
+func (unflushable) Flush() error {
+	return errors.New("connection reset")
+}
+
+func TestMetricsHandshakeFailures(t *testing.T) {
+	base := expvarSnapshot(t)
+
+	// A reply echoing another token.
+	failed := newRecordingMetrics()
+	client := NewSimpleTracker("client", WithMetrics(failed)).(*SimpleTracker)
+	cprot, sprot := newProtocolPair(t)
+	go func() {
+		_, _, seqID, _ := sprot.ReadMessageBegin()
+		tracking.NewUpgradeArgs_().Read(sprot)
+		sprot.ReadMessageEnd()
+		reply := tracking.NewUpgradeReply()
+		reply.Echo = thrift.StringPtr("pong")
+		sprot.WriteMessageBegin(TrackingAPIName, thrift.REPLY, seqID)
+		reply.Write(sprot)
+		sprot.WriteMessageEnd()
+		sprot.Flush()
+	}()
+	if err := client.NegotiateEcho(1, cprot, cprot, "ping"); err == nil {
+		t.Fatal("expect an echo mismatch")
+	}
+	if failed.handshakes[false] != 1 || failed.handshakes[true] != 0 {
+		t.Fatalf("expect a failed handshake, got %v", failed.handshakes)
+	}
+
+	// A reply the server fails to send.
+	rejected := newRecordingMetrics()
+	server := NewSimpleTracker("server", WithMetrics(rejected))
+	iprot := newMemoryProtocol()
+	writeArgs(iprot, thrift.Int32Ptr(TrackingMagic))
+	iprot.ReadMessageBegin()
+	oprot := thrift.NewTBinaryProtocolTransport(unflushable{thrift.NewTMemoryBuffer()})
+	if _, err := server.TryUpgrade(1, iprot, oprot); err == nil {
+		t.Fatal("expect the reply to fail")
+	}
+	if rejected.handshakes[false] != 1 || rejected.handshakes[true] != 0 || server.RequestHeaderSupported() {
+		t.Fatalf("expect a failed handshake, got %v", rejected.handshakes)
+	}
+
+	if got := expvarSnapshot(t)["handshake_failures"] - base["handshake_failures"]; got != 2 {
+		t.Fatalf("expect 2 more handshake_failures, got %d", got)
+	}
+}
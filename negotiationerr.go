@@ -0,0 +1,135 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// ErrNegotiationFailed is matched by errors.Is against the errors of a failed
+// handshake, a NegotiationError, a HandshakeRejectedError or a
+// ProtocolMagicError, whatever their reason.
+var ErrNegotiationFailed = errors.New("tracker: negotiation failed")
+
+// NegotiationErrorReason tells why a handshake failed.
+type NegotiationErrorReason int
+
+const (
+	// ReasonTransport: the I/O failed, the connection may be fine again later.
+	ReasonTransport NegotiationErrorReason = iota
+	// ReasonWrongMethod: the reply is not the one of the handshake.
+	ReasonWrongMethod
+	// ReasonBadSequenceID: the reply is not the one of this handshake.
+	ReasonBadSequenceID
+	// ReasonInvalidMessageType: the reply is neither a REPLY nor an EXCEPTION.
+	ReasonInvalidMessageType
+	// ReasonPeerException: the server failed the handshake with an exception.
+	ReasonPeerException
+	// ReasonUnexpectedEvent: the driver of a NegotiationFSM fed it an event
+	// out of order.
+	ReasonUnexpectedEvent
+	// ReasonEchoMismatch: the echo of NegotiateEcho did not come back as is.
+	ReasonEchoMismatch
+	// ReasonAborted: NegotiationContext gave up.
+	ReasonAborted
+	// ReasonInvalidReply: the reply agrees on something the client never
+	// offered.
+	ReasonInvalidReply
+)
+
+func (r NegotiationErrorReason) String() string {
+	switch r {
+	case ReasonTransport:
+		return "Transport"
+	case ReasonWrongMethod:
+		return "WrongMethod"
+	case ReasonBadSequenceID:
+		return "BadSequenceID"
+	case ReasonInvalidMessageType:
+		return "InvalidMessageType"
+	case ReasonPeerException:
+		return "PeerException"
+	case ReasonUnexpectedEvent:
+		return "UnexpectedEvent"
+	case ReasonEchoMismatch:
+		return "EchoMismatch"
+	case ReasonAborted:
+		return "Aborted"
+	case ReasonInvalidReply:
+		return "InvalidReply"
+	}
+	return fmt.Sprintf("NegotiationErrorReason(%d)", int(r))
+}
+
+// NegotiationError is the error of a failed handshake, Err is the underlying
+// error, a thrift.TApplicationException for most reasons. A server without
+// tracking fails no handshake: the negotiation succeeds, without upgrading,
+// see RequestHeaderSupported. A server rejecting the handshake fails it with
+// a HandshakeRejectedError instead.
+//
+// A NegotiationError is a thrift.TException, classified by TypeId like a
+// thrift.TApplicationException, and unwraps to Err: errors.As gets the
+// exception or the protocol error behind it.
+type NegotiationError struct {
+	Reason NegotiationErrorReason
+	Err    error
+}
+
+func (e *NegotiationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NegotiationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NegotiationError) Is(target error) bool {
+	return target == ErrNegotiationFailed
+}
+
+// TypeId returns the type of the exception the server failed the handshake
+// with, the one matching Reason for the errors of the client.
+func (e *NegotiationError) TypeId() int32 {
+	var x thrift.TApplicationException
+	if errors.As(e.Err, &x) {
+		return x.TypeId()
+	}
+	switch e.Reason {
+	case ReasonWrongMethod:
+		return thrift.WRONG_METHOD_NAME
+	case ReasonBadSequenceID:
+		return thrift.BAD_SEQUENCE_ID
+	case ReasonInvalidMessageType:
+		return thrift.INVALID_MESSAGE_TYPE_EXCEPTION
+	case ReasonEchoMismatch, ReasonInvalidReply:
+		return thrift.PROTOCOL_ERROR
+	}
+	return thrift.UNKNOWN_APPLICATION_EXCEPTION
+}
+
+// checkReply makes sure reply only agrees on what the client offered: the ID
+// format of the tracker or the opaque one, one of its meta codecs. A server
+// going past it is buggy, or not speaking the same protocol, nothing it
+// agreed on can be trusted. The limits of concurrent streams and of meta
+// entries are only ever lowered by the reply, see minLimit, there is nothing
+// to check.
+func (t *SimpleTracker) checkReply(reply *tracking.UpgradeReply) error {
+	if f := IDFormat(reply.GetIDFormat()); reply.IsSetIDFormat() && f != IDFormatOpaque && f != t.idFormat {
+		return &NegotiationError{Reason: ReasonInvalidReply,
+			Err: fmt.Errorf("tracker negotiation failed: reply picked the ID format %d, offered %d", f, t.idFormat)}
+	}
+	if name := reply.GetMetaCodec(); reply.IsSetMetaCodec() && lookupMetaCodec(t.metaCodecs, name) == nil &&
+		name != ThriftMetaCodec.Name() {
+		return &NegotiationError{Reason: ReasonInvalidReply,
+			Err: fmt.Errorf("tracker negotiation failed: reply picked the meta codec %q, offered %v", name, metaCodecNames(t.metaCodecs))}
+	}
+	return nil
+}
+
+// Temporary tells whether negotiating again may succeed, over the same
+// connection or a new one.
+func (e *NegotiationError) Temporary() bool {
+	return e.Reason == ReasonTransport || e.Reason == ReasonAborted
+}
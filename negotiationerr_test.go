@@ -0,0 +1,90 @@
+package tracker
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// overclaimingServer replies to the handshake with reply, whatever the
+// client offered.
+func overclaimingServer(t *testing.T, reply *tracking.UpgradeReply) thrift.TProtocol {
+	cprot, sprot := newProtocolPair(t)
+	go func() {
+		_, _, seqID, _ := sprot.ReadMessageBegin()
+		sprot.Skip(thrift.STRUCT)
+		sprot.ReadMessageEnd()
+		sprot.WriteMessageBegin(TrackingAPIName, thrift.REPLY, seqID)
+		reply.Write(sprot)
+		sprot.WriteMessageEnd()
+		sprot.Flush()
+	}()
+	return cprot
+}
+
+func TestNegotiationOverclaimingReply(t *testing.T) {
+	for name, reply := range map[string]*tracking.UpgradeReply{
+		"id format":  {IDFormat: thrift.Int32Ptr(int32(IDFormatStructured))},
+		"meta codec": {MetaCodec: thrift.StringPtr(CBORMetaCodec.Name())},
+	} {
+		client := NewSimpleTracker("client", WithMetaCodecs(JSONMetaCodec))
+		prot := overclaimingServer(t, reply)
+		err := client.Negotiation(1, prot, prot)
+		var nerr *NegotiationError
+		if !errors.As(err, &nerr) || nerr.Reason != ReasonInvalidReply || nerr.Temporary() {
+			t.Fatalf("%s: expect an InvalidReply error, got %v", name, err)
+		}
+		if client.RequestHeaderSupported() {
+			t.Fatalf("%s: expect the connection not upgraded", name)
+		}
+	}
+
+	// What was offered, or the defaults, are fine.
+	for name, reply := range map[string]*tracking.UpgradeReply{
+		"offered": {IDFormat: thrift.Int32Ptr(int32(IDFormatStructured)), MetaCodec: thrift.StringPtr(JSONMetaCodec.Name())},
+		"default": {IDFormat: thrift.Int32Ptr(int32(IDFormatOpaque)), MetaCodec: thrift.StringPtr(ThriftMetaCodec.Name())},
+	} {
+		client := NewSimpleTracker("client", WithIDFormat(IDFormatStructured), WithMetaCodecs(JSONMetaCodec))
+		prot := overclaimingServer(t, reply)
+		if err := client.Negotiation(1, prot, prot); err != nil || !client.RequestHeaderSupported() {
+			t.Fatalf("%s: expect the connection upgraded, got %v", name, err)
+		}
+	}
+}
+
+func TestNegotiationErrorClassification(t *testing.T) {
+	prot := overclaimingServer(t, &tracking.UpgradeReply{IDFormat: thrift.Int32Ptr(int32(IDFormatStructured))})
+	err := NewSimpleTracker("client").Negotiation(1, prot, prot)
+	if !errors.Is(err, ErrNegotiationFailed) {
+		t.Fatalf("expect ErrNegotiationFailed, got %v", err)
+	}
+	if x, ok := err.(interface{ TypeId() int32 }); !ok || x.TypeId() != thrift.PROTOCOL_ERROR {
+		t.Fatalf("expect a PROTOCOL_ERROR, got %#v", err)
+	}
+
+	prot = failingServer(t, thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "boom"))
+	err = NewSimpleTracker("client").Negotiation(1, prot, prot)
+	var x thrift.TApplicationException
+	if !errors.Is(err, ErrNegotiationFailed) || !errors.As(err, &x) || x.TypeId() != thrift.INTERNAL_ERROR || x.Error() != "boom" {
+		t.Fatalf("expect the exception of the server, got %#v", err)
+	}
+	if nerr := err.(*NegotiationError); nerr.TypeId() != thrift.INTERNAL_ERROR {
+		t.Fatalf("expect the type of the exception, got %d", nerr.TypeId())
+	}
+
+	c, s := net.Pipe()
+	s.Close()
+	defer c.Close()
+	prot = thrift.NewTBinaryProtocolTransport(thrift.NewTSocketFromConnTimeout(c, 0))
+	err = NewSimpleTracker("client").Negotiation(1, prot, prot)
+	var pe thrift.TProtocolException
+	if !errors.Is(err, ErrNegotiationFailed) || !errors.As(err, &pe) {
+		t.Fatalf("expect the error of the protocol, got %#v", err)
+	}
+	if errors.Is(err, ErrHeaderReadFailed) {
+		t.Fatal("expect a negotiation error only")
+	}
+}
@@ -0,0 +1,42 @@
+package tracker
+
+import (
+	"context"
+)
+
+// MetaKeyNoLog is the reserved meta key flagging a privacy-sensitive
+// request, a data subject access request for example, which must not be
+// logged verbosely by any hop. It is propagated unchanged through all the
+// hops once set.
+const MetaKeyNoLog = "no_log"
+
+const ctxKeyNoLog ctxKey = "__thrift_tracking_no_log"
+
+// WithNoLog returns a context flagging the requests made with it as not to be
+// logged. LogFields returns nothing for them and RecordingTracker does not
+// record them, handlers check NoLogFromContext before logging on their own.
+func WithNoLog(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyNoLog, true)
+}
+
+// NoLogFromContext tells whether the current request must not be logged.
+func NoLogFromContext(ctx context.Context) bool {
+	noLog, _ := ctx.Value(ctxKeyNoLog).(bool)
+	return noLog
+}
+
+func extractNoLog(ctx context.Context, meta map[string]string) (context.Context, error) {
+	if meta[MetaKeyNoLog] == "1" {
+		ctx = WithNoLog(ctx)
+	}
+	return ctx, nil
+}
+
+func injectNoLog(ctx context.Context, meta map[string]string) error {
+	if NoLogFromContext(ctx) {
+		meta[MetaKeyNoLog] = "1"
+	} else {
+		delete(meta, MetaKeyNoLog)
+	}
+	return nil
+}
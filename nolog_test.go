@@ -0,0 +1,43 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNoLogPropagation(t *testing.T) {
+	ctx := WithNoLog(context.Background())
+	for hop := 0; hop < 3; hop++ {
+		client, server := upgradedPair(t, nil, nil)
+		ctx = passRequestHeader(t, ctx, client, server)
+		if !NoLogFromContext(ctx) {
+			t.Fatalf("hop %d: expect the no_log flag to be propagated", hop)
+		}
+	}
+
+	client, server := upgradedPair(t, nil, nil)
+	forged := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{MetaKeyNoLog: "1"})
+	if NoLogFromContext(passRequestHeader(t, forged, client, server)) || NoLogFromContext(passRequestHeader(t, context.Background(), client, server)) {
+		t.Fatal("expect no no_log flag unless set with WithNoLog")
+	}
+}
+
+func TestNoLogSuppression(t *testing.T) {
+	client, inner := upgradedPair(t, nil, nil)
+	server := NewRecordingTracker(inner, 4)
+
+	logged := passRequestHeader(t, WithRequestID(context.Background(), "logged"), client, server)
+	secret := passRequestHeader(t, WithNoLog(WithRequestID(context.Background(), "secret")), client, server)
+	if LogFields(logged, server) == nil {
+		t.Fatal("expect log fields for a regular request")
+	}
+	if fields := LogFields(secret, server); fields != nil {
+		t.Fatalf("expect no log fields for a no_log request, got %v", fields)
+	}
+
+	server.Finish(logged)
+	server.Finish(secret)
+	if recent := server.Recent(); len(recent) != 1 || recent[0].RequestID != "logged" {
+		t.Fatalf("expect only the regular request to be recorded, got %+v", recent)
+	}
+}
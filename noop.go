@@ -0,0 +1,64 @@
+package tracker
+
+import (
+	"context"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// NoopTracker is a Tracker with tracking turned off: it never negotiates nor
+// reads or writes a request header.
+type NoopTracker struct{}
+
+var _ Tracker = NoopTracker{}
+
+// NewNoopTrackerFactory is a NewTrackerFactoryFunc of NoopTrackers, to swap
+// tracking off without touching the call sites.
+func NewNoopTrackerFactory(name string) func() Tracker {
+	return func() Tracker {
+		return NoopTracker{}
+	}
+}
+
+// Negotiation does nothing, the connection goes on without tracking.
+func (NoopTracker) Negotiation(curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	return nil
+}
+
+// TryUpgrade answers the handshake of a client as a server unaware of
+// tracking does, with an UNKNOWN_METHOD exception: the call has been read
+// already, leaving it unanswered would stall the client, which goes on
+// without tracking then.
+func (NoopTracker) TryUpgrade(seqID int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	if err := iprot.Skip(thrift.STRUCT); err != nil {
+		return false, err
+	}
+	if err := iprot.ReadMessageEnd(); err != nil {
+		return false, err
+	}
+	x := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function "+TrackingAPIName)
+	if err := writeUpgradeException(seqID, oprot, x); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (NoopTracker) RequestHeaderSupported() bool {
+	return false
+}
+
+// RequestSeqIDFromCtx returns the request ID and seq ctx carries, empty if it
+// carries none, for the logs to keep correlating requests.
+func (NoopTracker) RequestSeqIDFromCtx(ctx context.Context) (string, string) {
+	reqID, _ := ctx.Value(CtxKeyRequestID).(string)
+	seq, _ := ctx.Value(CtxKeySequenceID).(string)
+	return reqID, seq
+}
+
+func (NoopTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
+	return context.Background(), nil
+}
+
+func (NoopTracker) TryWriteRequestHeader(ctx context.Context, oprot thrift.TProtocol) error {
+	return nil
+}
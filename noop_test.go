@@ -0,0 +1,48 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func TestNoopTracker(t *testing.T) {
+	var newTracker NewTrackerFactoryFunc = NewNoopTrackerFactory
+	noop := newTracker("noop")()
+
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	if err := noop.Negotiation(1, prot, prot); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithRequestID(context.Background(), "req")
+	if err := noop.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 || noop.RequestHeaderSupported() {
+		t.Fatal("expect nothing on the wire")
+	}
+	if reqID, seq := noop.RequestSeqIDFromCtx(ctx); reqID != "req" || seq != "" {
+		t.Fatalf("expect the request ID of the context, got %q %q", reqID, seq)
+	}
+	if reqID, _ := noop.RequestSeqIDFromCtx(context.Background()); reqID != "" {
+		t.Fatalf("expect no request ID, got %q", reqID)
+	}
+}
+
+func TestNoopTrackerServer(t *testing.T) {
+	cprot, sprot := newProtocolPair(t)
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(NoopTracker{}, sprot) }()
+	client := NewSimpleTracker("client")
+	if err := client.Negotiation(1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if client.RequestHeaderSupported() {
+		t.Fatal("expect the client to go on without tracking")
+	}
+}
@@ -0,0 +1,347 @@
+package tracker
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// Option configures a SimpleTracker.
+type Option func(*SimpleTracker)
+
+// WithHandshakeSizeObserver registers fn to be called after a successful
+// Negotiation with the serialized size, in bytes, of the UpgradeArgs_ written
+// and the UpgradeReply read.
+func WithHandshakeSizeObserver(fn func(argsSize, replySize int)) Option {
+	return func(t *SimpleTracker) {
+		t.onHandshakeSize = fn
+	}
+}
+
+// WithMetaTransform makes the server side apply fn to the meta of incoming
+// requests when it gets propagated downstream. Reserved keys can not be
+// modified, removed or added by fn unless AllowReservedMetaTransform is also
+// given.
+func WithMetaTransform(fn MetaTransform) Option {
+	return func(t *SimpleTracker) {
+		t.metaTransform = fn
+	}
+}
+
+// AllowReservedMetaTransform lets the MetaTransform rename, drop or set the
+// keys reserved by the tracker.
+func AllowReservedMetaTransform() Option {
+	return func(t *SimpleTracker) {
+		t.reservedMetaTransformAllowed = true
+	}
+}
+
+// WithIDFormat sets the request ID format the tracker offers during the
+// handshake, it is IDFormatOpaque by default.
+func WithIDFormat(format IDFormat) Option {
+	return func(t *SimpleTracker) {
+		t.idFormat = format
+	}
+}
+
+// WithMetaCodec sets the codec used to write the meta of request headers.
+func WithMetaCodec(codec MetaCodec) Option {
+	return func(t *SimpleTracker) {
+		t.metaCodec = codec
+	}
+}
+
+// WithMetaCodecs sets the codecs the tracker supports for the meta of request
+// headers, in order of priority, to agree on one during the handshake: the
+// client advertises them, the server picks the first of its own the client
+// supports, or the first of the client known to it if it has none. Both sides
+// fall back to ThriftMetaCodec without a codec in common, as they do with a
+// peer unaware of the negotiation. It takes precedence over WithMetaCodec.
+func WithMetaCodecs(codecs ...MetaCodec) Option {
+	return func(t *SimpleTracker) {
+		t.metaCodecs = codecs
+	}
+}
+
+// WithNegotiationWatchdog makes Negotiation call fn once a handshake has been
+// in flight longer than threshold, a hint of a half-open connection or a
+// flaky peer. fn runs in its own goroutine while Negotiation keeps waiting,
+// so it is called even if the reply eventually arrives. A threshold of 0 or
+// less disables the watchdog.
+//
+// The watchdog is client side only, TryUpgrade is called once the upgrade
+// call has arrived and does not wait on the peer but to write the reply.
+func WithNegotiationWatchdog(threshold time.Duration, fn func(elapsed time.Duration)) Option {
+	return func(t *SimpleTracker) {
+		if threshold <= 0 {
+			t.watchdogThreshold, t.onNegotiationStuck = 0, nil
+			return
+		}
+		t.watchdogThreshold = threshold
+		t.onNegotiationStuck = fn
+	}
+}
+
+// WithCanonicalMetaKeys rewrites the meta keys with fn on both write and read,
+// so keys like "Trace-ID" and "trace-id" from different clients match. A nil
+// fn lowercases the keys.
+func WithCanonicalMetaKeys(fn func(key string) string) Option {
+	if fn == nil {
+		fn = strings.ToLower
+	}
+	return func(t *SimpleTracker) {
+		t.canonicalKey = fn
+	}
+}
+
+// WithMaxConcurrentStreams advertises the number of concurrent in-flight
+// requests supported on a connection during the handshake, both sides agree
+// on the smaller one. It is unlimited by default, as is n <= 0, n is capped to
+// math.MaxInt32, what the handshake can carry.
+func WithMaxConcurrentStreams(n int) Option {
+	if n < 0 {
+		n = 0
+	} else if n > math.MaxInt32 {
+		n = math.MaxInt32
+	}
+	return func(t *SimpleTracker) {
+		t.localMaxConcurrent = n
+	}
+}
+
+// WithMaxMetaEntries advertises the number of meta entries a request header
+// may carry during the handshake, both sides agree on the smaller one and
+// hold the connection to it: the client drops the entries past it on write,
+// following the policy of WithMetaLimits, the server on read, by key order,
+// for the clients unaware of the limit. The reserved keys do not count. It is
+// unlimited by default, as is n <= 0, n is capped to math.MaxInt32.
+func WithMaxMetaEntries(n int) Option {
+	if n < 0 {
+		n = 0
+	} else if n > math.MaxInt32 {
+		n = math.MaxInt32
+	}
+	return func(t *SimpleTracker) {
+		t.localMaxMetaEntries = n
+	}
+}
+
+// WithHandshakeDedup makes the server side share the handshake decisions
+// through d, see HandshakeDedup.
+func WithHandshakeDedup(d *HandshakeDedup) Option {
+	return func(t *SimpleTracker) {
+		t.handshakeDedup = d
+	}
+}
+
+// WithHandshakeBreaker makes the client side skip the handshake with the
+// servers b is open for, see HandshakeBreaker. NegotiateEcho always runs it,
+// its outcome is not recorded.
+func WithHandshakeBreaker(b *HandshakeBreaker) Option {
+	return func(t *SimpleTracker) {
+		t.breaker = b
+	}
+}
+
+// WithFailureChannel publishes the errors of failed handshakes onto c.
+func WithFailureChannel(c *FailureChannel) Option {
+	return func(t *SimpleTracker) {
+		t.failures = c
+	}
+}
+
+// WithAppIDTransform makes Negotiation report fn(name) as the app ID of the
+// client, to tag it with its environment for example. The name of the tracker
+// itself, used for the request IDs, is unchanged.
+func WithAppIDTransform(fn func(appID string) string) Option {
+	return func(t *SimpleTracker) {
+		t.appIDTransform = fn
+	}
+}
+
+// WithStrictRequestHeader makes the server side reject the request headers
+// carrying a field unknown to this package with an UnknownHeaderFieldError,
+// where they are skipped by default for the sake of newer clients. Only for
+// fleets upgraded in lockstep: a newer client adding a field is rejected too.
+func WithStrictRequestHeader() Option {
+	return func(t *SimpleTracker) {
+		t.strictHeader = true
+	}
+}
+
+// WithOnewayHandshake makes the handshake fire-and-forget: Negotiation sends
+// the upgrade call as ONEWAY and upgrades right away with the local ID format
+// and max concurrent streams, TryUpgrade writes no reply.
+//
+// Both sides must be configured so, with the same ID format and max
+// concurrent streams, nothing checks it: a server replying to the call, or
+// one not supporting the tracker, leaves a message the client reads as the
+// reply of its next call, and a client sending a request header to a server
+// that did not upgrade breaks the connection. Only for trusted fleets running
+// the same version and configuration.
+func WithOnewayHandshake() Option {
+	return func(t *SimpleTracker) {
+		t.onewayHandshake = true
+	}
+}
+
+// WithAdmissionController makes TryUpgrade consult c before taking on a
+// handshake, every handshake is admitted by default. It is ignored with
+// WithOnewayHandshake, the client would not read the rejection.
+func WithAdmissionController(c AdmissionController) Option {
+	return func(t *SimpleTracker) {
+		t.admission = c
+	}
+}
+
+// WithRequestIDGenerator generates the request IDs of the requests starting
+// with this tracker with fn, an external allocator for example, instead of
+// the ID format agreed on. fn is skipped for a cheap local ID once the context
+// of the request is done.
+func WithRequestIDGenerator(fn func(ctx context.Context) string) Option {
+	return func(t *SimpleTracker) {
+		t.requestIDGenerator = fn
+	}
+}
+
+// WithMetaDroppedObserver registers fn to be called when meta gets dropped
+// or truncated while reading or writing a request header, once per reason
+// with the keys dropped for it, see the MetaDrop* reasons.
+func WithMetaDroppedObserver(fn func(reason string, keys []string)) Option {
+	return func(t *SimpleTracker) {
+		t.onMetaDropped = fn
+	}
+}
+
+// WithClock makes the tracker tell the time with c rather than the system
+// clock, for tests.
+func WithClock(c Clock) Option {
+	return func(t *SimpleTracker) {
+		t.clock = c
+	}
+}
+
+// WithSampler makes the tracker ask s whether to track the requests starting
+// with it in full. The decision travels downstream, so the whole request
+// tree follows it. The header of a request not sampled carries its ID, its
+// seq and the decision only, the meta, reserved keys included, is left out.
+func WithSampler(s Sampler) Option {
+	return func(t *SimpleTracker) {
+		t.sampler = s
+	}
+}
+
+// WithMetrics reports the handshakes and the request headers of the tracker
+// to m, on top of the process-wide expvar metrics.
+func WithMetrics(m Metrics) Option {
+	return func(t *SimpleTracker) {
+		t.metrics = m
+	}
+}
+
+// WithMetaLimits bounds the meta of the request headers written to maxKeys
+// keys and maxBytes bytes, keys and values, 0 for no bound. The reserved keys
+// do not count, they have limits of their own. The meta over the limits is
+// handled by policy, the dropped keys are reported to the observer of
+// WithMetaDroppedObserver with MetaDropSizeLimit. The entries agreed with
+// WithMaxMetaEntries bound maxKeys further.
+func WithMetaLimits(maxBytes, maxKeys int, policy MetaLimitPolicy) Option {
+	return func(t *SimpleTracker) {
+		t.metaLimits = &metaLimits{maxBytes: maxBytes, maxKeys: maxKeys, policy: policy}
+	}
+}
+
+// WithNegotiationFallback makes the client go on without tracking when the
+// server fails the handshake but answers it in full, a rejection or an
+// exception: Negotiation succeeds and the tracker passes the requests through,
+// see Passthrough, until Reset. The failures leaving the connection in an
+// unknown state, the I/O ones for example, are still returned, the connection
+// must be dropped.
+func WithNegotiationFallback() Option {
+	return func(t *SimpleTracker) {
+		t.negotiationFallback = true
+	}
+}
+
+// WithFailOpen makes the tracker go on with the call rather than fail it on
+// a request header error, reported to onError, if not nil, instead:
+//
+//   - a header read in full but failing to decode, its meta for one, gives a
+//     context with the request ID and seq only;
+//   - a header failing to be built on write, over WithMetaLimits with
+//     MetaLimitError for one, is written with the request ID and seq only.
+//
+// The failures of the wire, leaving the stream at an undefined position, are
+// still returned and poison the connection, see Poisoned: there is no
+// telling where the call starts then.
+func WithFailOpen(onError func(err error)) Option {
+	return func(t *SimpleTracker) {
+		t.failOpen = true
+		t.onFailOpen = onError
+	}
+}
+
+// WithPropagators makes the tracker carry the tracing contexts of other
+// systems in the meta with propagators, B3Propagator for Zipkin, extracted in
+// order on read and injected in order on write.
+func WithPropagators(propagators ...Propagator) Option {
+	return func(t *SimpleTracker) {
+		t.propagators = append(t.propagators, propagators...)
+	}
+}
+
+// WithBeforeWriteRequestHeader makes the tracker call fn with every request
+// header about to be written, meta included, for fn to enrich or rewrite it:
+// add an auth token, override the request ID. The header is written as fn
+// leaves it, the meta limits and transforms have already run, the reserved
+// keys are not set again. An error of fn fails the call, even with
+// WithFailOpen.
+func WithBeforeWriteRequestHeader(fn func(ctx context.Context, header *tracking.RequestHeader) error) Option {
+	return func(t *SimpleTracker) {
+		t.beforeWriteRequestHeader = fn
+	}
+}
+
+// WithOnClose makes the tracker call fn once closed, see Close, with the
+// summary of its connection: to emit a last metric, whether it was ever
+// tracked for one.
+func WithOnClose(fn func(summary ConnectionSummary)) Option {
+	return func(t *SimpleTracker) {
+		t.onClose = fn
+	}
+}
+
+// WithDeadlinePropagation makes the tracker send the time left to the
+// deadline of the context of every call, see MetaKeyDeadlineBudget, and bound
+// the context of the requests it reads by the time their callers had left,
+// done right away for the callers out of time: the servers shed the work
+// nobody waits for anymore. The network time is not accounted for, the
+// servers get a little more time than the callers did.
+func WithDeadlinePropagation() Option {
+	return func(t *SimpleTracker) {
+		t.propagateDeadline = true
+	}
+}
+
+// WithUpgradeArgsBuilder makes Negotiation call fn on the upgrade call before
+// writing it, to send the deployment metadata of the client in args.Meta: its
+// environment, region or instance ID. The fields the tracker set are better
+// left alone, the handshake depends on them. Servers predating Meta skip it,
+// the handshake goes on as usual.
+func WithUpgradeArgsBuilder(fn func(args *tracking.UpgradeArgs_)) Option {
+	return func(t *SimpleTracker) {
+		t.upgradeArgsBuilder = fn
+	}
+}
+
+// WithUpgradeArgsReader makes TryUpgrade call fn with the upgrade call of
+// every client, once its magic is checked, to read what WithUpgradeArgsBuilder
+// sent. Clients predating Meta send none. fn must not modify args.
+func WithUpgradeArgsReader(fn func(args *tracking.UpgradeArgs_)) Option {
+	return func(t *SimpleTracker) {
+		t.upgradeArgsReader = fn
+	}
+}
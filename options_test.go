@@ -0,0 +1,177 @@
+package tracker
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func TestNegotiationWatchdog(t *testing.T) {
+	fired := make(chan time.Duration, 1)
+	client := NewSimpleTracker("client", WithNegotiationWatchdog(20*time.Millisecond, func(elapsed time.Duration) {
+		fired <- elapsed
+	}))
+	server := NewSimpleTracker("server")
+
+	cprot, sprot := newProtocolPair(t)
+	go func() { // a slow server
+		time.Sleep(100 * time.Millisecond)
+		serveUpgrade(server, sprot)
+	}()
+	if err := client.Negotiation(1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case elapsed := <-fired:
+		if elapsed < 20*time.Millisecond {
+			t.Fatalf("expect the watchdog to fire after the threshold, fired after %v", elapsed)
+		}
+	default:
+		t.Fatal("expect the watchdog to fire")
+	}
+	if !client.RequestHeaderSupported() {
+		t.Fatal("expect the slow handshake to succeed anyway")
+	}
+}
+
+func TestNegotiationWatchdogQuiet(t *testing.T) {
+	for _, threshold := range []time.Duration{time.Minute, 0, -time.Second} {
+		fired := make(chan time.Duration, 1)
+		client := NewSimpleTracker("client", WithNegotiationWatchdog(threshold, func(elapsed time.Duration) {
+			fired <- elapsed
+		}))
+		handshake(t, client, NewSimpleTracker("server"))
+		time.Sleep(10 * time.Millisecond)
+		select {
+		case <-fired:
+			t.Fatalf("threshold %v: expect the watchdog not to fire", threshold)
+		default:
+		}
+	}
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	cases := []struct {
+		client, server, want int
+	}{
+		{8, 4, 4},
+		{4, 8, 4},
+		{8, 0, 8},
+		{0, 8, 8},
+		{0, 0, 0},
+		{-1, 3, 3},
+		{math.MaxInt32 + 1, 0, math.MaxInt32},
+	}
+	for _, c := range cases {
+		client, server := upgradedPair(t,
+			[]Option{WithMaxConcurrentStreams(c.client)}, []Option{WithMaxConcurrentStreams(c.server)})
+		for _, tr := range []Tracker{client, server} {
+			if got := tr.(*SimpleTracker).MaxConcurrentStreams(); got != c.want {
+				t.Fatalf("client %d, server %d: expect %d, got %d", c.client, c.server, c.want, got)
+			}
+		}
+	}
+}
+
+func TestMaxConcurrentStreamsOldPeer(t *testing.T) {
+	if got := minLimit(4, 0); got != 4 { // an unset field reads as 0
+		t.Fatalf("expect the local limit, got %d", got)
+	}
+}
+
+func TestMaxMetaEntries(t *testing.T) {
+	cases := []struct {
+		client, server, want int
+	}{
+		{5, 3, 3},
+		{3, 5, 3},
+		{0, 4, 4},
+		{2, 0, 2},
+		{0, 0, 0},
+	}
+	for _, c := range cases {
+		client, server := upgradedPair(t,
+			[]Option{WithMaxMetaEntries(c.client)}, []Option{WithMaxMetaEntries(c.server)})
+		for _, tr := range []Tracker{client, server} {
+			if got := tr.(*SimpleTracker).MaxMetaEntries(); got != c.want {
+				t.Fatalf("client %d, server %d: expect %d, got %d", c.client, c.server, c.want, got)
+			}
+		}
+	}
+}
+
+func nonReservedMeta(meta map[string]string) map[string]string {
+	out := make(map[string]string)
+	for k, v := range meta {
+		if !isReservedMetaKey(k) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func TestMaxMetaEntriesWrite(t *testing.T) {
+	var drops []droppedMeta
+	client, _ := upgradedPair(t, []Option{observeDrops(&drops)}, []Option{WithMaxMetaEntries(2)})
+	ctx := WithBudget(context.Background(), 3)
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"})
+
+	header := tracking.NewRequestHeader()
+	if err := header.Read(protocolOf(writeRequestHeader(t, client, ctx))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nonReservedMeta(header.Meta), map[string]string{"c": "3", "d": "4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expect %v written, got %v", want, got)
+	}
+	if header.Meta[MetaKeyBudget] != "3" {
+		t.Fatalf("expect the reserved keys kept, got %v", header.Meta)
+	}
+	if want := []droppedMeta{{MetaDropSizeLimit, []string{"a", "b"}}}; !reflect.DeepEqual(drops, want) {
+		t.Fatalf("expect %v reported, got %v", want, drops)
+	}
+
+	// The tighter of the local and agreed limits applies, with the policy of
+	// WithMetaLimits.
+	client, _ = upgradedPair(t, []Option{WithMetaLimits(0, 3, MetaLimitError)}, []Option{WithMaxMetaEntries(2)})
+	if err := client.TryWriteRequestHeader(ctx, newMemoryProtocol()); err != ErrMetaTooLarge {
+		t.Fatalf("expect ErrMetaTooLarge, got %v", err)
+	}
+}
+
+func TestMaxMetaEntriesRead(t *testing.T) {
+	var drops []droppedMeta
+	server := NewSimpleTracker("server", observeDrops(&drops)).(*SimpleTracker)
+	server.setMaxMetaEntries(2)
+	server.upgradeProtocol(IDFormatOpaque, 0, nil)
+	client := NewSimpleTracker("client").(*SimpleTracker) // unaware of the limit
+	client.upgradeProtocol(IDFormatOpaque, 0, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"a": "1", "b": "2", "c": "3"})
+
+	sctx, err := server.TryReadRequestHeader(protocolOf(writeRequestHeader(t, client, ctx)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nonReservedMeta(metaFromContext(sctx)), map[string]string{"b": "2", "c": "3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expect %v read, got %v", want, got)
+	}
+	if want := []droppedMeta{{MetaDropSizeLimit, []string{"a"}}}; !reflect.DeepEqual(drops, want) {
+		t.Fatalf("expect %v reported, got %v", want, drops)
+	}
+}
+
+func TestAppIDTransform(t *testing.T) {
+	client, server := upgradedPair(t, []Option{WithIDFormat(IDFormatStructured), WithAppIDTransform(func(appID string) string {
+		return "staging." + appID
+	})}, []Option{WithIDFormat(IDFormatStructured)})
+	if got := server.(*SimpleTracker).PeerAppID(); got != "staging.client" {
+		t.Fatalf("expect the transformed app ID in the handshake, got %q", got)
+	}
+	if reqID, _ := client.RequestSeqIDFromCtx(context.Background()); !strings.HasPrefix(reqID, "client:") {
+		t.Fatalf("expect the request IDs to keep the name of the tracker, got %q", reqID)
+	}
+}
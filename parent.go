@@ -0,0 +1,41 @@
+package tracker
+
+import (
+	"context"
+)
+
+// MetaKeyParentSeq is the reserved meta key carrying the seq of the request a
+// call is made for, its parent in the trace tree, so that the tree can be
+// built without parsing the seqs. The root calls, made outside any request,
+// have none.
+const MetaKeyParentSeq = "parent_seq"
+
+const ctxKeyParentSeq ctxKey = "__thrift_tracking_parent_seq"
+
+// ParentSeqFromContext returns the seq of the parent of the current request,
+// ok is false for a root request. The seq of the request itself is under
+// CtxKeySequenceID.
+func ParentSeqFromContext(ctx context.Context) (seq string, ok bool) {
+	seq, ok = ctx.Value(ctxKeyParentSeq).(string)
+	return
+}
+
+// extractParentSeq keeps the parent seq only if the seq of the request is one
+// of its children, anything else is a forgery or a bug upstream.
+func extractParentSeq(ctx context.Context, meta map[string]string) (context.Context, error) {
+	parent := meta[MetaKeyParentSeq]
+	seq, _ := ctx.Value(CtxKeySequenceID).(string)
+	if parent != "" && (Seq{path: seq}).IsChildOf(Seq{path: parent}) {
+		ctx = context.WithValue(ctx, ctxKeyParentSeq, parent)
+	}
+	return ctx, nil
+}
+
+func injectParentSeq(ctx context.Context, meta map[string]string) error {
+	if seq, ok := ctx.Value(CtxKeySequenceID).(string); ok && seq != "" {
+		meta[MetaKeyParentSeq] = seq
+	} else {
+		delete(meta, MetaKeyParentSeq)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func TestParentSeq(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	root := passRequestHeader(t, context.Background(), client, server)
+	if _, ok := ParentSeqFromContext(root); ok {
+		t.Fatal("expect no parent for a root request")
+	}
+
+	relayClient, downstream := upgradedPair(t, nil, nil)
+	passRequestHeader(t, root, relayClient, downstream) // 1.1.1
+	child := passRequestHeader(t, root, relayClient, downstream)
+	if parent, ok := ParentSeqFromContext(child); !ok || parent != "1.1" || child.Value(CtxKeySequenceID) != "1.1.2" {
+		t.Fatalf("expect 1.1.2 to be a child of 1.1, got %q of %v", parent, child.Value(CtxKeySequenceID))
+	}
+	grandchild := passRequestHeader(t, child, relayClient, downstream)
+	if parent, _ := ParentSeqFromContext(grandchild); parent != "1.1.2" {
+		t.Fatalf("expect the parent 1.1.2, got %q", parent)
+	}
+}
+
+func TestParentSeqValidation(t *testing.T) {
+	_, server := upgradedPair(t, nil, nil)
+	for parent, valid := range map[string]bool{
+		"1.2":   true,
+		"1":     false,
+		"1.3":   false,
+		"1.2.5": false,
+		"1.":    false,
+	} {
+		header := tracking.NewRequestHeader()
+		header.Seq = "1.2.5"
+		header.Meta = map[string]string{MetaKeyParentSeq: parent}
+		prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+		header.Write(prot)
+		ctx, err := server.TryReadRequestHeader(prot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := ParentSeqFromContext(ctx); ok != valid {
+			t.Fatalf("%q: expect valid %v", parent, valid)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package tracker
+
+import (
+	"errors"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// HeaderReadError is the error of a request header failing to be read off
+// the wire, truncated or malformed. Where the next message starts is lost
+// then, the tracker marks its connection as poisoned, see Poisoned. Err is
+// the error of the protocol, errors.As gets the thrift.TProtocolException.
+// It is ErrHeaderReadFailed.
+type HeaderReadError struct {
+	Err error
+}
+
+func (e *HeaderReadError) Error() string {
+	return "tracker: failed reading request header: " + e.Err.Error()
+}
+
+func (e *HeaderReadError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HeaderReadError) Is(target error) bool {
+	return target == ErrHeaderReadFailed
+}
+
+// ErrHeaderReadFailed is matched by errors.Is against the HeaderReadErrors
+// and ErrConnectionPoisoned, the header reads leaving the connection unusable.
+var ErrHeaderReadFailed = errors.New("tracker: failed reading request header")
+
+// ErrConnectionPoisoned is returned by the header reads of a tracker whose
+// connection is poisoned.
+var ErrConnectionPoisoned error = &poisonedError{}
+
+type poisonedError struct{}
+
+func (*poisonedError) Error() string {
+	return "tracker: connection poisoned by a failed request header read"
+}
+
+func (*poisonedError) Is(target error) bool {
+	return target == ErrHeaderReadFailed
+}
+
+// Poisoned tells whether a request header failed to be read off the
+// connection, or was given up on halfway through its write, see
+// HeaderWriteAbortedError, leaving the stream at an undefined position: the
+// connection is to be closed, never reused nor returned to a pool. Reset
+// clears it along with the handshake.
+func (t *SimpleTracker) Poisoned() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.poisoned
+}
+
+// poison marks the connection as poisoned after err, read failed after n
+// bytes of the header. A clean end of file, the peer closing the connection
+// between two calls, is returned as is for the servers of thrift to close the
+// connection quietly, it is wrapped into a HeaderReadError otherwise. An
+// UnknownHeaderFieldError poisons nothing, the header was read to its end.
+func (t *SimpleTracker) poison(err error, n int) error {
+	if _, ok := err.(*UnknownHeaderFieldError); ok {
+		return err
+	}
+	t.mu.Lock()
+	t.poisoned = true
+	t.mu.Unlock()
+	if te, ok := err.(thrift.TTransportException); ok && te.TypeId() == thrift.END_OF_FILE && n == 0 {
+		return err
+	}
+	return &HeaderReadError{Err: err}
+}
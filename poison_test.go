@@ -0,0 +1,92 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func TestTruncatedHeaderPoisons(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	ctx := WithRequestID(context.Background(), "req")
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	truncated := thrift.NewTMemoryBuffer()
+	truncated.Write(buf.Bytes()[:buf.Len()-3])
+
+	_, err := server.TryReadRequestHeader(thrift.NewTBinaryProtocolTransport(truncated))
+	var rerr *HeaderReadError
+	if !errors.As(err, &rerr) || rerr.Err == nil {
+		t.Fatalf("expect a HeaderReadError, got %v", err)
+	}
+	var pe thrift.TProtocolException
+	if !errors.Is(err, ErrHeaderReadFailed) || !errors.As(err, &pe) || errors.Is(err, ErrNegotiationFailed) {
+		t.Fatalf("expect a header read failure of the protocol, got %#v", err)
+	}
+	st := server.(*SimpleTracker)
+	if !st.Poisoned() {
+		t.Fatal("expect the connection poisoned")
+	}
+	if _, err := server.TryReadRequestHeader(newMemoryProtocol()); err != ErrConnectionPoisoned {
+		t.Fatalf("expect the next read to fail, got %v", err)
+	} else if !errors.Is(err, ErrHeaderReadFailed) {
+		t.Fatal("expect ErrConnectionPoisoned to be a header read failure")
+	}
+	st.Reset()
+	if st.Poisoned() {
+		t.Fatal("expect Reset to clear the poison")
+	}
+}
+
+func TestCleanEOFNotWrapped(t *testing.T) {
+	_, server := upgradedPair(t, nil, nil)
+	c, s := net.Pipe()
+	c.Close() // the client goes away between two calls
+	defer s.Close()
+	_, err := server.TryReadRequestHeader(thrift.NewTBinaryProtocolTransport(thrift.NewTSocketFromConnTimeout(s, 0)))
+	te, ok := err.(thrift.TTransportException)
+	if !ok || te.TypeId() != thrift.END_OF_FILE {
+		t.Fatalf("expect the end of file as is, got %#v", err)
+	}
+	if !server.(*SimpleTracker).Poisoned() {
+		t.Fatal("expect the connection poisoned")
+	}
+}
+
+func TestTrackedProcessorClosesPoisoned(t *testing.T) {
+	_, server := upgradedPair(t, nil, nil)
+	prot := newMemoryProtocol()
+	prot.WriteStructBegin("RequestHeader")
+	prot.WriteFieldBegin("request_id", thrift.STRING, 1) // no value follows
+	prot.Flush()
+
+	p := NewTrackedProcessor(server, &stockProcessor{})
+	if ok, err := p.Process(prot, newMemoryProtocol()); ok || err == nil {
+		t.Fatalf("expect the processor to fail the connection, got %v %v", ok, err)
+	}
+}
+
+func TestStrictRejectionKeepsStream(t *testing.T) {
+	strict := NewSimpleTracker("server", WithStrictRequestHeader())
+	client, _ := upgradedPair(t, nil, nil)
+	handshake(t, NewSimpleTracker("client"), strict)
+	prot := newMemoryProtocol()
+	writeExtendedHeader(prot)
+	ctx := WithRequestID(context.Background(), "next")
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strict.TryReadRequestHeader(prot); err == nil {
+		t.Fatal("expect the extended header rejected")
+	}
+	got, err := strict.TryReadRequestHeader(prot)
+	if err != nil || got.Value(CtxKeyRequestID) != "next" {
+		t.Fatalf("expect the next header read off the same stream, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// ContextProcessor is a thrift.TProcessor able to serve a call under the
+// context of its request header.
+type ContextProcessor interface {
+	ProcessContext(ctx context.Context, iprot, oprot thrift.TProtocol) (bool, thrift.TException)
+}
+
+// TrackedProcessor adds tracking to a processor generated by the stock
+// compiler, unaware of it: it answers the handshake on its own, and reads the
+// request header in front of every other call before handing it over.
+//
+// The context of the request reaches the handlers only if the processor is a
+// ContextProcessor, or through OnRequest. Replies carry no header, there is
+// nothing to write after the call.
+type TrackedProcessor struct {
+	tracker   Tracker
+	processor thrift.TProcessor
+	// OnRequest, if set, is called with the context of every call but the
+	// handshake, before it is processed.
+	OnRequest func(ctx context.Context, method string)
+	// MethodFilter, if set, leaves the calls of the methods it filters out
+	// untracked: they are processed under an empty context and skip
+	// OnRequest. The header is read anyway, filter on the client with
+	// NewMethodFilterProtocol to save it.
+	MethodFilter MethodFilter
+}
+
+// NewTrackedProcessor wraps processor with tracker, the tracker of a single
+// connection, see NewTrackedProcessorFactory for a server.
+func NewTrackedProcessor(tracker Tracker, processor thrift.TProcessor) *TrackedProcessor {
+	return &TrackedProcessor{tracker: tracker, processor: processor}
+}
+
+// NewTrackedProcessorFactory returns a factory wrapping processor with a new
+// tracker of newTracker for every connection, to be given to the servers of
+// thrift, NewTSimpleServerFactory4 for one.
+func NewTrackedProcessorFactory(newTracker func() Tracker, processor thrift.TProcessor) thrift.TProcessorFactory {
+	return trackedProcessorFactory{newTracker: newTracker, processor: processor}
+}
+
+type trackedProcessorFactory struct {
+	newTracker func() Tracker
+	processor  thrift.TProcessor
+}
+
+func (f trackedProcessorFactory) GetProcessor(thrift.TTransport) thrift.TProcessor {
+	return NewTrackedProcessor(f.newTracker(), f.processor)
+}
+
+// WrapProcessorFactory is NewTrackedProcessorFactory for the servers making a
+// processor per connection: the processor of inner for the connection is
+// wrapped with a new tracker of newTracker, a factory of a
+// NewTrackerFactoryFunc for one, NewSimpleTrackerFactory(name). A single line
+// at the construction of the server:
+//
+//	server := thrift.NewTSimpleServerFactory4(
+//		tracker.WrapProcessorFactory(processorFactory, tracker.NewSimpleTrackerFactory("app")),
+//		serverTransport, transportFactory, protocolFactory)
+func WrapProcessorFactory(inner thrift.TProcessorFactory, newTracker func() Tracker) thrift.TProcessorFactory {
+	return wrappedProcessorFactory{inner: inner, newTracker: newTracker}
+}
+
+type wrappedProcessorFactory struct {
+	inner      thrift.TProcessorFactory
+	newTracker func() Tracker
+}
+
+func (f wrappedProcessorFactory) GetProcessor(trans thrift.TTransport) thrift.TProcessor {
+	return NewTrackedProcessor(f.newTracker(), f.inner.GetProcessor(trans))
+}
+
+func (p *TrackedProcessor) Process(iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	ctx, err := p.tracker.TryReadRequestHeader(iprot)
+	if err != nil {
+		return false, err
+	}
+	next, ok, err := AcceptHandshake(p.tracker, iprot, oprot)
+	if err != ErrNotHandshake {
+		return ok, err
+	}
+	if name := next.(*peekedProtocol).name; p.MethodFilter != nil && !p.MethodFilter(name) {
+		ReleaseDeadline(ctx)
+		ctx = context.Background()
+	} else if p.OnRequest != nil {
+		p.OnRequest(ctx, name)
+	}
+	iprot = next
+	defer ReleaseDeadline(ctx)
+	if cp, ok := p.processor.(ContextProcessor); ok {
+		return cp.ProcessContext(ctx, iprot, oprot)
+	}
+	return p.processor.Process(iprot, oprot)
+}
+
+// ErrNotHandshake is returned by AcceptHandshake for any call but the
+// handshake.
+var ErrNotHandshake = errors.New("tracker: not a handshake")
+
+// AcceptHandshake reads the message header of the next call off iprot for
+// servers serving clients with and without tracking on the same port: the
+// first call of the latter is a regular one. The handshake is answered by h
+// right away, the results are the ones of TryUpgrade. Any other call is left
+// unread but its message header, AcceptHandshake returns ErrNotHandshake and
+// next, iprot replaying the header, to dispatch the call as usual.
+func AcceptHandshake(h HandShaker, iprot, oprot thrift.TProtocol) (next thrift.TProtocol, ok bool, err thrift.TException) {
+	name, typeID, seqID, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return nil, false, err
+	}
+	if name == TrackingAPIName {
+		ok, err = h.TryUpgrade(seqID, iprot, oprot)
+		return nil, ok, err
+	}
+	return &peekedProtocol{TProtocol: iprot, name: name, typeID: typeID, seqID: seqID}, true, ErrNotHandshake
+}
+
+// peekedProtocol returns a message header read ahead on the first
+// ReadMessageBegin.
+type peekedProtocol struct {
+	thrift.TProtocol
+	name     string
+	typeID   thrift.TMessageType
+	seqID    int32
+	replayed bool
+}
+
+func (p *peekedProtocol) ReadMessageBegin() (string, thrift.TMessageType, int32, error) {
+	if p.replayed {
+		return p.TProtocol.ReadMessageBegin()
+	}
+	p.replayed = true
+	return p.name, p.typeID, p.seqID, nil
+}
@@ -0,0 +1,166 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// stockProcessor reads a call as a generated processor unaware of tracking.
+type stockProcessor struct {
+	method string
+	seqID  int32
+	ctx    context.Context
+}
+
+func (p *stockProcessor) Process(iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	name, _, seqID, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return false, err
+	}
+	if err := iprot.Skip(thrift.STRUCT); err != nil {
+		return false, err
+	}
+	p.method, p.seqID = name, seqID
+	return true, iprot.ReadMessageEnd()
+}
+
+type stockContextProcessor struct{ stockProcessor }
+
+func (p *stockContextProcessor) ProcessContext(ctx context.Context, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	p.ctx = ctx
+	return p.Process(iprot, oprot)
+}
+
+func writeCall(t *testing.T, client Tracker, ctx context.Context, method string, seqID int32) thrift.TProtocol {
+	t.Helper()
+	prot := newMemoryProtocol()
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	prot.WriteMessageBegin(method, thrift.CALL, seqID)
+	prot.WriteStructBegin("args")
+	prot.WriteFieldStop()
+	prot.WriteStructEnd()
+	prot.WriteMessageEnd()
+	return prot
+}
+
+func TestTrackedProcessor(t *testing.T) {
+	inner := &stockContextProcessor{}
+	var requested string
+	newProcessor := NewTrackedProcessorFactory(NewSimpleTrackerFactory("server"), inner)
+	processor := newProcessor.GetProcessor(nil).(*TrackedProcessor)
+	processor.OnRequest = func(ctx context.Context, method string) { requested = method }
+
+	client := NewSimpleTracker("client")
+	cprot, sprot := newProtocolPair(t)
+	done := make(chan error, 1)
+	go func() {
+		_, err := processor.Process(sprot, sprot)
+		done <- err
+	}()
+	if err := client.Negotiation(1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if inner.method != "" || requested != "" {
+		t.Fatal("expect the handshake not to reach the processor")
+	}
+
+	ctx := WithRequestID(context.Background(), "req")
+	ok, err := processor.Process(writeCall(t, client, ctx, "add", 7), newMemoryProtocol())
+	if !ok || err != nil {
+		t.Fatalf("expect the call to succeed, got %v %v", ok, err)
+	}
+	if inner.method != "add" || inner.seqID != 7 || requested != "add" {
+		t.Fatalf("expect the message header replayed, got %q %d", inner.method, inner.seqID)
+	}
+	if inner.ctx.Value(CtxKeyRequestID) != "req" {
+		t.Fatalf("expect the context of the request header, got %v", inner.ctx.Value(CtxKeyRequestID))
+	}
+}
+
+func TestTrackedProcessorNotUpgraded(t *testing.T) {
+	inner := &stockProcessor{}
+	processor := NewTrackedProcessor(NewSimpleTracker("server"), inner)
+	// Without a handshake, the client writes no header.
+	prot := writeCall(t, NewSimpleTracker("client"), context.Background(), "ping", 3)
+	if ok, err := processor.Process(prot, newMemoryProtocol()); !ok || err != nil {
+		t.Fatalf("expect the call to succeed, got %v %v", ok, err)
+	}
+	if inner.method != "ping" || inner.seqID != 3 {
+		t.Fatalf("expect the call processed, got %q %d", inner.method, inner.seqID)
+	}
+}
+
+func TestAcceptHandshake(t *testing.T) {
+	// A legacy client: the first message is a regular call.
+	server := NewSimpleTracker("server")
+	prot := writeCall(t, NewSimpleTracker("legacy"), context.Background(), "add", 5)
+	next, ok, err := AcceptHandshake(server, prot, newMemoryProtocol())
+	if err != ErrNotHandshake || !ok {
+		t.Fatalf("expect ErrNotHandshake, got %v %v", ok, err)
+	}
+	inner := &stockProcessor{}
+	if ok, err := inner.Process(next, newMemoryProtocol()); !ok || err != nil {
+		t.Fatalf("expect the call intact, got %v %v", ok, err)
+	}
+	if inner.method != "add" || inner.seqID != 5 || server.RequestHeaderSupported() {
+		t.Fatalf("expect the call dispatched untouched, got %q %d", inner.method, inner.seqID)
+	}
+
+	// A tracking client.
+	cprot, sprot := newProtocolPair(t)
+	done := make(chan error, 1)
+	go func() {
+		next, ok, err := AcceptHandshake(server, sprot, sprot)
+		if err == nil && (!ok || next != nil) {
+			err = fmt.Errorf("expect the handshake answered, got %v %v", next, ok)
+		}
+		done <- err
+	}()
+	if err := NewSimpleTracker("client").Negotiation(1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !server.RequestHeaderSupported() {
+		t.Fatal("expect the server upgraded")
+	}
+}
+
+type perConnectionFactory struct {
+	processors []*stockProcessor
+}
+
+func (f *perConnectionFactory) GetProcessor(thrift.TTransport) thrift.TProcessor {
+	p := &stockProcessor{}
+	f.processors = append(f.processors, p)
+	return p
+}
+
+func TestWrapProcessorFactory(t *testing.T) {
+	inner := &perConnectionFactory{}
+	factory := WrapProcessorFactory(inner, NewSimpleTrackerFactory("server"))
+	first := factory.GetProcessor(nil).(*TrackedProcessor)
+	second := factory.GetProcessor(nil).(*TrackedProcessor)
+	if len(inner.processors) != 2 || first.tracker == second.tracker {
+		t.Fatal("expect a processor and a tracker per connection")
+	}
+
+	client := NewSimpleTracker("client")
+	handshake(t, client, first.tracker)
+	ctx := WithRequestID(context.Background(), "req")
+	if ok, err := first.Process(writeCall(t, client, ctx, "add", 2), newMemoryProtocol()); !ok || err != nil {
+		t.Fatalf("expect the call to succeed, got %v %v", ok, err)
+	}
+	if p := inner.processors[0]; p.method != "add" || p.seqID != 2 || inner.processors[1].method != "" {
+		t.Fatalf("expect the call on the processor of its connection, got %q %d", p.method, p.seqID)
+	}
+}
@@ -0,0 +1,56 @@
+package tracker
+
+import (
+	"context"
+	"strings"
+)
+
+// Propagator carries a tracing context of another system, Zipkin B3 for one,
+// in the meta of the request headers, see WithPropagators. Unlike the
+// reserved keys, its keys are regular meta the peers unaware of it pass
+// through, but the trackers with it always write them from the context.
+type Propagator interface {
+	// Inject writes the tracing context of ctx, the one of the call to make,
+	// into meta, the outgoing meta. It removes its keys if ctx has none.
+	// meta is recycled once the header is written, it is not to be kept.
+	Inject(ctx context.Context, meta map[string]string)
+	// Extract returns ctx with the tracing context read from meta, the
+	// incoming meta, ctx as is if there is none or it is malformed.
+	Extract(ctx context.Context, meta map[string]string) context.Context
+}
+
+func (t *SimpleTracker) injectPropagators(ctx context.Context, meta map[string]string) {
+	for _, p := range t.propagators {
+		p.Inject(ctx, meta)
+	}
+}
+
+func (t *SimpleTracker) extractPropagators(ctx context.Context, meta map[string]string) context.Context {
+	for _, p := range t.propagators {
+		ctx = p.Extract(ctx, meta)
+	}
+	return ctx
+}
+
+// lookupMetaFold returns the value of key in meta regardless of case, the
+// keys of the tracing systems are HTTP headers, whose case does not matter.
+func lookupMetaFold(meta map[string]string, key string) (string, bool) {
+	if v, ok := meta[key]; ok {
+		return v, true
+	}
+	for k, v := range meta {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// deleteMetaFold removes key from meta regardless of case.
+func deleteMetaFold(meta map[string]string, key string) {
+	for k := range meta {
+		if strings.EqualFold(k, key) {
+			delete(meta, k)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package tracker
+
+import (
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// countingProtocol mirrors every struct level value passing through the
+// wrapped protocol, in either direction, onto a shadow protocol of the same
+// kind backed by a memory buffer, so the serialized size can be told afterwards.
+//
+// WriteByte/ReadByte are left out since their signatures upset go vet, none of
+// the tracking structs has a byte field anyway.
+type countingProtocol struct {
+	thrift.TProtocol
+	buf    *thrift.TMemoryBuffer
+	shadow thrift.TProtocol
+	pooled bool
+}
+
+func newCountingProtocol(prot thrift.TProtocol) *countingProtocol {
+	buf := thrift.NewTMemoryBuffer()
+	return &countingProtocol{
+		TProtocol: prot,
+		buf:       buf,
+		shadow:    shadowProtocolFactory(prot).GetProtocol(buf),
+	}
+}
+
+func shadowProtocolFactory(prot thrift.TProtocol) thrift.TProtocolFactory {
+	switch prot.(type) {
+	case *thrift.TCompactProtocol:
+		return thrift.NewTCompactProtocolFactory()
+	case *thrift.TJSONProtocol:
+		return thrift.NewTJSONProtocolFactory()
+	case *thrift.TSimpleJSONProtocol:
+		return thrift.NewTSimpleJSONProtocolFactory()
+	default: // the strictness of binary protocol only matters to messages
+		return thrift.NewTBinaryProtocolFactoryDefault()
+	}
+}
+
+// Size returns the number of bytes counted so far.
+func (p *countingProtocol) Size() int {
+	p.shadow.Flush()
+	return p.buf.Len()
+}
+
+func (p *countingProtocol) WriteStructBegin(name string) error {
+	p.shadow.WriteStructBegin(name)
+	return p.TProtocol.WriteStructBegin(name)
+}
+
+func (p *countingProtocol) WriteStructEnd() error {
+	p.shadow.WriteStructEnd()
+	return p.TProtocol.WriteStructEnd()
+}
+
+func (p *countingProtocol) WriteFieldBegin(name string, typeID thrift.TType, id int16) error {
+	p.shadow.WriteFieldBegin(name, typeID, id)
+	return p.TProtocol.WriteFieldBegin(name, typeID, id)
+}
+
+func (p *countingProtocol) WriteFieldEnd() error {
+	p.shadow.WriteFieldEnd()
+	return p.TProtocol.WriteFieldEnd()
+}
+
+func (p *countingProtocol) WriteFieldStop() error {
+	p.shadow.WriteFieldStop()
+	return p.TProtocol.WriteFieldStop()
+}
+
+func (p *countingProtocol) WriteMapBegin(keyType, valueType thrift.TType, size int) error {
+	p.shadow.WriteMapBegin(keyType, valueType, size)
+	return p.TProtocol.WriteMapBegin(keyType, valueType, size)
+}
+
+func (p *countingProtocol) WriteMapEnd() error {
+	p.shadow.WriteMapEnd()
+	return p.TProtocol.WriteMapEnd()
+}
+
+func (p *countingProtocol) WriteListBegin(elemType thrift.TType, size int) error {
+	p.shadow.WriteListBegin(elemType, size)
+	return p.TProtocol.WriteListBegin(elemType, size)
+}
+
+func (p *countingProtocol) WriteListEnd() error {
+	p.shadow.WriteListEnd()
+	return p.TProtocol.WriteListEnd()
+}
+
+func (p *countingProtocol) WriteSetBegin(elemType thrift.TType, size int) error {
+	p.shadow.WriteSetBegin(elemType, size)
+	return p.TProtocol.WriteSetBegin(elemType, size)
+}
+
+func (p *countingProtocol) WriteSetEnd() error {
+	p.shadow.WriteSetEnd()
+	return p.TProtocol.WriteSetEnd()
+}
+
+func (p *countingProtocol) WriteBool(value bool) error {
+	p.shadow.WriteBool(value)
+	return p.TProtocol.WriteBool(value)
+}
+
+func (p *countingProtocol) WriteI16(value int16) error {
+	p.shadow.WriteI16(value)
+	return p.TProtocol.WriteI16(value)
+}
+
+func (p *countingProtocol) WriteI32(value int32) error {
+	p.shadow.WriteI32(value)
+	return p.TProtocol.WriteI32(value)
+}
+
+func (p *countingProtocol) WriteI64(value int64) error {
+	p.shadow.WriteI64(value)
+	return p.TProtocol.WriteI64(value)
+}
+
+func (p *countingProtocol) WriteDouble(value float64) error {
+	p.shadow.WriteDouble(value)
+	return p.TProtocol.WriteDouble(value)
+}
+
+func (p *countingProtocol) WriteString(value string) error {
+	p.shadow.WriteString(value)
+	return p.TProtocol.WriteString(value)
+}
+
+func (p *countingProtocol) WriteBinary(value []byte) error {
+	p.shadow.WriteBinary(value)
+	return p.TProtocol.WriteBinary(value)
+}
+
+func (p *countingProtocol) ReadStructBegin() (string, error) {
+	name, err := p.TProtocol.ReadStructBegin()
+	if err == nil {
+		p.shadow.WriteStructBegin(name)
+	}
+	return name, err
+}
+
+func (p *countingProtocol) ReadStructEnd() error {
+	err := p.TProtocol.ReadStructEnd()
+	if err == nil {
+		p.shadow.WriteStructEnd()
+	}
+	return err
+}
+
+func (p *countingProtocol) ReadFieldBegin() (string, thrift.TType, int16, error) {
+	name, typeID, id, err := p.TProtocol.ReadFieldBegin()
+	if err == nil {
+		if typeID == thrift.STOP {
+			p.shadow.WriteFieldStop()
+		} else {
+			p.shadow.WriteFieldBegin(name, typeID, id)
+		}
+	}
+	return name, typeID, id, err
+}
+
+func (p *countingProtocol) ReadFieldEnd() error {
+	err := p.TProtocol.ReadFieldEnd()
+	if err == nil {
+		p.shadow.WriteFieldEnd()
+	}
+	return err
+}
+
+func (p *countingProtocol) ReadMapBegin() (thrift.TType, thrift.TType, int, error) {
+	keyType, valueType, size, err := p.TProtocol.ReadMapBegin()
+	if err == nil {
+		p.shadow.WriteMapBegin(keyType, valueType, size)
+	}
+	return keyType, valueType, size, err
+}
+
+func (p *countingProtocol) ReadMapEnd() error {
+	err := p.TProtocol.ReadMapEnd()
+	if err == nil {
+		p.shadow.WriteMapEnd()
+	}
+	return err
+}
+
+func (p *countingProtocol) ReadListBegin() (thrift.TType, int, error) {
+	elemType, size, err := p.TProtocol.ReadListBegin()
+	if err == nil {
+		p.shadow.WriteListBegin(elemType, size)
+	}
+	return elemType, size, err
+}
+
+func (p *countingProtocol) ReadListEnd() error {
+	err := p.TProtocol.ReadListEnd()
+	if err == nil {
+		p.shadow.WriteListEnd()
+	}
+	return err
+}
+
+func (p *countingProtocol) ReadSetBegin() (thrift.TType, int, error) {
+	elemType, size, err := p.TProtocol.ReadSetBegin()
+	if err == nil {
+		p.shadow.WriteSetBegin(elemType, size)
+	}
+	return elemType, size, err
+}
+
+func (p *countingProtocol) ReadSetEnd() error {
+	err := p.TProtocol.ReadSetEnd()
+	if err == nil {
+		p.shadow.WriteSetEnd()
+	}
+	return err
+}
+
+func (p *countingProtocol) ReadBool() (bool, error) {
+	v, err := p.TProtocol.ReadBool()
+	if err == nil {
+		p.shadow.WriteBool(v)
+	}
+	return v, err
+}
+
+func (p *countingProtocol) ReadI16() (int16, error) {
+	v, err := p.TProtocol.ReadI16()
+	if err == nil {
+		p.shadow.WriteI16(v)
+	}
+	return v, err
+}
+
+func (p *countingProtocol) ReadI32() (int32, error) {
+	v, err := p.TProtocol.ReadI32()
+	if err == nil {
+		p.shadow.WriteI32(v)
+	}
+	return v, err
+}
+
+func (p *countingProtocol) ReadI64() (int64, error) {
+	v, err := p.TProtocol.ReadI64()
+	if err == nil {
+		p.shadow.WriteI64(v)
+	}
+	return v, err
+}
+
+func (p *countingProtocol) ReadDouble() (float64, error) {
+	v, err := p.TProtocol.ReadDouble()
+	if err == nil {
+		p.shadow.WriteDouble(v)
+	}
+	return v, err
+}
+
+func (p *countingProtocol) ReadString() (string, error) {
+	v, err := p.TProtocol.ReadString()
+	if err == nil {
+		p.shadow.WriteString(v)
+	}
+	return v, err
+}
+
+func (p *countingProtocol) ReadBinary() ([]byte, error) {
+	v, err := p.TProtocol.ReadBinary()
+	if err == nil {
+		p.shadow.WriteBinary(v)
+	}
+	return v, err
+}
+
+// Skip must go through the methods above, the wrapped one would bypass them.
+func (p *countingProtocol) Skip(fieldType thrift.TType) error {
+	return thrift.SkipDefaultDepth(p, fieldType)
+}
@@ -0,0 +1,44 @@
+package tracker
+
+import (
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func TestCountingProtocol(t *testing.T) {
+	header := tracking.NewRequestHeader()
+	header.RequestID = "id"
+	header.Seq = "1.1"
+	header.Meta = map[string]string{"a": "b", "c": "d"}
+	header.MetaBlob = []byte{1, 2, 3}
+	header.SchemaVer = thrift.Int32Ptr(1)
+
+	factories := map[string]thrift.TProtocolFactory{
+		"binary":  thrift.NewTBinaryProtocolFactoryDefault(),
+		"compact": thrift.NewTCompactProtocolFactory(),
+		"json":    thrift.NewTJSONProtocolFactory(),
+	}
+	for name, factory := range factories {
+		buf := thrift.NewTMemoryBuffer()
+		prot := factory.GetProtocol(buf)
+		wprot := newCountingProtocol(prot)
+		if err := header.Write(wprot); err != nil {
+			t.Fatal(err)
+		}
+		prot.Flush()
+		size := buf.Len()
+		if got := wprot.Size(); got != size {
+			t.Fatalf("%s: expect written size %d, got %d", name, size, got)
+		}
+
+		rprot := newCountingProtocol(prot)
+		if err := tracking.NewRequestHeader().Read(rprot); err != nil {
+			t.Fatal(err)
+		}
+		if got := rprot.Size(); got != size {
+			t.Fatalf("%s: expect read size %d, got %d", name, size, got)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package tracker
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// TraceRecord is a request served by a RecordingTracker.
+type TraceRecord struct {
+	RequestID string
+	Seq       string
+	// Calls are the seqs of the downstream calls made for the request, the
+	// children of Seq in the seq tree.
+	Calls    []string
+	Meta     map[string]string
+	Start    time.Time
+	Duration time.Duration
+}
+
+const ctxKeyTrace ctxKey = "__thrift_tracking_trace"
+
+type liveTrace struct {
+	mu     sync.Mutex
+	record TraceRecord
+	done   bool
+}
+
+// RecordingTracker wraps a Tracker to keep the last completed traces in
+// memory, for diagnostics at runtime. A trace starts once a request header is
+// read and completes on Finish. The calls made in between under the context of
+// the request by any RecordingTracker, the client trackers to the downstream
+// wrapped too, are recorded as children of it. The requests flagged by
+// WithNoLog are not recorded.
+//
+// The traces of all the trackers sharing a RecordingTracker end up in the same
+// buffer: wrap a factory, see NewRecordingTrackerFactory, to record the
+// requests of all the connections of a server.
+type RecordingTracker struct {
+	Tracker
+	recorder *traceRecorder
+}
+
+type traceRecorder struct {
+	mu      sync.Mutex
+	records []TraceRecord
+	next    int
+	full    bool
+}
+
+// NewRecordingTracker returns inner recording up to capacity traces, the
+// oldest ones are evicted first. capacity is at least 1.
+func NewRecordingTracker(inner Tracker, capacity int) *RecordingTracker {
+	return &RecordingTracker{Tracker: inner, recorder: newTraceRecorder(capacity)}
+}
+
+// NewRecordingTrackerFactory wraps the trackers of factory into
+// RecordingTrackers recording into the same buffer, returned by recent.
+func NewRecordingTrackerFactory(factory func() Tracker, capacity int) (newTracker func() Tracker, recent func() []TraceRecord) {
+	recorder := newTraceRecorder(capacity)
+	newTracker = func() Tracker {
+		return &RecordingTracker{Tracker: factory(), recorder: recorder}
+	}
+	return newTracker, recorder.recent
+}
+
+func newTraceRecorder(capacity int) *traceRecorder {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &traceRecorder{records: make([]TraceRecord, capacity)}
+}
+
+// NegotiationContext calls the NegotiationContext of the inner tracker, or its
+// Negotiation if it is not a ContextHandShaker.
+func (r *RecordingTracker) NegotiationContext(ctx context.Context, curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	if h, ok := r.Tracker.(ContextHandShaker); ok {
+		return h.NegotiationContext(ctx, curSeqID, iprot, oprot)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Tracker.Negotiation(curSeqID, iprot, oprot)
+}
+
+func (r *RecordingTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
+	ctx, err := r.Tracker.TryReadRequestHeader(iprot)
+	if err != nil {
+		return ctx, err
+	}
+	reqID, ok := ctx.Value(CtxKeyRequestID).(string)
+	if !ok || NoLogFromContext(ctx) {
+		return ctx, nil
+	}
+	seq, _ := ctx.Value(CtxKeySequenceID).(string)
+	meta, _ := ctx.Value(CtxKeyRequestMeta).(map[string]string)
+	trace := &liveTrace{record: TraceRecord{
+		RequestID: reqID,
+		Seq:       seq,
+		Meta:      copyMeta(meta),
+		Start:     r.now(),
+	}}
+	return context.WithValue(ctx, ctxKeyTrace, trace), nil
+}
+
+func (r *RecordingTracker) TryWriteRequestHeader(ctx context.Context, oprot thrift.TProtocol) error {
+	trace, ok := ctx.Value(ctxKeyTrace).(*liveTrace)
+	if !ok {
+		return r.Tracker.TryWriteRequestHeader(ctx, oprot)
+	}
+	var seq string
+	observed := false
+	ctx = context.WithValue(ctx, ctxKeySeqObserver, func(s string) { seq, observed = s, true })
+	if err := r.Tracker.TryWriteRequestHeader(ctx, oprot); err != nil {
+		return err
+	}
+	if !observed && r.Tracker.RequestHeaderSupported() { // not a SimpleTracker
+		_, seq = r.Tracker.RequestSeqIDFromCtx(ctx)
+		observed = true
+	}
+	if observed {
+		trace.mu.Lock()
+		if !trace.done {
+			trace.record.Calls = append(trace.record.Calls, seq)
+		}
+		trace.mu.Unlock()
+	}
+	return nil
+}
+
+// Finish completes the trace of the request ctx comes from, the context
+// returned by TryReadRequestHeader or one derived from it. Calls made after
+// are not recorded, finishing a trace twice does nothing.
+func (r *RecordingTracker) Finish(ctx context.Context) {
+	trace, ok := ctx.Value(ctxKeyTrace).(*liveTrace)
+	if !ok {
+		return
+	}
+	trace.mu.Lock()
+	if trace.done {
+		trace.mu.Unlock()
+		return
+	}
+	trace.done = true
+	record := trace.record
+	trace.mu.Unlock()
+	record.Duration = r.now().Sub(record.Start)
+	r.recorder.add(record)
+}
+
+func (r *RecordingTracker) now() time.Time {
+	if c, ok := r.Tracker.(clocked); ok {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// Close closes the inner tracker if it is an io.Closer, SimpleTracker is one.
+func (r *RecordingTracker) Close() error {
+	if c, ok := r.Tracker.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Recent returns the completed traces still in the buffer, oldest first.
+func (r *RecordingTracker) Recent() []TraceRecord {
+	return r.recorder.recent()
+}
+
+func (b *traceRecorder) add(record TraceRecord) {
+	b.mu.Lock()
+	b.records[b.next] = record
+	b.next++
+	if b.next == len(b.records) {
+		b.next, b.full = 0, true
+	}
+	b.mu.Unlock()
+}
+
+func (b *traceRecorder) recent() []TraceRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []TraceRecord
+	if b.full {
+		records = append(records, b.records[b.next:]...)
+	}
+	records = append(records, b.records[:b.next]...)
+	for i := range records { // callers may modify them
+		records[i].Calls = append([]string(nil), records[i].Calls...)
+		records[i].Meta = copyMeta(records[i].Meta)
+	}
+	return records
+}
+
+func copyMeta(meta map[string]string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(meta))
+	for k, v := range meta {
+		cp[k] = v
+	}
+	return cp
+}
@@ -0,0 +1,133 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func newMemoryProtocol() thrift.TProtocol {
+	return thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+}
+
+func serveRecorded(t *testing.T, client Tracker, server *RecordingTracker, reqID string) context.Context {
+	t.Helper()
+	ctx := context.WithValue(context.Background(), CtxKeyRequestID, reqID)
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"user": reqID})
+	return passRequestHeader(t, ctx, client, server)
+}
+
+func TestRecordingTrackerRecordsTraces(t *testing.T) {
+	client, inner := upgradedPair(t, nil, nil)
+	server := NewRecordingTracker(inner, 4)
+	for i := 0; i < 3; i++ {
+		server.Finish(serveRecorded(t, client, server, fmt.Sprintf("req-%d", i)))
+	}
+
+	recent := server.Recent()
+	if len(recent) != 3 {
+		t.Fatalf("expect 3 traces, got %d", len(recent))
+	}
+	for i, r := range recent {
+		if want := fmt.Sprintf("req-%d", i); r.RequestID != want || r.Meta["user"] != want {
+			t.Fatalf("trace %d: expect %s, got %+v", i, want, r)
+		}
+		if r.Seq != "1.1" || r.Start.IsZero() || r.Duration < 0 || len(r.Calls) != 0 {
+			t.Fatalf("trace %d: unexpected %+v", i, r)
+		}
+	}
+}
+
+func TestRecordingTrackerRecordsCalls(t *testing.T) {
+	client, inner := upgradedPair(t, nil, nil)
+	server := NewRecordingTracker(inner, 4)
+	downstream, _ := upgradedPair(t, nil, nil)
+	recordingDownstream := NewRecordingTracker(downstream, 1)
+
+	ctx := serveRecorded(t, client, server, "req")
+	for _, tr := range []Tracker{recordingDownstream, downstream, recordingDownstream} {
+		if err := tr.TryWriteRequestHeader(ctx, newMemoryProtocol()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.Finish(ctx)
+	if err := recordingDownstream.TryWriteRequestHeader(ctx, newMemoryProtocol()); err != nil {
+		t.Fatal(err)
+	}
+
+	recent := server.Recent()
+	if len(recent) != 1 {
+		t.Fatalf("expect 1 trace, got %d", len(recent))
+	}
+	if calls := recent[0].Calls; len(calls) != 2 || calls[0] != "1.1.1" || calls[1] != "1.1.3" {
+		t.Fatalf("expect the 2 calls of the recording tracker made before Finish, got %v", calls)
+	}
+	recent[0].Calls[0], recent[0].Meta["user"] = "changed", "changed"
+	if r := server.Recent()[0]; r.Calls[0] != "1.1.1" || r.Meta["user"] != "req" {
+		t.Fatalf("expect Recent to return copies, got %+v", r)
+	}
+}
+
+func TestRecordingTrackerEviction(t *testing.T) {
+	client, inner := upgradedPair(t, nil, nil)
+	server := NewRecordingTracker(inner, 2)
+	for i := 0; i < 5; i++ {
+		server.Finish(serveRecorded(t, client, server, fmt.Sprintf("req-%d", i)))
+	}
+	recent := server.Recent()
+	if len(recent) != 2 || recent[0].RequestID != "req-3" || recent[1].RequestID != "req-4" {
+		t.Fatalf("expect the last 2 traces, got %+v", recent)
+	}
+}
+
+func TestRecordingTrackerNotUpgraded(t *testing.T) {
+	server := NewRecordingTracker(NewSimpleTracker("server"), 2)
+	ctx, err := server.TryReadRequestHeader(newMemoryProtocol())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Finish(ctx)
+	if recent := server.Recent(); len(recent) != 0 {
+		t.Fatalf("expect no trace without request header, got %+v", recent)
+	}
+}
+
+func TestRecordingTrackerFactoryConcurrent(t *testing.T) {
+	newTracker, recent := NewRecordingTrackerFactory(NewSimpleTrackerFactory("server"), 8)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		server := newTracker().(*RecordingTracker)
+		client := NewSimpleTracker("client")
+		handshake(t, client, server)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				reqCtx := context.WithValue(context.Background(), CtxKeyRequestID, fmt.Sprintf("req-%d-%d", i, j))
+				prot := newMemoryProtocol()
+				if err := client.TryWriteRequestHeader(reqCtx, prot); err != nil {
+					t.Error(err)
+					return
+				}
+				ctx, err := server.TryReadRequestHeader(prot)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err := server.TryWriteRequestHeader(ctx, newMemoryProtocol()); err != nil {
+					t.Error(err)
+					return
+				}
+				server.Finish(ctx)
+				recent()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := len(recent()); n != 8 {
+		t.Fatalf("expect the buffer to be bounded to 8 traces, got %d", n)
+	}
+}
@@ -0,0 +1,58 @@
+package tracker
+
+import (
+	"context"
+	"unicode/utf8"
+)
+
+// MetaKeyOriginRoute is the reserved meta key carrying the route of the HTTP
+// request a call originates from, "GET /orders/{id}" for example, set by the
+// gateway and propagated unchanged through all the hops.
+const MetaKeyOriginRoute = "origin_route"
+
+// MaxOriginRouteLength is the maximum length, in bytes, of an origin route,
+// longer ones are truncated.
+const MaxOriginRouteLength = 256
+
+const ctxKeyOriginRoute ctxKey = "__thrift_tracking_origin_route"
+
+// WithOriginRoute returns a context that attaches the origin route to the
+// requests made with it, truncated to MaxOriginRouteLength. Set the route
+// template rather than the URL, which may carry IDs and secrets.
+func WithOriginRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, ctxKeyOriginRoute, truncateUTF8(route, MaxOriginRouteLength))
+}
+
+// OriginRouteFromContext returns the origin route of the current request, it
+// is empty if none has been set.
+func OriginRouteFromContext(ctx context.Context) string {
+	route, _ := ctx.Value(ctxKeyOriginRoute).(string)
+	return route
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func extractOriginRoute(ctx context.Context, meta map[string]string) (context.Context, error) {
+	if route := meta[MetaKeyOriginRoute]; route != "" {
+		ctx = WithOriginRoute(ctx, route)
+	}
+	return ctx, nil
+}
+
+func injectOriginRoute(ctx context.Context, meta map[string]string) error {
+	if route := OriginRouteFromContext(ctx); route != "" {
+		meta[MetaKeyOriginRoute] = route
+	} else {
+		delete(meta, MetaKeyOriginRoute)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package tracker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func TestOriginRoutePropagation(t *testing.T) {
+	ctx := WithOriginRoute(context.Background(), "GET /orders/{id}")
+	for hop := 0; hop < 3; hop++ {
+		client, server := upgradedPair(t, nil, nil)
+		ctx = passRequestHeader(t, ctx, client, server)
+		if route := OriginRouteFromContext(ctx); route != "GET /orders/{id}" {
+			t.Fatalf("hop %d: expect the route to be propagated unchanged, got %q", hop, route)
+		}
+	}
+	client, server := upgradedPair(t, nil, nil)
+	if route := OriginRouteFromContext(passRequestHeader(t, context.Background(), client, server)); route != "" {
+		t.Fatalf("expect no route, got %q", route)
+	}
+}
+
+func TestOriginRouteLength(t *testing.T) {
+	long := "GET /" + strings.Repeat("é", MaxOriginRouteLength) // 2 bytes per rune
+	route := OriginRouteFromContext(WithOriginRoute(context.Background(), long))
+	if len(route) > MaxOriginRouteLength || len(route) < MaxOriginRouteLength-1 || !strings.HasPrefix(long, route) {
+		t.Fatalf("expect the route to be truncated to %d bytes, got %d", MaxOriginRouteLength, len(route))
+	}
+	if !strings.HasSuffix(route, "é") {
+		t.Fatalf("expect no rune to be split, got %q", route[len(route)-4:])
+	}
+
+	// From a peer not bounding it.
+	_, server := upgradedPair(t, nil, nil)
+	header := tracking.NewRequestHeader()
+	header.Meta = map[string]string{MetaKeyOriginRoute: long}
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := header.Write(prot); err != nil {
+		t.Fatal(err)
+	}
+	sctx, err := server.TryReadRequestHeader(prot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := OriginRouteFromContext(sctx); got != route {
+		t.Fatalf("expect the incoming route to be truncated, got %d bytes", len(got))
+	}
+}
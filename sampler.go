@@ -0,0 +1,109 @@
+package tracker
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+)
+
+// MetaKeySampled is the reserved meta key carrying the sampling decision of a
+// request, "1" or "0", made once at the root and honored by all the hops.
+const MetaKeySampled = "sampled"
+
+const ctxKeySampled ctxKey = "__thrift_tracking_sampled"
+
+// Sampler decides whether the request with the ID requestID is tracked in
+// full, see WithSampler.
+type Sampler interface {
+	ShouldSample(requestID string) bool
+}
+
+type constSampler bool
+
+func (s constSampler) ShouldSample(string) bool {
+	return bool(s)
+}
+
+var (
+	// AlwaysSample samples every request, as a tracker without Sampler does.
+	AlwaysSample Sampler = constSampler(true)
+	// NeverSample samples no request.
+	NeverSample Sampler = constSampler(false)
+)
+
+type rateSampler struct {
+	threshold uint64
+}
+
+// RateSampler samples the fraction of the requests, 0 for none up to 1 for
+// all. The decision is a hash of the request ID, every tracker sampling at the
+// same rate decides the same way for a request.
+func RateSampler(fraction float64) Sampler {
+	switch {
+	case fraction >= 1:
+		return AlwaysSample
+	case fraction <= 0 || math.IsNaN(fraction):
+		return NeverSample
+	}
+	return rateSampler{threshold: uint64(fraction * math.MaxUint64)}
+}
+
+func (s rateSampler) ShouldSample(requestID string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(requestID))
+	x := h.Sum64() // the high bits of FNV barely change over similar IDs, mix them
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x < s.threshold
+}
+
+// WithSampled returns a context with the sampling decision of the request
+// made, by an upstream outside Thrift for example. The requests made with it
+// follow the decision rather than asking the Sampler of their tracker.
+func WithSampled(ctx context.Context, sampled bool) context.Context {
+	return context.WithValue(ctx, ctxKeySampled, sampled)
+}
+
+// SampledFromContext returns the sampling decision of the current request,
+// ok is false if none has been made yet.
+func SampledFromContext(ctx context.Context) (sampled, ok bool) {
+	sampled, ok = ctx.Value(ctxKeySampled).(bool)
+	return sampled, ok
+}
+
+// sampled decides on the request with the ID requestID, decided tells
+// whether the decision is to be propagated.
+func (t *SimpleTracker) sampled(ctx context.Context, requestID string) (sampled, decided bool) {
+	if sampled, ok := SampledFromContext(ctx); ok {
+		return sampled, true
+	}
+	if t.sampler == nil {
+		return true, false
+	}
+	return t.sampler.ShouldSample(requestID), true
+}
+
+func extractSampled(ctx context.Context, meta map[string]string) (context.Context, error) {
+	switch meta[MetaKeySampled] {
+	case "1":
+		ctx = WithSampled(ctx, true)
+	case "0":
+		ctx = WithSampled(ctx, false)
+	}
+	return ctx, nil
+}
+
+func injectSampled(ctx context.Context, meta map[string]string) error {
+	delete(meta, MetaKeySampled) // written along with the decision
+	return nil
+}
+
+func sampledMeta(sampled bool) string {
+	if sampled {
+		return "1"
+	}
+	return "0"
+}
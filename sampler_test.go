@@ -0,0 +1,104 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+func TestRateSampler(t *testing.T) {
+	for _, c := range []struct {
+		sampler Sampler
+		min     int
+		max     int
+	}{
+		{RateSampler(0), 0, 0},
+		{RateSampler(-1), 0, 0},
+		{RateSampler(1), 1000, 1000},
+		{RateSampler(0.25), 200, 300},
+		{AlwaysSample, 1000, 1000},
+		{NeverSample, 0, 0},
+	} {
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if c.sampler.ShouldSample(fmt.Sprintf("req-%d", i)) {
+				n++
+			}
+		}
+		if n < c.min || n > c.max {
+			t.Fatalf("%#v: expect %d to %d sampled, got %d", c.sampler, c.min, c.max, n)
+		}
+	}
+	s := RateSampler(0.5)
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("req-%d", i)
+		if s.ShouldSample(id) != RateSampler(0.5).ShouldSample(id) {
+			t.Fatal("expect the decision to depend on the request ID only")
+		}
+	}
+}
+
+func readHeader(t *testing.T, client Tracker, ctx context.Context) *tracking.RequestHeader {
+	t.Helper()
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatal(err)
+	}
+	header := tracking.NewRequestHeader()
+	if err := header.Read(prot); err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func TestSamplerNotSampled(t *testing.T) {
+	client, server := upgradedPair(t, []Option{WithSampler(NeverSample)}, nil)
+	ctx := WithRequestID(context.Background(), "req")
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"k": "v"})
+	ctx, _ = WithLocale(ctx, "de-DE")
+
+	header := readHeader(t, client, ctx)
+	if header.RequestID != "req" || len(header.Meta) != 1 || header.Meta[MetaKeySampled] != "0" {
+		t.Fatalf("expect the ID and the decision only, got %+v", header)
+	}
+
+	// Downstream follows, whatever its sampler.
+	sctx := passRequestHeader(t, ctx, client, server)
+	if sampled, ok := SampledFromContext(sctx); !ok || sampled {
+		t.Fatalf("expect the request not sampled, got %v %v", sampled, ok)
+	}
+	downstream, _ := upgradedPair(t, []Option{WithSampler(AlwaysSample)}, nil)
+	if header := readHeader(t, downstream, sctx); header.Meta[MetaKeySampled] != "0" || header.Seq != "1.1.1" {
+		t.Fatalf("expect the decision of the root to stick, got %+v", header)
+	}
+	if fields := LogFields(sctx, server); fields["sampled"] != false {
+		t.Fatalf("expect the decision in the log fields, got %v", fields)
+	}
+}
+
+func TestSamplerSampled(t *testing.T) {
+	client, server := upgradedPair(t, []Option{WithSampler(AlwaysSample)}, nil)
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"k": "v"})
+	if header := readHeader(t, client, ctx); header.Meta["k"] != "v" || header.Meta[MetaKeySampled] != "1" {
+		t.Fatalf("expect the full header and the decision, got %+v", header)
+	}
+	sctx := passRequestHeader(t, ctx, client, server)
+	downstream, _ := upgradedPair(t, []Option{WithSampler(NeverSample)}, nil)
+	if header := readHeader(t, downstream, sctx); header.Meta["k"] != "v" || header.Meta[MetaKeySampled] != "1" {
+		t.Fatalf("expect the decision of the root to stick, got %+v", header)
+	}
+
+	// Without a sampler nor a decision, nothing is written.
+	plain, _ := upgradedPair(t, nil, nil)
+	if header := readHeader(t, plain, ctx); header.Meta["k"] != "v" || header.Meta[MetaKeySampled] != "" {
+		t.Fatalf("expect no decision, got %+v", header)
+	}
+	// A forged decision is not propagated.
+	forged := context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{MetaKeySampled: "0"})
+	if header := readHeader(t, plain, forged); header.Meta[MetaKeySampled] != "" {
+		t.Fatalf("expect the forged decision to be dropped, got %+v", header)
+	}
+}
@@ -0,0 +1,92 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// writeFutureHeader writes a RequestHeader as a newer schema would, with an
+// unknown codec and an unknown field.
+func writeFutureHeader(prot thrift.TProtocol, meta map[string]string) {
+	prot.WriteStructBegin("RequestHeader")
+	prot.WriteFieldBegin("request_id", thrift.STRING, 1)
+	prot.WriteString("future")
+	prot.WriteFieldEnd()
+	prot.WriteFieldBegin("seq", thrift.STRING, 2)
+	prot.WriteString("1.1")
+	prot.WriteFieldEnd()
+	prot.WriteFieldBegin("meta", thrift.MAP, 3)
+	prot.WriteMapBegin(thrift.STRING, thrift.STRING, len(meta))
+	for k, v := range meta {
+		prot.WriteString(k)
+		prot.WriteString(v)
+	}
+	prot.WriteMapEnd()
+	prot.WriteFieldEnd()
+	prot.WriteFieldBegin("meta_blob", thrift.STRING, 4)
+	prot.WriteBinary([]byte{0xde, 0xad})
+	prot.WriteFieldEnd()
+	prot.WriteFieldBegin("meta_codec", thrift.STRING, 5)
+	prot.WriteString("codec-from-the-future")
+	prot.WriteFieldEnd()
+	prot.WriteFieldBegin("schema_ver", thrift.I32, 6)
+	prot.WriteI32(HeaderSchemaVersion + 1)
+	prot.WriteFieldEnd()
+	prot.WriteFieldBegin("unknown", thrift.LIST, 99)
+	prot.WriteListBegin(thrift.I64, 1)
+	prot.WriteI64(42)
+	prot.WriteListEnd()
+	prot.WriteFieldEnd()
+	prot.WriteFieldStop()
+	prot.WriteStructEnd()
+}
+
+func TestCurrentSchemaHeader(t *testing.T) {
+	client, server := upgradedPair(t, []Option{WithMetaCodec(JSONMetaCodec)}, nil)
+	ctx := WithBudget(context.Background(), 5)
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"k": "v"})
+	sctx := passRequestHeader(t, ctx, client, server)
+	if metaFromContext(sctx)["k"] != "v" {
+		t.Fatalf("expect the blob to be decoded, got %v", metaFromContext(sctx))
+	}
+	if n, _ := BudgetFromContext(sctx); n != 4 {
+		t.Fatalf("expect budget 4, got %d", n)
+	}
+
+	// An unknown codec is an error with the current schema.
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	header := tracking.NewRequestHeader()
+	header.MetaCodec = thrift.StringPtr("codec-from-the-future")
+	header.SchemaVer = thrift.Int32Ptr(HeaderSchemaVersion)
+	header.Write(prot)
+	if _, err := server.TryReadRequestHeader(prot); err == nil {
+		t.Fatal("expect an unknown codec to fail with the current schema")
+	}
+}
+
+func TestFutureSchemaHeader(t *testing.T) {
+	_, server := upgradedPair(t, nil, nil)
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	writeFutureHeader(prot, map[string]string{MetaKeyBudget: "5", MetaKeyHopCount: "2"})
+	prot.WriteI32(42)
+
+	sctx, err := server.TryReadRequestHeader(prot)
+	if err != nil {
+		t.Fatalf("expect a future schema header to be read, got %v", err)
+	}
+	if id := sctx.Value(CtxKeyRequestID); id != "future" {
+		t.Fatalf("expect the known fields to be read, got request ID %v", id)
+	}
+	if n, _ := BudgetFromContext(sctx); n != 4 {
+		t.Fatalf("expect the budget to be enforced, got %d", n)
+	}
+	if n := HopCountFromContext(sctx); n != 3 {
+		t.Fatalf("expect the hop count to be kept, got %d", n)
+	}
+	if next, err := prot.ReadI32(); err != nil || next != 42 {
+		t.Fatalf("expect the unknown fields to be skipped, got %d, %v", next, err)
+	}
+}
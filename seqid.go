@@ -0,0 +1,53 @@
+package tracker
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// SeqIDAllocator hands out the seqIDs of a connection from two disjoint
+// ranges, for the client wrappers numbering the messages themselves:
+//
+//   - the calls count up from 1 to math.MaxInt32, as the thrift clients do,
+//     then start over from 1;
+//   - the handshakes count down from HandshakeSeqID to math.MinInt32, then
+//     start over from HandshakeSeqID.
+//
+// A handshake run again in between the calls, after Reset for one, can not
+// reuse the seqID of a call then, whose reply would trip the BAD_SEQUENCE_ID
+// check of either. The seqIDs are only ever compared for equality, to the one
+// of the message in flight, so the wraparounds are harmless: a stale reply of
+// before a wrap does not match the current seqID. The zero value is ready to
+// use and safe for concurrent use.
+type SeqIDAllocator struct {
+	call      int32
+	handshake int32
+}
+
+// NextCall returns the seqID of the next call.
+func (a *SeqIDAllocator) NextCall() int32 {
+	for {
+		cur := atomic.LoadInt32(&a.call)
+		next := cur + 1
+		if cur == math.MaxInt32 {
+			next = 1
+		}
+		if atomic.CompareAndSwapInt32(&a.call, cur, next) {
+			return next
+		}
+	}
+}
+
+// NextHandshake returns the seqID of the next handshake.
+func (a *SeqIDAllocator) NextHandshake() int32 {
+	for {
+		cur := atomic.LoadInt32(&a.handshake)
+		next := cur - 1
+		if cur == math.MinInt32 || cur >= 0 {
+			next = HandshakeSeqID
+		}
+		if atomic.CompareAndSwapInt32(&a.handshake, cur, next) {
+			return next
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package tracker
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+func TestSeqIDAllocatorRanges(t *testing.T) {
+	var a SeqIDAllocator
+	if id := a.NextHandshake(); id != HandshakeSeqID {
+		t.Fatalf("expect the first handshake on %d, got %d", HandshakeSeqID, id)
+	}
+	if id := a.NextCall(); id != 1 {
+		t.Fatalf("expect the first call on 1, got %d", id)
+	}
+	if id := a.NextHandshake(); id != HandshakeSeqID-1 {
+		t.Fatalf("expect the handshakes to count down, got %d", id)
+	}
+
+	a = SeqIDAllocator{call: math.MaxInt32, handshake: math.MinInt32}
+	if id := a.NextCall(); id != 1 {
+		t.Fatalf("expect the calls to start over from 1, got %d", id)
+	}
+	if id := a.NextHandshake(); id != HandshakeSeqID {
+		t.Fatalf("expect the handshakes to start over from %d, got %d", HandshakeSeqID, id)
+	}
+}
+
+// TestSeqIDAllocatorInterleaved negotiates again in between the calls of a
+// connection, the server checking every seqID is new.
+func TestSeqIDAllocatorInterleaved(t *testing.T) {
+	cprot, sprot := newProtocolPair(t)
+	client := NewSimpleTracker("client").(*SimpleTracker)
+	server := NewSimpleTracker("server")
+	const rounds, calls = 3, 4
+
+	done := make(chan error, 1)
+	go func() {
+		seen := make(map[int32]bool)
+		for i := 0; i < rounds*(calls+1); i++ {
+			name, _, seqID, err := sprot.ReadMessageBegin()
+			if err != nil {
+				done <- err
+				return
+			}
+			if seen[seqID] {
+				done <- thrift.NewTApplicationException(thrift.BAD_SEQUENCE_ID, name)
+				return
+			}
+			seen[seqID] = true
+			if name == TrackingAPIName {
+				_, err = server.TryUpgrade(seqID, sprot, sprot)
+			} else {
+				sprot.Skip(thrift.STRUCT)
+				sprot.ReadMessageEnd()
+				sprot.WriteMessageBegin(name, thrift.REPLY, seqID)
+				sprot.WriteStructBegin("result")
+				sprot.WriteFieldStop()
+				sprot.WriteStructEnd()
+				sprot.WriteMessageEnd()
+				err = sprot.Flush()
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	var seqIDs SeqIDAllocator
+	for r := 0; r < rounds; r++ {
+		client.Reset()
+		if err := client.Negotiation(seqIDs.NextHandshake(), cprot, cprot); err != nil {
+			t.Fatal(err)
+		}
+		for c := 0; c < calls; c++ {
+			seqID := seqIDs.NextCall()
+			cprot.WriteMessageBegin("ping", thrift.CALL, seqID)
+			cprot.WriteStructBegin("args")
+			cprot.WriteFieldStop()
+			cprot.WriteStructEnd()
+			cprot.WriteMessageEnd()
+			if err := cprot.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, got, err := cprot.ReadMessageBegin(); err != nil || got != seqID {
+				t.Fatalf("expect the reply of %d, got %d %v", seqID, got, err)
+			}
+			cprot.Skip(thrift.STRUCT)
+			cprot.ReadMessageEnd()
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestNegotiationSeqIDWraparound negotiates with the seqIDs at the edges of
+// int32, and checks a stale reply from before the wrap is not taken for the
+// current one.
+func TestNegotiationSeqIDWraparound(t *testing.T) {
+	for _, seqID := range []int32{math.MaxInt32, math.MinInt32, 0} {
+		cprot, sprot := newProtocolPair(t)
+		done := make(chan error, 1)
+		go func() { done <- serveUpgrade(NewSimpleTracker("server"), sprot) }()
+		client := NewSimpleTracker("client")
+		if err := client.Negotiation(seqID, cprot, cprot); err != nil {
+			t.Fatalf("seqID %d: %v", seqID, err)
+		}
+		if err := <-done; err != nil || !client.RequestHeaderSupported() {
+			t.Fatalf("seqID %d: expect the connection upgraded, got %v", seqID, err)
+		}
+	}
+
+	m := NewNegotiationFSM(math.MinInt32) // the seqID after math.MaxInt32
+	m.Step(NegotiationEvent{Kind: EventStart})
+	m.Step(NegotiationEvent{Kind: EventArgsWritten})
+	_, err := m.Step(NegotiationEvent{Kind: EventMessageBegin, Method: TrackingAPIName, TypeID: thrift.REPLY, SeqID: math.MaxInt32})
+	if nerr, ok := err.(*NegotiationError); !ok || nerr.Reason != ReasonBadSequenceID {
+		t.Fatalf("expect the stale reply rejected, got %v", err)
+	}
+}
+
+func TestSeqIDAllocatorConcurrentWrap(t *testing.T) {
+	a := SeqIDAllocator{call: math.MaxInt32 - 50, handshake: math.MinInt32 + 50}
+	var mu sync.Mutex
+	calls, handshakes := make(map[int32]bool), make(map[int32]bool)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				c, h := a.NextCall(), a.NextHandshake()
+				mu.Lock()
+				if c <= 0 || h >= 0 || calls[c] || handshakes[h] {
+					t.Errorf("unexpected seqIDs %d %d", c, h)
+				}
+				calls[c], handshakes[h] = true, true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if !calls[1] || !calls[math.MaxInt32] || !handshakes[HandshakeSeqID] || !handshakes[math.MinInt32] {
+		t.Fatal("expect both ranges to wrap around")
+	}
+}
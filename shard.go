@@ -0,0 +1,45 @@
+package tracker
+
+import (
+	"context"
+)
+
+// MetaKeyShardKey is the reserved meta key carrying the partition key of a
+// request, so that every hop routes it to the same shard. It is set once, by
+// the edge, and propagated unchanged through all the hops.
+const MetaKeyShardKey = "shard_key"
+
+const ctxKeyShardKey ctxKey = "__thrift_tracking_shard_key"
+
+// WithShardKey returns a context that attaches the shard key to the requests
+// made with it. The shard key can not be changed once set: ctx is returned as
+// is if it already carries one, from an incoming request for example.
+func WithShardKey(ctx context.Context, key string) context.Context {
+	if _, ok := ShardKeyFromContext(ctx); ok || key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKeyShardKey, key)
+}
+
+// ShardKeyFromContext returns the shard key of the current request, for the
+// routing decisions of servers.
+func ShardKeyFromContext(ctx context.Context) (key string, ok bool) {
+	key, ok = ctx.Value(ctxKeyShardKey).(string)
+	return
+}
+
+func extractShardKey(ctx context.Context, meta map[string]string) (context.Context, error) {
+	if key := meta[MetaKeyShardKey]; key != "" {
+		ctx = context.WithValue(ctx, ctxKeyShardKey, key)
+	}
+	return ctx, nil
+}
+
+func injectShardKey(ctx context.Context, meta map[string]string) error {
+	if key, ok := ShardKeyFromContext(ctx); ok {
+		meta[MetaKeyShardKey] = key
+	} else {
+		delete(meta, MetaKeyShardKey)
+	}
+	return nil
+}
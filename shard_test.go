@@ -0,0 +1,38 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+)
+
+func TestShardKeyPropagation(t *testing.T) {
+	ctx := WithShardKey(context.Background(), "user-42")
+	for hop := 0; hop < 3; hop++ {
+		client, server := upgradedPair(t, nil, nil)
+		ctx = passRequestHeader(t, ctx, client, server)
+		if key, ok := ShardKeyFromContext(ctx); !ok || key != "user-42" {
+			t.Fatalf("hop %d: expect shard key user-42, got %q(%v)", hop, key, ok)
+		}
+	}
+
+	client, server := upgradedPair(t, nil, nil)
+	if key, ok := ShardKeyFromContext(passRequestHeader(t, context.Background(), client, server)); ok {
+		t.Fatalf("expect no shard key, got %q", key)
+	}
+}
+
+func TestShardKeyImmutable(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	sctx := passRequestHeader(t, WithShardKey(context.Background(), "user-42"), client, server)
+
+	// An intermediate service trying to reroute the request.
+	sctx = WithShardKey(sctx, "user-7")
+	meta := metaFromContext(sctx)
+	meta[MetaKeyShardKey] = "user-7"
+	sctx = context.WithValue(sctx, CtxKeyRequestMeta, meta)
+
+	downstream, next := upgradedPair(t, nil, nil)
+	if key, _ := ShardKeyFromContext(passRequestHeader(t, sctx, downstream, next)); key != "user-42" {
+		t.Fatalf("expect the shard key not to be changed by intermediate hops, got %q", key)
+	}
+}
@@ -0,0 +1,82 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// ErrNoTrackingContext is returned by MarshalTrackingContext for a context
+// without request ID, and by UnmarshalTrackingContext for a snapshot without
+// one.
+var ErrNoTrackingContext = errors.New("tracker: no tracking context")
+
+// MarshalTrackingContext returns a snapshot of the tracking of ctx, for it to
+// cross a boundary thrift does not, an HTTP call or a message queue, and be
+// restored with UnmarshalTrackingContext: the snapshot is the request header
+// a call made with ctx would carry, with the meta and the reserved keys, in
+// the compact protocol of thrift. Like a call, it takes the next seq of ctx,
+// the calls made on the far side are numbered under it.
+func MarshalTrackingContext(ctx context.Context) ([]byte, error) {
+	id, ok := RequestIDFromContext(ctx)
+	if !ok {
+		return nil, ErrNoTrackingContext
+	}
+	header := tracking.NewRequestHeader()
+	header.SchemaVer = thrift.Int32Ptr(HeaderSchemaVersion)
+	header.RequestID, header.Seq = id.String(), nextSeq(ctx)
+	meta := mergeMeta(ctx)
+	header.Meta = make(map[string]string, len(meta))
+	for k, v := range meta {
+		header.Meta[k] = v
+	}
+	if err := injectReservedMeta(ctx, header.Meta); err != nil {
+		return nil, err
+	}
+	if sampled, ok := SampledFromContext(ctx); ok {
+		header.Meta[MetaKeySampled] = sampledMeta(sampled)
+	}
+	buf := thrift.NewTMemoryBuffer()
+	if err := header.Write(thrift.NewTCompactProtocol(buf)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalTrackingContext returns ctx with the tracking of the snapshot of
+// MarshalTrackingContext in data, as a server reading its request header
+// would: the calls made with it carry on the request.
+func UnmarshalTrackingContext(ctx context.Context, data []byte) (context.Context, error) {
+	buf := thrift.NewTMemoryBufferLen(len(data))
+	buf.Write(data)
+	header := tracking.NewRequestHeader()
+	if err := readRequestHeader(thrift.NewTCompactProtocol(buf), header, false); err != nil {
+		return ctx, err
+	}
+	if buf.Len() > 0 {
+		return ctx, errors.New("tracker: trailing bytes after the tracking context")
+	}
+	if header.GetRequestID() == "" {
+		return ctx, ErrNoTrackingContext
+	}
+	id, err := ParseRequestID(header.GetRequestID())
+	if err != nil {
+		return ctx, err
+	}
+	seq, err := ParseSeq(header.GetSeq())
+	if err != nil {
+		return ctx, err
+	}
+	meta := header.GetMeta()
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	ctx = context.WithValue(ctx, CtxKeyRequestID, id.String())
+	ctx = context.WithValue(ctx, CtxKeySequenceID, seq.String())
+	ctx = context.WithValue(ctx, ctxKeySeqCounter, new(seqCounter))
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, meta)
+	ctx = context.WithValue(ctx, ctxKeyInheritedMeta, meta)
+	return extractReservedMeta(ctx, meta, nil)
+}
@@ -0,0 +1,83 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTrackingContextRoundTrip(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ts := time.Unix(1500000000, 0)
+	ctx := WithRequestMeta(WithRequestID(context.Background(), "req"), "k", "v")
+	ctx = WithSampled(WithEntryTimestamp(ctx, ts), true)
+	ctx = passRequestHeader(t, ctx, client, server) // a server handling the request
+	seq := SeqFromContext(ctx)
+
+	data, err := MarshalTrackingContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker, err := UnmarshalTrackingContext(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := RequestIDFromContext(worker); id != "req" {
+		t.Fatalf("expect the request ID, got %q", id)
+	}
+	if got := SeqFromContext(worker); !got.IsChildOf(seq) {
+		t.Fatalf("expect a child of %v, got %v", seq, got)
+	}
+	if metaFromContext(worker)["k"] != "v" {
+		t.Fatalf("expect the meta, got %v", metaFromContext(worker))
+	}
+	if sampled, ok := SampledFromContext(worker); !ok || !sampled {
+		t.Fatalf("expect the sampling decision, got %v %v", sampled, ok)
+	}
+	if got, _ := EntryTimestampFromContext(worker); !got.Equal(ts) {
+		t.Fatalf("expect the entry timestamp %v, got %v", ts, got)
+	}
+
+	// The snapshot takes the next seq, like a call.
+	if next, _ := MarshalTrackingContext(ctx); string(next) == string(data) {
+		t.Fatal("expect another seq for another snapshot")
+	}
+
+	// Back into thrift, the calls of the worker carry on the request.
+	client, server = upgradedPair(t, nil, nil)
+	sctx := passRequestHeader(t, worker, client, server)
+	if id, _ := RequestIDFromContext(sctx); id != "req" {
+		t.Fatalf("expect the request ID downstream, got %q", id)
+	}
+	if got := SeqFromContext(sctx); !got.IsChildOf(SeqFromContext(worker)) {
+		t.Fatalf("expect a child of %v downstream, got %v", SeqFromContext(worker), got)
+	}
+	if metaFromContext(sctx)["k"] != "v" {
+		t.Fatalf("expect the meta downstream, got %v", metaFromContext(sctx))
+	}
+}
+
+func TestTrackingContextErrors(t *testing.T) {
+	if _, err := MarshalTrackingContext(context.Background()); err != ErrNoTrackingContext {
+		t.Fatalf("expect ErrNoTrackingContext, got %v", err)
+	}
+	data, err := MarshalTrackingContext(WithRequestID(context.Background(), "req"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"truncated": data[:len(data)-2],
+		"trailing":  append(data[:len(data):len(data)], 0),
+		"garbage":   []byte("not a snapshot"),
+		"empty":     nil,
+	} {
+		base := context.WithValue(context.Background(), ctxKey("base"), true)
+		ctx, err := UnmarshalTrackingContext(base, data)
+		if err == nil {
+			t.Fatalf("%s: expect an error", name)
+		}
+		if ctx != base {
+			t.Fatalf("%s: expect the context as is", name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package tracker
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionStats tells how many trackers, one per connection with the
+// factories, are alive in the process and how many of them got upgraded by
+// the handshake, the tracking coverage of the connections.
+type ConnectionStats struct {
+	Live     int64
+	Upgraded int64
+}
+
+var liveTrackers, upgradedTrackers int64
+
+// CurrentConnectionStats returns the process-wide ConnectionStats. A tracker
+// counts as alive from its creation until its Close, trackers dropped
+// without Close are never discounted.
+func CurrentConnectionStats() ConnectionStats {
+	return ConnectionStats{
+		Live:     atomic.LoadInt64(&liveTrackers),
+		Upgraded: atomic.LoadInt64(&upgradedTrackers),
+	}
+}
+
+// ConnectionSummary is what happened to the connection of a tracker over its
+// lifetime, given to the observer of WithOnClose.
+type ConnectionSummary struct {
+	// Upgraded tells whether a handshake ever upgraded the connection, a
+	// Reset in between included.
+	Upgraded bool
+	// Poisoned tells whether the connection got poisoned, see Poisoned.
+	Poisoned bool
+	// Lifetime is the time from the creation of the tracker to its Close.
+	Lifetime time.Duration
+}
+
+// Close tells the tracker its connection is gone, for CurrentConnectionStats
+// and the observer of WithOnClose. The factories make one tracker per
+// connection, a pool calls Close as it closes or evicts a connection. The
+// tracker must not be used afterwards, closing it twice does nothing.
+func (t *SimpleTracker) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	atomic.AddInt64(&liveTrackers, -1)
+	if t.upgraded {
+		atomic.AddInt64(&upgradedTrackers, -1)
+	}
+	summary := ConnectionSummary{Upgraded: t.everUpgraded, Poisoned: t.poisoned}
+	t.mu.Unlock()
+	if t.onClose != nil {
+		summary.Lifetime = t.now().Sub(t.createdAt)
+		t.onClose(summary)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectionStats(t *testing.T) {
+	base := CurrentConnectionStats()
+	delta := func() ConnectionStats {
+		cur := CurrentConnectionStats()
+		return ConnectionStats{Live: cur.Live - base.Live, Upgraded: cur.Upgraded - base.Upgraded}
+	}
+
+	newTracker := NewSimpleTrackerFactory("server")
+	var upgraded, plain []*SimpleTracker
+	for i := 0; i < 3; i++ { // connections negotiating
+		client, server := NewSimpleTracker("client"), newTracker()
+		handshake(t, client, server)
+		client.(*SimpleTracker).Close()
+		upgraded = append(upgraded, server.(*SimpleTracker))
+	}
+	for i := 0; i < 2; i++ { // connections from clients not supporting tracking
+		plain = append(plain, newTracker().(*SimpleTracker))
+	}
+	if got := delta(); got != (ConnectionStats{Live: 5, Upgraded: 3}) {
+		t.Fatalf("expect 5 live connections, 3 upgraded, got %+v", got)
+	}
+
+	upgraded[0].Close()
+	upgraded[0].Close()
+	plain[0].Close()
+	if got := delta(); got != (ConnectionStats{Live: 3, Upgraded: 2}) {
+		t.Fatalf("expect 3 live connections, 2 upgraded, got %+v", got)
+	}
+
+	// A closed tracker upgrading late is not counted anymore.
+	client := NewSimpleTracker("client").(*SimpleTracker)
+	handshake(t, client, plain[0])
+	for _, tr := range append(upgraded[1:], plain[1], client) {
+		tr.Close()
+	}
+	if got := delta(); got != (ConnectionStats{}) {
+		t.Fatalf("expect no connection left, got %+v", got)
+	}
+}
+
+func TestOnClose(t *testing.T) {
+	clock := newFakeClock()
+	var summaries []ConnectionSummary
+	onClose := WithOnClose(func(s ConnectionSummary) { summaries = append(summaries, s) })
+	newTracker := NewSimpleTrackerFactory("client", onClose, WithClock(clock))
+
+	upgraded := newTracker().(*SimpleTracker)
+	handshake(t, upgraded, NewSimpleTracker("server"))
+	upgraded.Reset()
+	clock.Advance(time.Minute)
+	upgraded.Close()
+	upgraded.Close()
+	NewRecordingTracker(newTracker(), 1).Close()
+
+	if len(summaries) != 2 {
+		t.Fatalf("expect a summary per tracker closed, got %+v", summaries)
+	}
+	if s := summaries[0]; !s.Upgraded || s.Poisoned || s.Lifetime != time.Minute {
+		t.Fatalf("expect the upgraded connection summarized, got %+v", s)
+	}
+	if s := summaries[1]; s.Upgraded || s.Lifetime != 0 {
+		t.Fatalf("expect the connection never tracked, got %+v", s)
+	}
+}
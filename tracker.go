@@ -2,20 +2,29 @@ package tracker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/apache/thrift/lib/go/thrift"
 	"github.com/damnever/thrift-tracker/tracking"
-	"github.com/google/uuid"
 )
 
 type ctxKey string
 
 const (
-	CtxKeySequenceID  ctxKey = "__thrift_tracking_sequence_id"
-	CtxKeyRequestID   ctxKey = "__thrift_tracking_request_id"
+	CtxKeySequenceID ctxKey = "__thrift_tracking_sequence_id"
+	CtxKeyRequestID  ctxKey = "__thrift_tracking_request_id"
+	// CtxKeyRequestMeta holds the meta, a map[string]string, of the incoming
+	// request for handlers, and of the calls made with the context. The meta
+	// of the incoming request is propagated anyway, the one set here is merged
+	// over it, use a MetaTransform to drop keys.
 	CtxKeyRequestMeta ctxKey = "__thrift_tracking_request_meta"
+	// CtxKeyAbortNegotiation holds a chan struct{}, closing it aborts an in-progress NegotiationContext.
+	CtxKeyAbortNegotiation ctxKey = "__thrift_tracking_abort_negotiation"
 	// CtxKeyResponseMeta           ctxKey = "__thrift_tracking_response_meta"
 	TrackingAPIName string = "__thriftpy_tracing_method_name__v2"
 )
@@ -27,6 +36,12 @@ type HandShaker interface {
 	// ResponseHeaderSupported() bool
 }
 
+// ContextHandShaker is implemented by the HandShakers able to abort an
+// in-progress negotiation, SimpleTracker is one.
+type ContextHandShaker interface {
+	NegotiationContext(ctx context.Context, curSeqID int32, iprot, oprot thrift.TProtocol) error
+}
+
 type Tracker interface {
 	HandShaker
 
@@ -37,101 +52,473 @@ type Tracker interface {
 	// TryWriteResponseHeader(ctx context.Context, oprot thrift.TProtocol) error
 }
 
+// HeaderSchemaVersion is the schema version of the RequestHeader written by
+// this package. It evolves independently of the handshake, readers use it to
+// tell whether a header comes from a newer writer.
+const HeaderSchemaVersion int32 = 1
+
+func headerSchemaVersion(header *tracking.RequestHeader) int32 {
+	if !header.IsSetSchemaVer() {
+		return HeaderSchemaVersion
+	}
+	return header.GetSchemaVer()
+}
+
+// NewTrackerFactoryFunc returns a factory of trackers for the app name, the
+// factory is called once per connection, see SimpleTracker.
 type NewTrackerFactoryFunc func(name string) func() Tracker
 
+// SimpleTracker is the Tracker of a single connection: the handshake state,
+// the outcome of the negotiation, belongs to the connection, use a factory
+// to get one tracker per connection, NewSimpleTrackerFactory for one. A
+// tracker holds no state of the requests, their IDs, seqs and meta live in
+// their contexts: once negotiated, its methods are safe for concurrent use by
+// all the requests on the connection, pooled connections included, the
+// tracker goes along with its connection. Negotiation itself must not run
+// concurrently with the requests.
 type SimpleTracker struct {
-	mu       *sync.RWMutex
-	upgraded bool
-	name     string
+	mu                 *sync.RWMutex
+	upgraded           bool
+	closed             bool
+	poisoned           bool
+	everUpgraded       bool
+	createdAt          time.Time
+	handshakeRTT       time.Duration
+	lastReply          *tracking.UpgradeReply
+	negotiatedOn       thrift.TTransport
+	negotiatedIDFormat IDFormat
+	negotiatedCodec    MetaCodec
+	maxConcurrent      int
+	maxMetaEntries     int
+	peerAppID          string
+	name               string
+
+	idFormat                     IDFormat
+	localMaxConcurrent           int
+	localMaxMetaEntries          int
+	metaCodec                    MetaCodec
+	metaCodecs                   []MetaCodec
+	onHandshakeSize              func(argsSize, replySize int)
+	watchdogThreshold            time.Duration
+	onNegotiationStuck           func(elapsed time.Duration)
+	metaTransform                MetaTransform
+	canonicalKey                 func(key string) string
+	handshakeDedup               *HandshakeDedup
+	failures                     *FailureChannel
+	appIDTransform               func(appID string) string
+	strictHeader                 bool
+	onewayHandshake              bool
+	admission                    AdmissionController
+	requestIDGenerator           func(ctx context.Context) string
+	onMetaDropped                func(reason string, keys []string)
+	clock                        Clock
+	sampler                      Sampler
+	metrics                      Metrics
+	metaLimits                   *metaLimits
+	negotiationFallback          bool
+	reservedMetaTransformAllowed bool
+	failOpen                     bool
+	onFailOpen                   func(err error)
+	propagators                  []Propagator
+	beforeWriteRequestHeader     func(ctx context.Context, header *tracking.RequestHeader) error
+	onClose                      func(summary ConnectionSummary)
+	propagateDeadline            bool
+	breaker                      *HandshakeBreaker
+	upgradeArgsBuilder           func(args *tracking.UpgradeArgs_)
+	upgradeArgsReader            func(args *tracking.UpgradeArgs_)
 }
 
-func NewSimpleTrackerFactory(name string) func() Tracker {
+func NewSimpleTrackerFactory(name string, opts ...Option) func() Tracker {
 	return func() Tracker {
-		return NewSimpleTracker(name)
+		return NewSimpleTracker(name, opts...)
 	}
 }
 
-func NewSimpleTracker(name string) Tracker {
-	return &SimpleTracker{
+func NewSimpleTracker(name string, opts ...Option) Tracker {
+	t := &SimpleTracker{
 		mu:       &sync.RWMutex{},
 		upgraded: false,
 		name:     name,
 	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.createdAt = t.now()
+	atomic.AddInt64(&liveTrackers, 1)
+	return t
 }
 
 func (t *SimpleTracker) Negotiation(curSeqID int32, iprot, oprot thrift.TProtocol) error {
-	// send
-	if err := oprot.WriteMessageBegin(TrackingAPIName, thrift.CALL, curSeqID); err != nil {
-		return err
+	err := t.negotiation(curSeqID, iprot, oprot, nil, nil)
+	if err != nil && t.failures != nil {
+		t.failures.publish(err)
 	}
-	args := tracking.NewUpgradeArgs_()
-	args.AppID = t.name
-	if err := args.Write(oprot); err != nil {
+	return err
+}
+
+// Negotiated tells whether the client side of a handshake has completed on
+// the connection, upgraded or not, and the connection is still open. The
+// handshake is not run again then: Negotiation and NegotiationContext return
+// right away, unless they are given a transport other than the one negotiated
+// on, the handshake is run again from scratch. NegotiateEcho always runs it.
+func (t *SimpleTracker) Negotiated() bool {
+	_, ok := t.negotiatedTransport()
+	return ok
+}
+
+func (t *SimpleTracker) negotiatedTransport() (thrift.TTransport, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.negotiatedOn, t.negotiatedOn != nil && t.negotiatedOn.IsOpen()
+}
+
+// Reset forgets the handshake, for a tracker reused after its transport got
+// reopened, the next Negotiation runs the handshake again. It clears Poisoned
+// too.
+func (t *SimpleTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.upgraded && !t.closed {
+		atomic.AddInt64(&upgradedTrackers, -1)
+	}
+	t.upgraded = false
+	t.negotiatedOn = nil
+	t.handshakeRTT = 0
+	t.lastReply = nil
+	t.negotiatedIDFormat = 0
+	t.maxConcurrent = 0
+	t.maxMetaEntries = 0
+	t.negotiatedCodec = nil
+	t.peerAppID = ""
+	t.poisoned = false
+}
+
+func (t *SimpleTracker) setNegotiated(trans thrift.TTransport) {
+	t.mu.Lock()
+	t.negotiatedOn = trans
+	t.mu.Unlock()
+}
+
+// NegotiateEcho is Negotiation asking the server to send token back in its
+// reply, an active health check of the serialization both ways before any
+// real call. It fails without upgrading if the token does not come back as
+// is, servers predating the echo included.
+func (t *SimpleTracker) NegotiateEcho(curSeqID int32, iprot, oprot thrift.TProtocol, token string) error {
+	err := t.negotiation(curSeqID, iprot, oprot, nil, &token)
+	if err != nil && t.failures != nil {
+		t.failures.publish(err)
+	}
+	return err
+}
+
+// negotiation runs the handshake, giving up before any step once aborted,
+// if not nil, returns true. The server must send echo back if not nil.
+func (t *SimpleTracker) negotiation(curSeqID int32, iprot, oprot thrift.TProtocol, aborted func() bool, echo *string) (err error) {
+	if t.onNegotiationStuck != nil {
+		start := time.Now()
+		watchdog := time.AfterFunc(t.watchdogThreshold, func() {
+			t.onNegotiationStuck(time.Since(start))
+		})
+		defer watchdog.Stop()
+	}
+	if trans, ok := t.negotiatedTransport(); ok {
+		if trans != oprot.Transport() {
+			t.Reset() // a new connection
+		} else if echo == nil { // the echo checks the connection again
+			return nil
+		}
+	}
+	if t.breaker != nil && echo == nil {
+		key := t.breaker.key(oprot.Transport())
+		if !t.breaker.allow(key, t.now) {
+			statBreakerSkips.Add(1)
+			t.setNegotiated(oprot.Transport())
+			return nil
+		}
+		defer func() {
+			e, ok := err.(*NegotiationError)
+			t.breaker.done(key, t.RequestHeaderSupported(),
+				ok && e.Reason == ReasonAborted || aborted != nil && aborted(), t.now)
+		}()
+	}
+	defer func() { t.recordHandshake(err) }()
+	if t.onewayHandshake {
+		return t.onewayNegotiation(curSeqID, oprot, echo)
+	}
+
+	var (
+		argsProt, replyProt *countingProtocol
+		reply               *tracking.UpgradeReply
+		sentAt, repliedAt   time.Time
+	)
+	fsm := NewNegotiationFSM(curSeqID)
+	action, err := fsm.Step(NegotiationEvent{Kind: EventStart})
+	for err == nil && action != ActionNone && action != ActionUpgrade {
+		if aborted != nil && aborted() {
+			return errNegotiationAborted()
+		}
+		var ev NegotiationEvent
+		switch action {
+		case ActionWriteArgs: // send
+			ev.Kind = EventArgsWritten
+			argsProt = newCountingProtocol(oprot)
+			if ev.Err = t.writeUpgradeArgs(curSeqID, oprot, argsProt, echo); ev.Err == nil {
+				sentAt = t.now()
+				ev.Err = oprot.Flush()
+			}
+		case ActionReadMessageBegin: // recv
+			ev.Kind = EventMessageBegin
+			ev.Method, ev.TypeID, ev.SeqID, ev.Err = iprot.ReadMessageBegin()
+		case ActionReadException:
+			ev.Kind = EventException
+			ev.Exception, ev.Err = thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION,
+				"Unknown Exception").Read(iprot)
+			if ev.Err == nil {
+				ev.Err = iprot.ReadMessageEnd()
+			}
+		case ActionReadReply:
+			ev.Kind = EventReply
+			reply = tracking.NewUpgradeReply()
+			replyProt = newCountingProtocol(iprot)
+			if ev.Err = reply.Read(replyProt); ev.Err == nil {
+				ev.Err = iprot.ReadMessageEnd()
+				repliedAt = t.now()
+			}
+		}
+		action, err = fsm.Step(ev)
+	}
+	if err != nil {
+		return t.fallback(oprot.Transport(), asHandshakeRejected(err))
+	}
+	if action != ActionUpgrade {
+		t.setNegotiated(oprot.Transport())
+		return nil
+	}
+	if echo != nil && (!reply.IsSetEcho() || reply.GetEcho() != *echo) {
+		return &NegotiationError{Reason: ReasonEchoMismatch,
+			Err: fmt.Errorf("tracker negotiation failed: echo mismatch, sent %q, got %q", *echo, reply.GetEcho())}
+	}
+	if err := t.checkReply(reply); err != nil {
 		return err
 	}
-	if err := oprot.WriteMessageEnd(); err != nil {
+	t.setMaxMetaEntries(minLimit(t.localMaxMetaEntries, int(reply.GetMaxMetaEntries())))
+	t.upgradeProtocol(agreeIDFormat(t.idFormat, reply.IsSetIDFormat(), reply.GetIDFormat()),
+		minLimit(t.localMaxConcurrent, int(reply.GetMaxConcurrent())),
+		lookupMetaCodec(t.metaCodecs, reply.GetMetaCodec()))
+	t.mu.Lock()
+	t.handshakeRTT = repliedAt.Sub(sentAt)
+	t.lastReply = reply
+	t.negotiatedOn = oprot.Transport()
+	t.mu.Unlock()
+	if t.onHandshakeSize != nil {
+		t.onHandshakeSize(argsProt.Size(), replyProt.Size())
+	}
+	return nil
+}
+
+// onewayNegotiation sends the upgrade call as ONEWAY and upgrades right away
+// with the local settings, see WithOnewayHandshake.
+func (t *SimpleTracker) onewayNegotiation(curSeqID int32, oprot thrift.TProtocol, echo *string) error {
+	if echo != nil {
+		return errors.New("tracker negotiation failed: no echo without a reply, the handshake is ONEWAY")
+	}
+	argsProt := newCountingProtocol(oprot)
+	if err := t.writeUpgradeArgs(curSeqID, oprot, argsProt, nil); err != nil {
 		return err
 	}
 	if err := oprot.Flush(); err != nil {
 		return err
 	}
+	t.setMaxMetaEntries(t.localMaxMetaEntries)
+	t.upgradeProtocol(t.idFormat, t.localMaxConcurrent, nil) // no reply to pick a codec
+	t.setNegotiated(oprot.Transport())
+	if t.onHandshakeSize != nil {
+		t.onHandshakeSize(argsProt.Size(), 0)
+	}
+	return nil
+}
 
-	// recv
-	method, mTypeID, seqID, err := iprot.ReadMessageBegin()
-	if err != nil {
+// writeUpgradeArgs writes the upgrade call, the caller flushes it.
+func (t *SimpleTracker) writeUpgradeArgs(curSeqID int32, oprot, argsProt thrift.TProtocol, echo *string) error {
+	typeID := thrift.CALL
+	if t.onewayHandshake {
+		typeID = thrift.ONEWAY
+	}
+	if err := oprot.WriteMessageBegin(TrackingAPIName, typeID, curSeqID); err != nil {
 		return err
 	}
-	if method != TrackingAPIName {
-		return thrift.NewTApplicationException(thrift.WRONG_METHOD_NAME,
-			"tracker negotiation failed: wrong method name")
+	args := tracking.NewUpgradeArgs_()
+	args.AppID = t.name
+	if t.appIDTransform != nil {
+		args.AppID = t.appIDTransform(args.AppID)
+	}
+	args.IDFormat = thrift.Int32Ptr(int32(t.idFormat))
+	args.MaxConcurrent = thrift.Int32Ptr(int32(t.localMaxConcurrent))
+	if t.localMaxMetaEntries > 0 {
+		args.MaxMetaEntries = thrift.Int32Ptr(int32(t.localMaxMetaEntries))
 	}
-	if curSeqID != seqID {
-		return thrift.NewTApplicationException(thrift.BAD_SEQUENCE_ID,
-			"tracker negotiation failed: out of sequence response")
+	args.Echo = echo
+	args.Magic = thrift.Int32Ptr(TrackingMagic)
+	args.MetaCodecs = metaCodecNames(t.metaCodecs)
+	if t.upgradeArgsBuilder != nil {
+		t.upgradeArgsBuilder(args)
 	}
-	if mTypeID == thrift.EXCEPTION {
-		err0 := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION,
-			"Unknown Exception")
-		var err1 thrift.TApplicationException
-		if err1, err = err0.Read(iprot); err != nil {
-			return err
-		}
-		if err = iprot.ReadMessageEnd(); err != nil {
-			return err
-		}
-		if err1.TypeId() == thrift.UNKNOWN_METHOD { // server does not support tracker, ignore
-			return nil
+	if err := args.Write(argsProt); err != nil {
+		return err
+	}
+	return oprot.WriteMessageEnd()
+}
+
+// NegotiationContext is Negotiation that gives up once ctx is done or the
+// channel under CtxKeyAbortNegotiation is closed. The abort is checked before
+// every step of the handshake, the pending I/O is also interrupted through a
+// deadline if the transports are TSocket or have a SetDeadline method. Other
+// transports, the buffered ones hiding the socket for example, let the I/O
+// run until it completes or fails.
+//
+// The transports are closed on abort, once no I/O is pending anymore, the
+// connection can not be used afterwards.
+func (t *SimpleTracker) NegotiationContext(ctx context.Context, curSeqID int32, iprot, oprot thrift.TProtocol) error {
+	var abort <-chan struct{}
+	switch ch := ctx.Value(CtxKeyAbortNegotiation).(type) {
+	case chan struct{}:
+		abort = ch
+	case <-chan struct{}:
+		abort = ch
+	}
+	if abort == nil && ctx.Done() == nil {
+		return t.Negotiation(curSeqID, iprot, oprot)
+	}
+	aborted := func() bool {
+		select {
+		case <-abort:
+			return true
+		case <-ctx.Done():
+			return true
+		default:
+			return false
 		}
-		return err1
 	}
-	if mTypeID != thrift.REPLY {
-		return thrift.NewTApplicationException(thrift.INVALID_MESSAGE_TYPE_EXCEPTION,
-			"tracker negotiation failed: invalid message type")
+	if aborted() {
+		return errNegotiationAborted()
 	}
-	reply := tracking.NewUpgradeReply()
-	if err := reply.Read(iprot); err != nil {
-		return err
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	if deadliners := transportDeadliners(iprot, oprot); len(deadliners) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-abort:
+			case <-ctx.Done():
+			case <-stop:
+				return
+			}
+			for _, d := range deadliners {
+				d.SetDeadline(time.Now())
+			}
+		}()
 	}
-	if err := iprot.ReadMessageEnd(); err != nil {
-		return err
+	err := t.negotiation(curSeqID, iprot, oprot, aborted, nil)
+	close(stop)
+	wg.Wait()
+
+	if aborted() {
+		iprot.Transport().Close()
+		oprot.Transport().Close()
+		err = errNegotiationAborted()
 	}
-	t.upgradeProtocol()
-	return nil
+	if err != nil && t.failures != nil {
+		t.failures.publish(err)
+	}
+	return err
+}
+
+func errNegotiationAborted() error {
+	return &NegotiationError{Reason: ReasonAborted, Err: thrift.NewTApplicationException(thrift.INTERNAL_ERROR,
+		"tracker negotiation aborted")}
+}
+
+// deadliner is what can interrupt the pending I/O of a transport, it must be
+// safe to use concurrently with the I/O, like net.Conn is.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+func transportDeadliners(prots ...thrift.TProtocol) []deadliner {
+	var deadliners []deadliner
+	for _, prot := range prots {
+		switch trans := prot.Transport().(type) {
+		case deadliner:
+			deadliners = append(deadliners, trans)
+		case interface{ Conn() net.Conn }: // TSocket
+			if conn := trans.Conn(); conn != nil {
+				deadliners = append(deadliners, conn)
+			}
+		}
+	}
+	return deadliners
 }
 
+// TryUpgrade answers the handshake of a client. A handshake always starts
+// from scratch: what an earlier one negotiated on the tracker is reset first,
+// see Reset, so a client reconnecting through a proxy which keeps the server
+// side of the connection gets what it asks for this time. A handshake which
+// fails or does not upgrade leaves the tracker not upgraded, as the client
+// is, rather than with the settings of the one before.
 func (t *SimpleTracker) TryUpgrade(seqID int32, iprot, oprot thrift.TProtocol) (bool, thrift.TException) {
+	t.Reset()
+	ok, err := t.tryUpgrade(seqID, iprot, oprot)
+	if err != nil && t.failures != nil {
+		t.failures.publish(err)
+	}
+	return ok, err
+}
+
+func (t *SimpleTracker) tryUpgrade(seqID int32, iprot, oprot thrift.TProtocol) (_ bool, err thrift.TException) {
+	defer func() { t.recordHandshake(err) }()
 	args := tracking.NewUpgradeArgs_()
 	if err := args.Read(iprot); err != nil {
 		iprot.ReadMessageEnd()
-		x := thrift.NewTApplicationException(thrift.PROTOCOL_ERROR, err.Error())
-		x.Write(oprot)
-		oprot.WriteMessageEnd()
-		oprot.Flush()
+		if !t.onewayHandshake {
+			x := thrift.NewTApplicationException(thrift.PROTOCOL_ERROR, err.Error())
+			x.Write(oprot)
+			oprot.WriteMessageEnd()
+			oprot.Flush()
+		}
 		return false, err
 	}
 	iprot.ReadMessageEnd()
+	if err := checkMagic(args); err != nil {
+		if !t.onewayHandshake {
+			writeUpgradeException(seqID, oprot, thrift.NewTApplicationException(thrift.PROTOCOL_ERROR, err.Error()))
+		}
+		return false, err
+	}
+	t.mu.Lock()
+	t.peerAppID = args.GetAppID()
+	t.mu.Unlock()
+	if t.upgradeArgsReader != nil {
+		t.upgradeArgsReader(args)
+	}
+	if t.admission != nil && !t.onewayHandshake {
+		if admitted, backoff := t.admission.Admit(args.GetAppID()); !admitted {
+			return t.rejectUpgrade(seqID, oprot, backoff)
+		}
+	}
 
-	result := tracking.NewUpgradeReply()
+	var result *tracking.UpgradeReply
+	if t.handshakeDedup != nil {
+		result = t.handshakeDedup.do(args, func() *tracking.UpgradeReply { return t.upgradeReply(args) })
+	} else {
+		result = t.upgradeReply(args)
+	}
+	if t.onewayHandshake { // the client reads no reply
+		t.setMaxMetaEntries(int(result.GetMaxMetaEntries()))
+		t.upgradeProtocol(IDFormat(result.GetIDFormat()), int(result.GetMaxConcurrent()), t.replyMetaCodec(result))
+		return true, nil
+	}
 	if err := oprot.WriteMessageBegin(TrackingAPIName, thrift.REPLY, seqID); err != nil {
 		return false, err
 	}
@@ -144,14 +531,58 @@ func (t *SimpleTracker) TryUpgrade(seqID int32, iprot, oprot thrift.TProtocol) (
 	if err := oprot.Flush(); err != nil {
 		return false, err
 	}
-	t.upgradeProtocol()
+	t.setMaxMetaEntries(int(result.GetMaxMetaEntries()))
+	t.upgradeProtocol(IDFormat(result.GetIDFormat()), int(result.GetMaxConcurrent()), t.replyMetaCodec(result))
 	return true, nil
 }
 
-func (t *SimpleTracker) upgradeProtocol() {
+// rejectUpgrade tells the client to try again after backoff, the connection
+// goes on without tracking.
+func (t *SimpleTracker) rejectUpgrade(seqID int32, oprot thrift.TProtocol, backoff time.Duration) (bool, thrift.TException) {
+	if err := writeUpgradeException(seqID, oprot, newHandshakeRejectedException(backoff)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// upgradeReply decides on the handshake requested by args.
+func (t *SimpleTracker) upgradeReply(args *tracking.UpgradeArgs_) *tracking.UpgradeReply {
+	reply := tracking.NewUpgradeReply()
+	reply.IDFormat = thrift.Int32Ptr(int32(agreeIDFormat(t.idFormat, args.IsSetIDFormat(), args.GetIDFormat())))
+	reply.MaxConcurrent = thrift.Int32Ptr(int32(minLimit(t.localMaxConcurrent, int(args.GetMaxConcurrent()))))
+	if n := minLimit(t.localMaxMetaEntries, int(args.GetMaxMetaEntries())); n > 0 {
+		reply.MaxMetaEntries = thrift.Int32Ptr(int32(n))
+	}
+	reply.Echo = args.Echo
+	if args.IsSetMetaCodecs() {
+		reply.MetaCodec = thrift.StringPtr(pickMetaCodec(t.metaCodecs, args.GetMetaCodecs()).Name())
+	}
+	return reply
+}
+
+func (t *SimpleTracker) upgradeProtocol(idFormat IDFormat, maxConcurrent int, codec MetaCodec) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
+	if !t.upgraded && !t.closed {
+		atomic.AddInt64(&upgradedTrackers, 1)
+	}
 	t.upgraded = true
+	t.everUpgraded = true
+	t.negotiatedIDFormat = idFormat
+	t.maxConcurrent = maxConcurrent
+	t.negotiatedCodec = codec
+	t.mu.Unlock()
+}
+
+// minLimit takes the smaller limit of both sides, 0 (or less) stands for
+// unlimited, so does an unset field from an older peer.
+func minLimit(local, peer int) int {
+	if peer <= 0 {
+		return local
+	}
+	if local == 0 || peer < local {
+		return peer
+	}
+	return local
 }
 
 func (t *SimpleTracker) RequestHeaderSupported() bool {
@@ -160,50 +591,314 @@ func (t *SimpleTracker) RequestHeaderSupported() bool {
 	return t.upgraded
 }
 
-func (t *SimpleTracker) RequestSeqIDFromCtx(ctx context.Context) (string, string) {
-	var reqID, seqID string
+// PeerAppID returns the AppID the client reported during the handshake, it is
+// only known on the server side.
+func (t *SimpleTracker) PeerAppID() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.peerAppID
+}
 
-	if v, ok := ctx.Value(CtxKeyRequestID).(string); ok {
-		reqID = v
-	} else {
-		reqID = uuid.New().String()
+// LastUpgradeReply returns a copy of the reply of the server to the last
+// handshake which upgraded the connection, for the fields without a getter of
+// their own. It is nil until then, on the server side, with
+// WithOnewayHandshake, and once Reset.
+func (t *SimpleTracker) LastUpgradeReply() *tracking.UpgradeReply {
+	t.mu.RLock()
+	reply := t.lastReply
+	t.mu.RUnlock()
+	if reply == nil {
+		return nil
+	}
+	// A round trip copies the fields added to the struct later on as well.
+	buf := thrift.NewTMemoryBuffer()
+	prot := thrift.NewTBinaryProtocolTransport(buf)
+	cp := tracking.NewUpgradeReply()
+	if err := reply.Write(prot); err != nil {
+		return nil
+	}
+	if err := cp.Read(prot); err != nil {
+		return nil
 	}
+	return cp
+}
 
-	if v, ok := ctx.Value(CtxKeySequenceID).(string); ok {
-		seqID = v
-	} else {
-		seqID = "1"
+// IDFormat returns the request ID format agreed during the handshake.
+func (t *SimpleTracker) IDFormat() IDFormat {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.negotiatedIDFormat
+}
+
+// MaxConcurrentStreams returns the number of concurrent in-flight requests
+// agreed during the handshake, 0 means unlimited.
+func (t *SimpleTracker) MaxConcurrentStreams() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.maxConcurrent
+}
+
+// MaxMetaEntries returns the number of meta entries per request header
+// agreed during the handshake, see WithMaxMetaEntries, 0 means unlimited.
+func (t *SimpleTracker) MaxMetaEntries() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.maxMetaEntries
+}
+
+// setMaxMetaEntries is called right before upgradeProtocol, for the limit to
+// hold from the first request header.
+func (t *SimpleTracker) setMaxMetaEntries(n int) {
+	t.mu.Lock()
+	t.maxMetaEntries = n
+	t.mu.Unlock()
+}
+
+// WithRequestID returns a context carrying id as the request ID, for the
+// calls made with it and the middleware reading it. It wins over the ID
+// generator and format of the tracker, the servers propagate it unchanged:
+// set it to assert on the correlation of the logs in tests. An empty id
+// stands for none, a new one is generated.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, CtxKeyRequestID, id)
+}
+
+// EnsureRequestID returns ctx with a request ID, a new one if ctx has none,
+// and the ID. The edge should call it once per request: RequestSeqIDFromCtx
+// generates a new request ID every time it is called on a context with none,
+// so the calls of the same request would not share it. The context returned
+// also numbers the calls made with it, like the context of an incoming
+// request does.
+func (t *SimpleTracker) EnsureRequestID(ctx context.Context) (context.Context, string) {
+	if _, ok := ctx.Value(ctxKeySeqCounter).(*seqCounter); !ok {
+		ctx = context.WithValue(ctx, ctxKeySeqCounter, new(seqCounter))
+	}
+	if id, ok := ctx.Value(CtxKeyRequestID).(string); ok {
+		return ctx, id
 	}
+	id := t.requestID(ctx)
+	return WithRequestID(ctx, id), id
+}
 
-	return reqID, fmt.Sprintf("%v.1", seqID)
+// RequestSeqIDFromCtx returns the request ID and the seq of a call made with
+// ctx. The seq is the one of the current request, "1" at the edge, extended
+// with the number of the call: every call gets the next one, "1.1", "1.2"
+// and so on, when ctx comes from TryReadRequestHeader or EnsureRequestID,
+// the calls made with other contexts are all numbered 1.
+func (t *SimpleTracker) RequestSeqIDFromCtx(ctx context.Context) (string, string) {
+	return t.requestID(ctx), nextSeq(ctx)
 }
 
+func (t *SimpleTracker) requestID(ctx context.Context) string {
+	if v, _ := ctx.Value(CtxKeyRequestID).(string); v != "" {
+		return v
+	}
+	if t.requestIDGenerator != nil && ctx.Err() == nil {
+		return t.requestIDGenerator(ctx)
+	}
+	// The generator may be slow, not worth it once ctx is done.
+	return t.IDFormat().newRequestID(t.name, t.now())
+}
+
+const ctxKeySeqCounter ctxKey = "__thrift_tracking_seq_counter"
+
+// seqCounter numbers the calls made for a request, safe for concurrent use.
+type seqCounter struct {
+	n uint32
+}
+
+func nextSeq(ctx context.Context) string {
+	return SeqFromContext(ctx).child().String()
+}
+
+// ctxKeySeqObserver holds a func(seq string) called with the seq of the
+// request header written, for RequestSeqIDFromCtx not to number another call.
+const ctxKeySeqObserver ctxKey = "__thrift_tracking_seq_observer"
+
 func (t *SimpleTracker) TryReadRequestHeader(iprot thrift.TProtocol) (context.Context, error) {
 	if !t.RequestHeaderSupported() {
 		return context.TODO(), nil
 	}
+	return t.TryReadRequestHeaderContext(context.Background(), iprot)
+}
+
+// TryReadRequestHeaderContext is TryReadRequestHeader deriving the context
+// returned from ctx, which may already carry tracking values from another
+// channel, set by an HTTP middleware for example. The request header wins:
+//
+//   - the request ID and seq of ctx are kept only if the header has none;
+//   - the meta of ctx is merged under the meta of the header, but for the
+//     reserved keys, which the tracker sets from their own context values;
+//   - the reserved values of ctx are kept only if the header has none, but
+//     the hop count which is always counted from the header.
+//
+// ctx is returned as is if the connection has not been upgraded.
+func (t *SimpleTracker) TryReadRequestHeaderContext(ctx context.Context, iprot thrift.TProtocol) (context.Context, error) {
+	if !t.RequestHeaderSupported() {
+		return ctx, nil
+	}
+	if t.Poisoned() {
+		return ctx, ErrConnectionPoisoned
+	}
 	header := tracking.NewRequestHeader()
-	if err := header.Read(iprot); err != nil {
-		return context.TODO(), err
+	cprot := newCountingProtocol(iprot)
+	err := t.readRequestHeader(cprot, header)
+	n := cprot.Size()
+	statHeaderBytesRead.Add(int64(n))
+	if err != nil {
+		if err = t.poison(err, n); t.failOpen && !t.Poisoned() {
+			t.failedOpen(err)
+			return ctx, nil
+		}
+		return ctx, err
+	}
+	if isUntrackedHeader(header) { // filtered out by the client
+		return ctx, nil
 	}
-	ctx := context.Background()
-	ctx = context.WithValue(ctx, CtxKeyRequestID, header.GetRequestID())
-	ctx = context.WithValue(ctx, CtxKeySequenceID, header.GetSeq())
-	ctx = context.WithValue(ctx, CtxKeyRequestMeta, header.GetMeta())
-	return ctx, nil
+	if id := header.GetRequestID(); id != "" {
+		ctx = context.WithValue(ctx, CtxKeyRequestID, id)
+	}
+	if seq := header.GetSeq(); seq != "" {
+		ctx = context.WithValue(ctx, CtxKeySequenceID, seq)
+	}
+	ctx = context.WithValue(ctx, ctxKeySeqCounter, new(seqCounter))
+	ctx = context.WithValue(ctx, ctxKeyRequestHeader, header)
+	if appID := t.PeerAppID(); appID != "" {
+		ctx = context.WithValue(ctx, ctxKeyPeerAppID, appID)
+	}
+	base := ctx // the ID and the seq, all that is left once failed open
+	drops := t.metaDrops()
+	meta, err := t.decodeMeta(header, drops)
+	if err != nil {
+		return t.failOpenRead(base, err)
+	}
+	meta = t.canonicalizeMeta(meta, drops)
+	if n := t.MaxMetaEntries(); n > 0 { // a client unaware of the limit wrote past it
+		(&metaLimits{maxKeys: n}).enforce(ctx, meta, drops)
+	}
+	if local, ok := ctx.Value(CtxKeyRequestMeta).(map[string]string); ok && len(local) > 0 {
+		merged := t.canonicalizeMeta(local, drops)
+		for k := range merged {
+			if isReservedMetaKey(k) { // set through their own accessors
+				delete(merged, k)
+			}
+		}
+		for k, v := range meta {
+			merged[k] = v
+		}
+		meta = merged
+	}
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, meta)
+	ctx = context.WithValue(ctx, ctxKeyInheritedMeta, meta)
+	if t.metaTransform != nil {
+		ctx = context.WithValue(ctx, ctxKeyMetaTransform, &metaTransformer{
+			fn:              t.metaTransform,
+			reservedAllowed: t.reservedMetaTransformAllowed,
+		})
+	}
+	ctx, err = extractReservedMeta(ctx, meta, drops)
+	t.reportMetaDrops(drops)
+	if err != nil {
+		return t.failOpenRead(base, err)
+	}
+	if t.propagateDeadline {
+		ctx = withDeadlineBudget(ctx)
+	}
+	return t.extractPropagators(ctx, meta), nil
+}
+
+func (t *SimpleTracker) readRequestHeader(iprot thrift.TProtocol, header *tracking.RequestHeader) error {
+	return readRequestHeader(iprot, header, t.strictHeader)
 }
 
 func (t *SimpleTracker) TryWriteRequestHeader(ctx context.Context, oprot thrift.TProtocol) error {
 	if !t.RequestHeaderSupported() {
 		return nil
 	}
-	header := tracking.NewRequestHeader()
-	if meta, ok := ctx.Value(CtxKeyRequestMeta).(map[string]string); ok {
-		header.Meta = make(map[string]string, len(meta))
-		for k, v := range meta {
-			header.Meta[k] = v
-		}
+	var header *tracking.RequestHeader
+	if t.beforeWriteRequestHeader == nil {
+		p := getRequestHeader()
+		defer putRequestHeader(p)
+		header = &p.header
+	} else { // fn may well keep the header
+		header = tracking.NewRequestHeader()
+		header.SchemaVer = thrift.Int32Ptr(HeaderSchemaVersion)
 	}
 	header.RequestID, header.Seq = t.RequestSeqIDFromCtx(ctx)
-	return header.Write(oprot)
+	if observe, ok := ctx.Value(ctxKeySeqObserver).(func(seq string)); ok {
+		observe(header.Seq)
+	}
+	sampled, decided := t.sampled(ctx, header.RequestID)
+	if !sampled {
+		if header.Meta == nil {
+			header.Meta = make(map[string]string, 1)
+		}
+		header.Meta[MetaKeySampled] = sampledMeta(false)
+	} else if err := t.writeRequestMeta(ctx, header, decided); err != nil {
+		if err = t.failOpenWrite(header, err); err != nil {
+			return err
+		}
+	}
+	if t.beforeWriteRequestHeader != nil {
+		if err := t.beforeWriteRequestHeader(ctx, header); err != nil {
+			return err
+		}
+	}
+	if err := t.encodeMeta(header); err != nil {
+		if err = t.failOpenWrite(header, err); err != nil {
+			return err
+		}
+	}
+	return t.writeRequestHeaderContext(ctx, header, oprot)
+}
+
+// writeRequestMeta sets the meta of header to write, from ctx. The empty map
+// header may come with is used as is for a ctx without meta.
+func (t *SimpleTracker) writeRequestMeta(ctx context.Context, header *tracking.RequestHeader, decided bool) error {
+	meta := mergeMeta(ctx)
+	drops := t.metaDrops()
+	if meta := t.canonicalizeMeta(meta, drops); meta != nil {
+		header.Meta = meta
+	} else if header.Meta == nil {
+		header.Meta = make(map[string]string)
+	}
+	if _, ok := EntryTimestampFromContext(ctx); !ok { // stamped at the edge
+		ctx = WithEntryTimestamp(ctx, t.now())
+	}
+	ctx = context.WithValue(ctx, ctxKeyLocalAppID, t.name)
+	if t.propagateDeadline {
+		ctx = context.WithValue(ctx, ctxKeyPropagateDeadline, true)
+	}
+	if err := injectReservedMeta(ctx, header.Meta); err != nil {
+		return err
+	}
+	t.injectPropagators(ctx, header.Meta)
+	if m, ok := ctx.Value(ctxKeyMetaTransform).(*metaTransformer); ok {
+		header.Meta = t.canonicalizeMeta(m.apply(header.Meta, drops), drops)
+	}
+	if limits := t.writeMetaLimits(); limits != nil {
+		if err := limits.enforce(ctx, header.Meta, drops); err != nil {
+			return err
+		}
+	}
+	t.reportMetaDrops(drops)
+	if decided {
+		if header.Meta == nil { // dropped by the transform
+			header.Meta = make(map[string]string)
+		}
+		header.Meta[MetaKeySampled] = sampledMeta(true)
+	}
+	return nil
+}
+
+func (t *SimpleTracker) writeRequestHeader(header *tracking.RequestHeader, oprot thrift.TProtocol) error {
+	cprot := getCountingProtocol(oprot)
+	err := header.Write(cprot)
+	n := cprot.Size()
+	putCountingProtocol(cprot)
+	statHeaderBytesWritten.Add(int64(n))
+	if t.metrics != nil && err == nil {
+		t.metrics.ObserveHeaderBytes(n)
+	}
+	return err
 }
@@ -0,0 +1,747 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// newProtocolPair returns the protocols of both ends of an in-memory
+// connection, each end reads and writes with the same protocol.
+func newProtocolPair(t *testing.T) (client, server thrift.TProtocol) {
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	return thrift.NewTBinaryProtocolTransport(thrift.NewTSocketFromConnTimeout(c, 0)),
+		thrift.NewTBinaryProtocolTransport(thrift.NewTSocketFromConnTimeout(s, 0))
+}
+
+// serveUpgrade handles the upgrade call read from prot as the generated
+// processor does.
+func serveUpgrade(server Tracker, prot thrift.TProtocol) error {
+	name, _, seqID, err := prot.ReadMessageBegin()
+	if err != nil {
+		return err
+	}
+	if name != TrackingAPIName {
+		return thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, name)
+	}
+	_, err = server.TryUpgrade(seqID, prot, prot)
+	return err
+}
+
+// handshake negotiates between client and server over a new connection.
+func handshake(t *testing.T, client, server Tracker) (cprot, sprot thrift.TProtocol) {
+	t.Helper()
+	cprot, sprot = newProtocolPair(t)
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+	if err := client.Negotiation(1, cprot, cprot); err != nil {
+		t.Fatalf("client negotiation failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server upgrade failed: %v", err)
+	}
+	return cprot, sprot
+}
+
+// passRequestHeader writes a request header with client under ctx and reads
+// it back with server, both must have been upgraded.
+func passRequestHeader(t *testing.T, ctx context.Context, client, server Tracker) context.Context {
+	t.Helper()
+	prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+		t.Fatalf("write request header failed: %v", err)
+	}
+	sctx, err := server.TryReadRequestHeader(prot)
+	if err != nil {
+		t.Fatalf("read request header failed: %v", err)
+	}
+	return sctx
+}
+
+// upgradedPair returns a client and a server tracker that went through the
+// handshake.
+func upgradedPair(t *testing.T, clientOpts, serverOpts []Option) (client, server Tracker) {
+	t.Helper()
+	client = NewSimpleTracker("client", clientOpts...)
+	server = NewSimpleTracker("server", serverOpts...)
+	handshake(t, client, server)
+	return client, server
+}
+
+func serializedSize(t *testing.T, s thrift.TStruct) int {
+	t.Helper()
+	buf := thrift.NewTMemoryBuffer()
+	if err := s.Write(thrift.NewTBinaryProtocolTransport(buf)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Len()
+}
+
+func TestHandshake(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	if !client.RequestHeaderSupported() || !server.RequestHeaderSupported() {
+		t.Fatal("expect both sides to be upgraded")
+	}
+
+	ctx := context.WithValue(context.Background(), CtxKeyRequestID, "req")
+	ctx = context.WithValue(ctx, CtxKeyRequestMeta, map[string]string{"k": "v"})
+	sctx := passRequestHeader(t, ctx, client, server)
+	if id := sctx.Value(CtxKeyRequestID); id != "req" {
+		t.Fatalf("expect request ID %q, got %v", "req", id)
+	}
+	if seq := sctx.Value(CtxKeySequenceID); seq != "1.1" {
+		t.Fatalf("expect seq %q, got %v", "1.1", seq)
+	}
+	if meta, _ := sctx.Value(CtxKeyRequestMeta).(map[string]string); meta["k"] != "v" {
+		t.Fatalf("expect meta to be propagated, got %v", meta)
+	}
+}
+
+func TestHandshakeNotSupported(t *testing.T) {
+	cprot, sprot := newProtocolPair(t)
+	go func() { // a server without tracker
+		_, _, seqID, _ := sprot.ReadMessageBegin()
+		sprot.Skip(thrift.STRUCT)
+		sprot.ReadMessageEnd()
+		x := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "unknown method")
+		sprot.WriteMessageBegin(TrackingAPIName, thrift.EXCEPTION, seqID)
+		x.Write(sprot)
+		sprot.WriteMessageEnd()
+		sprot.Flush()
+	}()
+	client := NewSimpleTracker("client")
+	if err := client.Negotiation(1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	if client.RequestHeaderSupported() {
+		t.Fatal("expect the client not to be upgraded")
+	}
+}
+
+func TestHandshakeSizeObserver(t *testing.T) {
+	var argsSize, replySize int
+	client := NewSimpleTracker("client", WithHandshakeSizeObserver(func(a, r int) {
+		argsSize, replySize = a, r
+	}))
+	server := NewSimpleTracker("server")
+	handshake(t, client, server)
+
+	args := tracking.NewUpgradeArgs_()
+	args.AppID = "client"
+	args.IDFormat = thrift.Int32Ptr(int32(IDFormatOpaque))
+	args.MaxConcurrent = thrift.Int32Ptr(0)
+	args.Magic = thrift.Int32Ptr(TrackingMagic)
+	if want := serializedSize(t, args); argsSize != want {
+		t.Fatalf("expect args size %d, got %d", want, argsSize)
+	}
+	reply := tracking.NewUpgradeReply()
+	reply.IDFormat = thrift.Int32Ptr(int32(IDFormatOpaque))
+	reply.MaxConcurrent = thrift.Int32Ptr(0)
+	if want := serializedSize(t, reply); replySize != want {
+		t.Fatalf("expect reply size %d, got %d", want, replySize)
+	}
+}
+
+func TestSimpleTrackerFactoryOptions(t *testing.T) {
+	newTracker := NewSimpleTrackerFactory("client", WithIDFormat(IDFormatStructured))
+	if format := newTracker().(*SimpleTracker).idFormat; format != IDFormatStructured {
+		t.Fatalf("expect the options to be applied, got format %v", format)
+	}
+}
+
+var _ ContextHandShaker = (*SimpleTracker)(nil)
+
+func TestNegotiationContextAbort(t *testing.T) {
+	cprot, sprot := newProtocolPair(t)
+	go func() { // read the call and never reply, as a half-open connection would
+		sprot.ReadMessageBegin()
+		sprot.Skip(thrift.STRUCT)
+		sprot.ReadMessageEnd()
+	}()
+
+	abort := make(chan struct{})
+	ctx := context.WithValue(context.Background(), CtxKeyAbortNegotiation, abort)
+	time.AfterFunc(50*time.Millisecond, func() { close(abort) })
+
+	client := NewSimpleTracker("client").(*SimpleTracker)
+	start := time.Now()
+	if err := client.NegotiationContext(ctx, 1, cprot, cprot); err == nil {
+		t.Fatal("expect the negotiation to be aborted")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expect a prompt return, took %v", elapsed)
+	}
+	if client.RequestHeaderSupported() {
+		t.Fatal("expect the client not to be upgraded")
+	}
+}
+
+func TestNegotiationContextCancel(t *testing.T) {
+	cprot, _ := newProtocolPair(t) // nobody reads, the write blocks
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	client := NewSimpleTracker("client").(*SimpleTracker)
+	start := time.Now()
+	if err := client.NegotiationContext(ctx, 1, cprot, cprot); err == nil {
+		t.Fatal("expect the negotiation to be aborted")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expect a prompt return, took %v", elapsed)
+	}
+}
+
+func TestNegotiationContextAbortedBeforeStart(t *testing.T) {
+	cprot, _ := newProtocolPair(t) // any write would block forever
+
+	abort := make(chan struct{})
+	close(abort)
+	ctx := context.WithValue(context.Background(), CtxKeyAbortNegotiation, abort)
+	client := NewSimpleTracker("client").(*SimpleTracker)
+	if err := client.NegotiationContext(ctx, 1, cprot, cprot); err == nil {
+		t.Fatal("expect the negotiation to be aborted")
+	}
+}
+
+func TestNegotiationContextSucceeds(t *testing.T) {
+	cprot, sprot := newProtocolPair(t)
+	server := NewSimpleTracker("server")
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+
+	ctx := context.WithValue(context.Background(), CtxKeyAbortNegotiation, make(chan struct{}))
+	client := NewSimpleTracker("client").(*SimpleTracker)
+	if err := client.NegotiationContext(ctx, 1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !client.RequestHeaderSupported() {
+		t.Fatal("expect the client to be upgraded")
+	}
+}
+
+func TestNegotiateEcho(t *testing.T) {
+	client := NewSimpleTracker("client").(*SimpleTracker)
+	server := NewSimpleTracker("server")
+	cprot, sprot := newProtocolPair(t)
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+	if err := client.NegotiateEcho(1, cprot, cprot, "ping-42"); err != nil {
+		t.Fatalf("expect the echo to match, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !client.RequestHeaderSupported() {
+		t.Fatal("expect the client to be upgraded")
+	}
+}
+
+func TestNegotiateEchoMismatch(t *testing.T) {
+	for name, echo := range map[string]*string{
+		"garbled": thrift.StringPtr("pong-42"),
+		"missing": nil, // a server predating the echo
+	} {
+		client := NewSimpleTracker("client").(*SimpleTracker)
+		cprot, sprot := newProtocolPair(t)
+		go func(echo *string) { // a broken peer
+			_, _, seqID, _ := sprot.ReadMessageBegin()
+			tracking.NewUpgradeArgs_().Read(sprot)
+			sprot.ReadMessageEnd()
+			reply := tracking.NewUpgradeReply()
+			reply.Echo = echo
+			sprot.WriteMessageBegin(TrackingAPIName, thrift.REPLY, seqID)
+			reply.Write(sprot)
+			sprot.WriteMessageEnd()
+			sprot.Flush()
+		}(echo)
+		err := client.NegotiateEcho(1, cprot, cprot, "ping-42")
+		if err == nil || !strings.Contains(err.Error(), "echo mismatch") {
+			t.Fatalf("%s: expect an echo mismatch, got %v", name, err)
+		}
+		if client.RequestHeaderSupported() {
+			t.Fatalf("%s: expect the client not to be upgraded", name)
+		}
+	}
+}
+
+func TestOnewayHandshake(t *testing.T) {
+	var replySize = -1
+	client := NewSimpleTracker("client", WithOnewayHandshake(), WithIDFormat(IDFormatStructured),
+		WithMaxConcurrentStreams(8), WithHandshakeSizeObserver(func(_, size int) { replySize = size }))
+	server := NewSimpleTracker("server", WithOnewayHandshake(), WithIDFormat(IDFormatStructured),
+		WithMaxConcurrentStreams(8))
+
+	// Nothing to read on the client side, any read would fail.
+	iprot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+	buf := thrift.NewTMemoryBuffer()
+	oprot := thrift.NewTBinaryProtocolTransport(buf)
+	if err := client.Negotiation(1, iprot, oprot); err != nil {
+		t.Fatal(err)
+	}
+	if !client.RequestHeaderSupported() || replySize != 0 {
+		t.Fatalf("expect the client to upgrade without reading a reply, reply size %d", replySize)
+	}
+	c := client.(*SimpleTracker)
+	if c.IDFormat() != IDFormatStructured || c.MaxConcurrentStreams() != 8 {
+		t.Fatalf("expect the local settings to be assumed, got %v and %d", c.IDFormat(), c.MaxConcurrentStreams())
+	}
+
+	sprot := thrift.NewTBinaryProtocolTransport(buf)
+	name, typeID, seqID, err := sprot.ReadMessageBegin()
+	if err != nil || name != TrackingAPIName || typeID != thrift.ONEWAY {
+		t.Fatalf("expect a ONEWAY upgrade call, got %q %v %v", name, typeID, err)
+	}
+	out := thrift.NewTMemoryBuffer()
+	if ok, err := server.TryUpgrade(seqID, sprot, thrift.NewTBinaryProtocolTransport(out)); !ok || err != nil {
+		t.Fatalf("expect the server to upgrade, got %v %v", ok, err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expect no reply, got %d bytes", out.Len())
+	}
+	if s := server.(*SimpleTracker); s.IDFormat() != IDFormatStructured || s.MaxConcurrentStreams() != 8 {
+		t.Fatalf("expect the server to agree on the same settings, got %v and %d", s.IDFormat(), s.MaxConcurrentStreams())
+	}
+
+	if err := client.(*SimpleTracker).NegotiateEcho(2, iprot, oprot, "token"); err == nil {
+		t.Fatal("expect no echo with a ONEWAY handshake")
+	}
+}
+
+func TestSequencing(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+
+	// The edge: calls numbered under the root once the request is set up.
+	edge, _ := client.(*SimpleTracker).EnsureRequestID(context.Background())
+	for i, want := range []string{"1.1", "1.2", "1.3"} {
+		if seq := passRequestHeader(t, edge, client, server).Value(CtxKeySequenceID); seq != want {
+			t.Fatalf("call %d: expect seq %s, got %v", i, want, seq)
+		}
+	}
+	if _, seq := client.RequestSeqIDFromCtx(context.Background()); seq != "1.1" {
+		t.Fatalf("expect an unnumbered context to get the first seq, got %s", seq)
+	}
+
+	// A nested call extends the seq of the incoming request.
+	sctx := passRequestHeader(t, edge, client, server) // 1.4
+	downstream, next := upgradedPair(t, nil, nil)
+	for _, want := range []string{"1.4.1", "1.4.2"} {
+		if seq := passRequestHeader(t, sctx, downstream, next).Value(CtxKeySequenceID); seq != want {
+			t.Fatalf("expect seq %s, got %v", want, seq)
+		}
+	}
+}
+
+func TestSequencingConcurrent(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	sctx := passRequestHeader(t, context.Background(), client, server)
+
+	const calls = 50
+	seqs := make(chan string, calls)
+	for i := 0; i < calls; i++ {
+		go func() {
+			_, seq := server.RequestSeqIDFromCtx(sctx)
+			seqs <- seq
+		}()
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < calls; i++ {
+		seq := <-seqs
+		if seen[seq] || !strings.HasPrefix(seq, "1.1.") {
+			t.Fatalf("expect distinct sibling seqs under 1.1, got %s twice", seq)
+		}
+		seen[seq] = true
+	}
+	if !seen["1.1.1"] || !seen[fmt.Sprintf("1.1.%d", calls)] {
+		t.Fatalf("expect the siblings to be numbered from 1 to %d", calls)
+	}
+}
+
+func TestTryReadRequestHeaderContext(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	read := func(wire, local context.Context) context.Context {
+		t.Helper()
+		prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+		if err := client.TryWriteRequestHeader(wire, prot); err != nil {
+			t.Fatal(err)
+		}
+		ctx, err := server.(*SimpleTracker).TryReadRequestHeaderContext(local, prot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ctx
+	}
+	withMeta := func(ctx context.Context, meta map[string]string) context.Context {
+		return context.WithValue(ctx, CtxKeyRequestMeta, meta)
+	}
+
+	// Wire only.
+	ctx := read(withMeta(WithRequestID(context.Background(), "wire"), map[string]string{"k": "wire"}), context.Background())
+	if ctx.Value(CtxKeyRequestID) != "wire" || metaFromContext(ctx)["k"] != "wire" {
+		t.Fatalf("expect the values of the header, got %v %v", ctx.Value(CtxKeyRequestID), metaFromContext(ctx))
+	}
+
+	// Context only: a header with nothing but what the tracker always writes.
+	local, _ := WithLocale(context.Background(), "de-DE")
+	local = withMeta(local, map[string]string{"http": "x-forwarded", MetaKeyShardKey: "forged"})
+	ctx = read(context.Background(), local)
+	if LocaleFromContext(ctx) != "de-DE" || metaFromContext(ctx)["http"] != "x-forwarded" {
+		t.Fatalf("expect the values of the context to be kept, got %q %v", LocaleFromContext(ctx), metaFromContext(ctx))
+	}
+	if _, ok := ShardKeyFromContext(ctx); ok || metaFromContext(ctx)[MetaKeyShardKey] != "" {
+		t.Fatal("expect reserved keys in the meta of the context to be ignored")
+	}
+
+	// Both: the header wins.
+	wire, _ := WithLocale(WithRequestID(context.Background(), "wire"), "fr-FR")
+	wire = withMeta(wire, map[string]string{"k": "wire"})
+	local = withMeta(WithRequestID(local, "local"), map[string]string{"k": "local", "http": "x-forwarded"})
+	ctx = read(wire, local)
+	if ctx.Value(CtxKeyRequestID) != "wire" || LocaleFromContext(ctx) != "fr-FR" {
+		t.Fatalf("expect the header to win, got %v %q", ctx.Value(CtxKeyRequestID), LocaleFromContext(ctx))
+	}
+	if meta := metaFromContext(ctx); meta["k"] != "wire" || meta["http"] != "x-forwarded" {
+		t.Fatalf("expect the meta merged under the header, got %v", meta)
+	}
+	if HopCountFromContext(ctx) != 1 {
+		t.Fatalf("expect the hop count from the header, got %d", HopCountFromContext(ctx))
+	}
+
+	plain := NewSimpleTracker("plain").(*SimpleTracker)
+	if ctx, _ := plain.TryReadRequestHeaderContext(local, newMemoryProtocol()); ctx != local {
+		t.Fatal("expect ctx as is without upgrade")
+	}
+}
+
+func TestNegotiatedOnce(t *testing.T) {
+	client, server := NewSimpleTracker("client").(*SimpleTracker), NewSimpleTracker("server")
+	if client.Negotiated() {
+		t.Fatal("expect no handshake yet")
+	}
+	cprot, _ := handshake(t, client, server)
+	if !client.Negotiated() {
+		t.Fatal("expect the handshake to be cached")
+	}
+	done := make(chan error, 1)
+	go func() { done <- client.Negotiation(2, cprot, cprot) }() // nobody serves it
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the handshake not to run again")
+	}
+
+	// Another connection, to a server without tracker.
+	cprot2, sprot2 := newProtocolPair(t)
+	go func() {
+		_, _, seqID, _ := sprot2.ReadMessageBegin()
+		sprot2.Skip(thrift.STRUCT)
+		sprot2.ReadMessageEnd()
+		writeUpgradeException(seqID, sprot2, thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "unknown method"))
+	}()
+	if err := client.Negotiation(1, cprot2, cprot2); err != nil {
+		t.Fatal(err)
+	}
+	if !client.Negotiated() || client.RequestHeaderSupported() {
+		t.Fatal("expect the new connection negotiated, without tracking")
+	}
+
+	cprot2.Transport().Close()
+	if client.Negotiated() {
+		t.Fatal("expect a closed connection not to count as negotiated")
+	}
+}
+
+func TestNegotiatedReset(t *testing.T) {
+	base := CurrentConnectionStats()
+	client, server := NewSimpleTracker("client").(*SimpleTracker), NewSimpleTracker("server")
+	cprot, sprot := handshake(t, client, server)
+	client.Reset()
+	if client.Negotiated() || client.RequestHeaderSupported() {
+		t.Fatal("expect the handshake to be forgotten")
+	}
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+	if err := client.Negotiation(2, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !client.RequestHeaderSupported() {
+		t.Fatal("expect the handshake to run again")
+	}
+	client.Close()
+	server.(*SimpleTracker).Close()
+	if got := CurrentConnectionStats(); got != base {
+		t.Fatalf("expect the stats back to %+v, got %+v", base, got)
+	}
+}
+
+func TestNegotiationErrorReasons(t *testing.T) {
+	// The server replies to another call.
+	cprot, sprot := newProtocolPair(t)
+	go func() {
+		_, _, seqID, _ := sprot.ReadMessageBegin()
+		sprot.Skip(thrift.STRUCT)
+		sprot.ReadMessageEnd()
+		sprot.WriteMessageBegin(TrackingAPIName, thrift.REPLY, seqID+1)
+		tracking.NewUpgradeReply().Write(sprot)
+		sprot.WriteMessageEnd()
+		sprot.Flush()
+	}()
+	err := NewSimpleTracker("client").Negotiation(1, cprot, cprot)
+	var nerr *NegotiationError
+	if !errors.As(err, &nerr) || nerr.Reason != ReasonBadSequenceID || nerr.Temporary() {
+		t.Fatalf("expect a BadSequenceID error, got %#v", err)
+	}
+	var x thrift.TApplicationException
+	if !errors.As(err, &x) || x.TypeId() != thrift.BAD_SEQUENCE_ID {
+		t.Fatalf("expect the exception to be wrapped, got %#v", err)
+	}
+
+	// The connection is gone.
+	cprot2, sprot2 := newProtocolPair(t)
+	sprot2.Transport().Close()
+	err = NewSimpleTracker("client").Negotiation(1, cprot2, cprot2)
+	if !errors.As(err, &nerr) || nerr.Reason != ReasonTransport || !nerr.Temporary() {
+		t.Fatalf("expect a Transport error, got %#v", err)
+	}
+
+	// Aborted.
+	cprot3, _ := newProtocolPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = NewSimpleTracker("client").(*SimpleTracker).NegotiationContext(ctx, 1, cprot3, cprot3)
+	if !errors.As(err, &nerr) || nerr.Reason != ReasonAborted {
+		t.Fatalf("expect an Aborted error, got %#v", err)
+	}
+}
+
+func TestTrackerConcurrentRequests(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	downstream, _ := upgradedPair(t, nil, nil)
+	const requests = 20
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		go func(i int) {
+			id := fmt.Sprintf("req-%d", i)
+			ctx := WithRequestMeta(WithRequestID(context.Background(), id), "user", id)
+			prot := newMemoryProtocol()
+			if err := client.TryWriteRequestHeader(ctx, prot); err != nil {
+				errs <- err
+				return
+			}
+			sctx, err := server.TryReadRequestHeader(prot)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for j := 1; j <= 3; j++ { // the calls of a request do not mix with the others
+				reqID, seq := downstream.RequestSeqIDFromCtx(sctx)
+				if reqID != id || seq != fmt.Sprintf("1.1.%d", j) {
+					errs <- fmt.Errorf("%s: expect call %d, got %s %s", id, j, reqID, seq)
+					return
+				}
+			}
+			if meta := metaFromContext(sctx); meta["user"] != id {
+				errs <- fmt.Errorf("%s: expect its own meta, got %v", id, meta)
+				return
+			}
+			errs <- nil
+		}(i)
+	}
+	for i := 0; i < requests; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newProtocolPairOf(t *testing.T, clientFactory, serverFactory thrift.TProtocolFactory) (client, server thrift.TProtocol) {
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	return clientFactory.GetProtocol(thrift.NewTSocketFromConnTimeout(c, time.Second)),
+		serverFactory.GetProtocol(thrift.NewTSocketFromConnTimeout(s, time.Second))
+}
+
+func TestHandshakeProtocols(t *testing.T) {
+	factories := map[string]thrift.TProtocolFactory{
+		"binary":  thrift.NewTBinaryProtocolFactoryDefault(),
+		"compact": thrift.NewTCompactProtocolFactory(),
+	}
+	for name, factory := range factories {
+		cprot, sprot := newProtocolPairOf(t, factory, factory)
+		client, server := NewSimpleTracker("client"), NewSimpleTracker("server")
+		done := make(chan error, 1)
+		go func() { done <- serveUpgrade(server, sprot) }()
+		if err := client.Negotiation(1, cprot, cprot); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !client.RequestHeaderSupported() || !server.RequestHeaderSupported() {
+			t.Fatalf("%s: expect both sides upgraded", name)
+		}
+	}
+
+	// Mismatching protocols fail, none of the sides upgrades.
+	cprot, sprot := newProtocolPairOf(t, factories["compact"], factories["binary"])
+	client, server := NewSimpleTracker("client"), NewSimpleTracker("server")
+	done := make(chan error, 1)
+	go func() {
+		err := serveUpgrade(server, sprot)
+		sprot.Transport().Close() // as the server does on a failure
+		done <- err
+	}()
+	if err := client.Negotiation(1, cprot, cprot); err == nil {
+		t.Fatal("expect the client to fail")
+	}
+	if err := <-done; err == nil {
+		t.Fatal("expect the server to fail")
+	}
+	if client.RequestHeaderSupported() || server.RequestHeaderSupported() {
+		t.Fatal("expect no side upgraded")
+	}
+}
+
+func TestLastUpgradeReply(t *testing.T) {
+	opts := []Option{WithMetaCodecs(JSONMetaCodec), WithMaxConcurrentStreams(8)}
+	client := NewSimpleTracker("client", opts...).(*SimpleTracker)
+	if client.LastUpgradeReply() != nil {
+		t.Fatal("expect no reply before the handshake")
+	}
+	server := NewSimpleTracker("server", opts...).(*SimpleTracker)
+	handshake(t, client, server)
+
+	reply := client.LastUpgradeReply()
+	if reply == nil || reply.GetMetaCodec() != "json" || reply.GetMaxConcurrent() != 8 {
+		t.Fatalf("expect the reply of the server, got %v", reply)
+	}
+	*reply.MetaCodec = "changed"
+	if got := client.LastUpgradeReply().GetMetaCodec(); got != "json" {
+		t.Fatalf("expect a copy, got %q", got)
+	}
+	if server.LastUpgradeReply() != nil {
+		t.Fatal("expect no reply on the server side")
+	}
+	client.Reset()
+	if client.LastUpgradeReply() != nil {
+		t.Fatal("expect no reply once reset")
+	}
+}
+
+func TestUpgradeArgsBuilder(t *testing.T) {
+	var read map[string]string
+	client := NewSimpleTracker("client", WithUpgradeArgsBuilder(func(args *tracking.UpgradeArgs_) {
+		args.Meta = map[string]string{"region": "eu-west-1"}
+	}))
+	server := NewSimpleTracker("server", WithUpgradeArgsReader(func(args *tracking.UpgradeArgs_) {
+		read = args.GetMeta()
+	}))
+	handshake(t, client, server)
+	if !client.RequestHeaderSupported() || read["region"] != "eu-west-1" {
+		t.Fatalf("expect the meta of the client on the server, got %v", read)
+	}
+
+	// Nothing is read from the clients sending none.
+	read = nil
+	handshake(t, NewSimpleTracker("client"), server)
+	if read != nil {
+		t.Fatalf("expect no meta, got %v", read)
+	}
+}
+
+func TestUpgradeArgsUnknownFields(t *testing.T) {
+	// A newer client sends a field this package does not know of, as the
+	// clients sending Meta do to older servers.
+	cprot, sprot := newProtocolPair(t)
+	server := NewSimpleTracker("server")
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+	cprot.WriteMessageBegin(TrackingAPIName, thrift.CALL, 1)
+	cprot.WriteStructBegin("UpgradeArgs")
+	cprot.WriteFieldBegin("app_id", thrift.STRING, 1)
+	cprot.WriteString("client")
+	cprot.WriteFieldEnd()
+	cprot.WriteFieldBegin("future", thrift.MAP, 100)
+	cprot.WriteMapBegin(thrift.STRING, thrift.STRING, 1)
+	cprot.WriteString("k")
+	cprot.WriteString("v")
+	cprot.WriteMapEnd()
+	cprot.WriteFieldEnd()
+	cprot.WriteFieldStop()
+	cprot.WriteStructEnd()
+	cprot.WriteMessageEnd()
+	cprot.Flush()
+
+	name, typeID, _, err := cprot.ReadMessageBegin()
+	if err != nil || name != TrackingAPIName || typeID != thrift.REPLY {
+		t.Fatalf("expect a reply, got %s %v %v", name, typeID, err)
+	}
+	if err := tracking.NewUpgradeReply().Read(cprot); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil || !server.RequestHeaderSupported() {
+		t.Fatalf("expect the server upgraded, got %v", err)
+	}
+}
+
+func TestHandshakeAgainResets(t *testing.T) {
+	opts := []Option{WithIDFormat(IDFormatStructured), WithMetaCodecs(JSONMetaCodec)}
+	server := NewSimpleTracker("server", opts...).(*SimpleTracker)
+	handshake(t, NewSimpleTracker("first", opts...), server)
+	if server.IDFormat() != IDFormatStructured || server.NegotiatedMetaCodec() != JSONMetaCodec {
+		t.Fatalf("expect the first handshake negotiated, got %v %v", server.IDFormat(), server.NegotiatedMetaCodec())
+	}
+
+	// The client reconnects without the settings, the second handshake wins.
+	handshake(t, NewSimpleTracker("second"), server)
+	if server.IDFormat() != IDFormatOpaque || server.NegotiatedMetaCodec() != ThriftMetaCodec || server.PeerAppID() != "second" {
+		t.Fatalf("expect the second handshake to win, got %v %v %s",
+			server.IDFormat(), server.NegotiatedMetaCodec(), server.PeerAppID())
+	}
+
+	// A failed handshake leaves the server not upgraded, as the client is.
+	cprot, sprot := newProtocolPair(t)
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+	args := tracking.NewUpgradeArgs_()
+	args.AppID, args.Magic = "third", thrift.Int32Ptr(TrackingMagic+1)
+	cprot.WriteMessageBegin(TrackingAPIName, thrift.CALL, 1)
+	args.Write(cprot)
+	cprot.WriteMessageEnd()
+	cprot.Flush()
+	if _, typeID, _, err := cprot.ReadMessageBegin(); err != nil || typeID != thrift.EXCEPTION {
+		t.Fatalf("expect an exception, got %v %v", typeID, err)
+	}
+	if _, err := thrift.NewTApplicationException(0, "").Read(cprot); err != nil {
+		t.Fatal(err)
+	}
+	cprot.ReadMessageEnd()
+	if err := <-done; err == nil {
+		t.Fatal("expect the handshake to fail")
+	}
+	if server.RequestHeaderSupported() || server.PeerAppID() != "" {
+		t.Fatal("expect the state of the former handshakes gone")
+	}
+}
@@ -0,0 +1,65 @@
+// Package trackertest provides utilities for testing the propagation of
+// the tracker across services.
+package trackertest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/damnever/thrift-tracker"
+)
+
+// ChainSimulator pipes a request through a chain of simulated services, each
+// reading the incoming request header and writing an outgoing one, without
+// any real service nor network.
+type ChainSimulator struct {
+	links []link
+}
+
+// link is the connection from a service to the next one.
+type link struct {
+	client, server tracker.Tracker
+}
+
+// Handler plays the service at hop, it gets the context read from the
+// incoming request header, hop 0 is the edge and gets the context passed to
+// Run, and returns the context of the call to the next service.
+type Handler func(hop int, ctx context.Context) context.Context
+
+// NewChainSimulator returns a ChainSimulator of n calls, n+1 services, whose
+// trackers are created with opts and went through the handshake.
+func NewChainSimulator(n int, opts ...tracker.Option) (*ChainSimulator, error) {
+	c := &ChainSimulator{}
+	for i := 0; i < n; i++ {
+		l := link{
+			client: tracker.NewSimpleTracker(fmt.Sprintf("service-%d", i), opts...),
+			server: tracker.NewSimpleTracker(fmt.Sprintf("service-%d", i+1), opts...),
+		}
+		if _, _, err := RunHandshake(l.client, l.server); err != nil {
+			return nil, err
+		}
+		c.links = append(c.links, l)
+	}
+	return c, nil
+}
+
+// Run sends a request made with ctx through the chain, handler, if not nil,
+// is called at every service but the last one before calling the next. It
+// returns the context read by the last service.
+func (c *ChainSimulator) Run(ctx context.Context, handler Handler) (context.Context, error) {
+	for i, l := range c.links {
+		if handler != nil {
+			ctx = handler(i, ctx)
+		}
+		prot := thrift.NewTBinaryProtocolTransport(thrift.NewTMemoryBuffer())
+		if err := l.client.TryWriteRequestHeader(ctx, prot); err != nil {
+			return ctx, fmt.Errorf("hop %d: %v", i, err)
+		}
+		var err error
+		if ctx, err = l.server.TryReadRequestHeader(prot); err != nil {
+			return ctx, fmt.Errorf("hop %d: %v", i, err)
+		}
+	}
+	return ctx, nil
+}
@@ -0,0 +1,70 @@
+package trackertest
+
+import (
+	"context"
+	"testing"
+
+	tracker "github.com/damnever/thrift-tracker"
+)
+
+func TestChainSimulatorBaggage(t *testing.T) {
+	chain, err := NewChainSimulator(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, _ := tracker.WithLocale(context.Background(), "fr-CA")
+	ctx = tracker.WithShardKey(ctx, "user-42")
+	ctx = tracker.WithRequestID(ctx, "req")
+	ctx = context.WithValue(ctx, tracker.CtxKeyRequestMeta, map[string]string{"k": "v"})
+
+	var seen []int
+	last, err := chain.Run(ctx, func(hop int, ctx context.Context) context.Context {
+		seen = append(seen, hop)
+		if n := tracker.HopCountFromContext(ctx); n != hop {
+			t.Errorf("hop %d: expect hop count %d, got %d", hop, hop, n)
+		}
+		return ctx
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 4 {
+		t.Fatalf("expect the handler at every service but the last, got %v", seen)
+	}
+	if tracker.LocaleFromContext(last) != "fr-CA" || last.Value(tracker.CtxKeyRequestID) != "req" {
+		t.Fatal("expect the baggage to survive the chain")
+	}
+	if key, _ := tracker.ShardKeyFromContext(last); key != "user-42" {
+		t.Fatalf("expect the shard key to survive the chain, got %q", key)
+	}
+	if meta, _ := last.Value(tracker.CtxKeyRequestMeta).(map[string]string); meta["k"] != "v" {
+		t.Fatalf("expect the meta to survive the chain, got %v", meta)
+	}
+	if n := tracker.HopCountFromContext(last); n != 4 {
+		t.Fatalf("expect 4 hops, got %d", n)
+	}
+}
+
+func TestChainSimulatorPerHop(t *testing.T) {
+	chain, err := NewChainSimulator(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := chain.Run(tracker.WithBudget(context.Background(), 10), func(hop int, ctx context.Context) context.Context {
+		if hop == 1 { // a service resetting the budget of its calls
+			return tracker.WithBudget(ctx, 5)
+		}
+		return ctx
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := tracker.BudgetFromContext(last); n != 3 {
+		t.Fatalf("expect the budget reset at hop 1 to be spent per hop, got %d", n)
+	}
+
+	if _, err := chain.Run(tracker.WithBudget(context.Background(), 2), nil); err == nil ||
+		err.Error() != "hop 2: "+tracker.ErrBudgetExhausted.Error() {
+		t.Fatalf("expect the budget to run out at hop 2, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package trackertest
+
+import (
+	"net"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	tracker "github.com/damnever/thrift-tracker"
+)
+
+// ProtocolPair is a connected pair of binary protocols over an in-memory
+// pipe: what is written to one side is read from the other. Each write
+// blocks until it is read, the sides are to be used by distinct goroutines.
+type ProtocolPair struct {
+	Client, Server thrift.TProtocol
+	c, s           net.Conn
+}
+
+// NewProtocolPair returns a connected ProtocolPair, to be closed once done.
+func NewProtocolPair() *ProtocolPair {
+	c, s := net.Pipe()
+	return &ProtocolPair{
+		Client: thrift.NewTBinaryProtocolTransport(thrift.NewTSocketFromConnTimeout(c, 0)),
+		Server: thrift.NewTBinaryProtocolTransport(thrift.NewTSocketFromConnTimeout(s, 0)),
+		c:      c,
+		s:      s,
+	}
+}
+
+// Close closes both sides, the pending reads and writes fail.
+func (p *ProtocolPair) Close() error {
+	p.c.Close()
+	return p.s.Close()
+}
+
+// RunHandshake drives the Negotiation of client against the TryUpgrade of
+// server over a new ProtocolPair. It returns whether each side ends up
+// upgraded, see RequestHeaderSupported.
+func RunHandshake(client, server tracker.HandShaker) (clientUpgraded, serverUpgraded bool, err error) {
+	pair := NewProtocolPair()
+	defer pair.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, seqID, err := pair.Server.ReadMessageBegin()
+		if err == nil {
+			_, err = server.TryUpgrade(seqID, pair.Server, pair.Server)
+		}
+		done <- err
+	}()
+	err = client.Negotiation(tracker.HandshakeSeqID, pair.Client, pair.Client)
+	if err != nil {
+		pair.Close() // unblock the server
+	}
+	if serr := <-done; err == nil {
+		err = serr
+	}
+	return client.RequestHeaderSupported(), server.RequestHeaderSupported(), err
+}
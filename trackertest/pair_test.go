@@ -0,0 +1,58 @@
+package trackertest
+
+import (
+	"context"
+	"testing"
+
+	tracker "github.com/damnever/thrift-tracker"
+)
+
+func TestRunHandshake(t *testing.T) {
+	client, server := tracker.NewSimpleTracker("client"), tracker.NewSimpleTracker("server")
+	cu, su, err := RunHandshake(client, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cu || !su {
+		t.Fatalf("expect both sides upgraded, got %v and %v", cu, su)
+	}
+}
+
+func TestRunHandshakeWithoutTracking(t *testing.T) {
+	cu, su, err := RunHandshake(tracker.NewSimpleTracker("client"), tracker.NoopTracker{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cu || su {
+		t.Fatalf("expect both sides without tracking, got %v and %v", cu, su)
+	}
+}
+
+func TestProtocolPair(t *testing.T) {
+	pair := NewProtocolPair()
+	defer pair.Close()
+	client, server := tracker.NewSimpleTracker("client"), tracker.NewSimpleTracker("server")
+	if _, _, err := RunHandshake(client, server); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := tracker.WithRequestID(context.Background(), "req")
+	done := make(chan error, 1)
+	go func() {
+		err := client.TryWriteRequestHeader(ctx, pair.Client)
+		if err == nil {
+			err = pair.Client.Flush()
+		}
+		done <- err
+	}()
+	got, err := server.TryReadRequestHeader(pair.Server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got.Value(tracker.CtxKeyRequestID) != "req" {
+		t.Fatal("expect the request header across the pair")
+	}
+}
@@ -5,6 +5,9 @@ struct RequestHeader {
     1: string request_id
     2: string seq
     3: map<string, string> meta
+    4: optional binary meta_blob    // meta encoded by the codec below, instead of the map
+    5: optional string meta_codec
+    6: optional i32 schema_ver      // the schema version of this struct, absent means the current one
 }
 
 struct ResponseHeader {
@@ -15,8 +18,20 @@ struct ResponseHeader {
  * This is the struct that a successful upgrade will reply with.
  */
 struct UpgradeReply {
+    1: optional i32 id_format   // the request ID format both sides agreed on
+    2: optional i32 max_concurrent  // the concurrent in-flight requests both sides support, 0 for unlimited
+    3: optional string echo     // the echo of the args, if any
+    4: optional i32 max_meta_entries  // the meta entries per request header both sides accept, 0 for unlimited
+    5: optional string meta_codec   // the meta codec picked out of the ones of the args
 }
 
 struct UpgradeArgs {
     1: string app_id
+    2: optional i32 id_format   // the request ID format the client prefers
+    3: optional i32 max_concurrent  // the concurrent in-flight requests the client supports, 0 for unlimited
+    4: optional string echo     // a token the server must send back, to check the handshake end to end
+    5: optional i32 magic       // the magic number of the tracking protocol, absent from older clients
+    6: optional i32 max_meta_entries  // the meta entries per request header the client accepts, 0 for unlimited
+    7: optional list<string> meta_codecs  // the meta codecs the client supports, in order of preference
+    8: optional map<string, string> meta  // deployment metadata of the client, its region for example
 }
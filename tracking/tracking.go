@@ -18,10 +18,16 @@ var _ = bytes.Equal
 //  - RequestID
 //  - Seq
 //  - Meta
+//  - MetaBlob
+//  - MetaCodec
+//  - SchemaVer
 type RequestHeader struct {
   RequestID string `thrift:"request_id,1" db:"request_id" json:"request_id"`
   Seq string `thrift:"seq,2" db:"seq" json:"seq"`
   Meta map[string]string `thrift:"meta,3" db:"meta" json:"meta"`
+  MetaBlob []byte `thrift:"meta_blob,4" db:"meta_blob" json:"meta_blob,omitempty"`
+  MetaCodec *string `thrift:"meta_codec,5" db:"meta_codec" json:"meta_codec,omitempty"`
+  SchemaVer *int32 `thrift:"schema_ver,6" db:"schema_ver" json:"schema_ver,omitempty"`
 }
 
 func NewRequestHeader() *RequestHeader {
@@ -40,6 +46,37 @@ func (p *RequestHeader) GetSeq() string {
 func (p *RequestHeader) GetMeta() map[string]string {
   return p.Meta
 }
+var RequestHeader_MetaBlob_DEFAULT []byte
+
+func (p *RequestHeader) GetMetaBlob() []byte {
+  return p.MetaBlob
+}
+var RequestHeader_MetaCodec_DEFAULT string
+func (p *RequestHeader) GetMetaCodec() string {
+  if !p.IsSetMetaCodec() {
+    return RequestHeader_MetaCodec_DEFAULT
+  }
+return *p.MetaCodec
+}
+var RequestHeader_SchemaVer_DEFAULT int32
+func (p *RequestHeader) GetSchemaVer() int32 {
+  if !p.IsSetSchemaVer() {
+    return RequestHeader_SchemaVer_DEFAULT
+  }
+return *p.SchemaVer
+}
+func (p *RequestHeader) IsSetMetaBlob() bool {
+  return p.MetaBlob != nil
+}
+
+func (p *RequestHeader) IsSetMetaCodec() bool {
+  return p.MetaCodec != nil
+}
+
+func (p *RequestHeader) IsSetSchemaVer() bool {
+  return p.SchemaVer != nil
+}
+
 func (p *RequestHeader) Read(iprot thrift.TProtocol) error {
   if _, err := iprot.ReadStructBegin(); err != nil {
     return thrift.PrependError(fmt.Sprintf("%T read error: ", p), err)
@@ -65,6 +102,18 @@ func (p *RequestHeader) Read(iprot thrift.TProtocol) error {
       if err := p.ReadField3(iprot); err != nil {
         return err
       }
+    case 4:
+      if err := p.ReadField4(iprot); err != nil {
+        return err
+      }
+    case 5:
+      if err := p.ReadField5(iprot); err != nil {
+        return err
+      }
+    case 6:
+      if err := p.ReadField6(iprot); err != nil {
+        return err
+      }
     default:
       if err := iprot.Skip(fieldTypeId); err != nil {
         return err
@@ -126,6 +175,33 @@ var _val1 string
   return nil
 }
 
+func (p *RequestHeader)  ReadField4(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadBinary(); err != nil {
+  return thrift.PrependError("error reading field 4: ", err)
+} else {
+  p.MetaBlob = v
+}
+  return nil
+}
+
+func (p *RequestHeader)  ReadField5(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadString(); err != nil {
+  return thrift.PrependError("error reading field 5: ", err)
+} else {
+  p.MetaCodec = &v
+}
+  return nil
+}
+
+func (p *RequestHeader)  ReadField6(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadI32(); err != nil {
+  return thrift.PrependError("error reading field 6: ", err)
+} else {
+  p.SchemaVer = &v
+}
+  return nil
+}
+
 func (p *RequestHeader) Write(oprot thrift.TProtocol) error {
   if err := oprot.WriteStructBegin("RequestHeader"); err != nil {
     return thrift.PrependError(fmt.Sprintf("%T write struct begin error: ", p), err) }
@@ -133,6 +209,9 @@ func (p *RequestHeader) Write(oprot thrift.TProtocol) error {
     if err := p.writeField1(oprot); err != nil { return err }
     if err := p.writeField2(oprot); err != nil { return err }
     if err := p.writeField3(oprot); err != nil { return err }
+    if err := p.writeField4(oprot); err != nil { return err }
+    if err := p.writeField5(oprot); err != nil { return err }
+    if err := p.writeField6(oprot); err != nil { return err }
   }
   if err := oprot.WriteFieldStop(); err != nil {
     return thrift.PrependError("write field stop error: ", err) }
@@ -181,6 +260,42 @@ func (p *RequestHeader) writeField3(oprot thrift.TProtocol) (err error) {
   return err
 }
 
+func (p *RequestHeader) writeField4(oprot thrift.TProtocol) (err error) {
+  if p.IsSetMetaBlob() {
+    if err := oprot.WriteFieldBegin("meta_blob", thrift.STRING, 4); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 4:meta_blob: ", p), err) }
+    if err := oprot.WriteBinary(p.MetaBlob); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.meta_blob (4) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 4:meta_blob: ", p), err) }
+  }
+  return err
+}
+
+func (p *RequestHeader) writeField5(oprot thrift.TProtocol) (err error) {
+  if p.IsSetMetaCodec() {
+    if err := oprot.WriteFieldBegin("meta_codec", thrift.STRING, 5); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 5:meta_codec: ", p), err) }
+    if err := oprot.WriteString(string(*p.MetaCodec)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.meta_codec (5) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 5:meta_codec: ", p), err) }
+  }
+  return err
+}
+
+func (p *RequestHeader) writeField6(oprot thrift.TProtocol) (err error) {
+  if p.IsSetSchemaVer() {
+    if err := oprot.WriteFieldBegin("schema_ver", thrift.I32, 6); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 6:schema_ver: ", p), err) }
+    if err := oprot.WriteI32(int32(*p.SchemaVer)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.schema_ver (6) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 6:schema_ver: ", p), err) }
+  }
+  return err
+}
+
 func (p *RequestHeader) String() string {
   if p == nil {
     return "<nil>"
@@ -303,13 +418,80 @@ func (p *ResponseHeader) String() string {
 }
 
 // This is the struct that a successful upgrade will reply with.
+// 
+// Attributes:
+//  - IDFormat
+//  - MaxConcurrent
+//  - Echo
+//  - MaxMetaEntries
+//  - MetaCodec
 type UpgradeReply struct {
+  IDFormat *int32 `thrift:"id_format,1" db:"id_format" json:"id_format,omitempty"`
+  MaxConcurrent *int32 `thrift:"max_concurrent,2" db:"max_concurrent" json:"max_concurrent,omitempty"`
+  Echo *string `thrift:"echo,3" db:"echo" json:"echo,omitempty"`
+  MaxMetaEntries *int32 `thrift:"max_meta_entries,4" db:"max_meta_entries" json:"max_meta_entries,omitempty"`
+  MetaCodec *string `thrift:"meta_codec,5" db:"meta_codec" json:"meta_codec,omitempty"`
 }
 
 func NewUpgradeReply() *UpgradeReply {
   return &UpgradeReply{}
 }
 
+var UpgradeReply_IDFormat_DEFAULT int32
+func (p *UpgradeReply) GetIDFormat() int32 {
+  if !p.IsSetIDFormat() {
+    return UpgradeReply_IDFormat_DEFAULT
+  }
+return *p.IDFormat
+}
+var UpgradeReply_MaxConcurrent_DEFAULT int32
+func (p *UpgradeReply) GetMaxConcurrent() int32 {
+  if !p.IsSetMaxConcurrent() {
+    return UpgradeReply_MaxConcurrent_DEFAULT
+  }
+return *p.MaxConcurrent
+}
+var UpgradeReply_Echo_DEFAULT string
+func (p *UpgradeReply) GetEcho() string {
+  if !p.IsSetEcho() {
+    return UpgradeReply_Echo_DEFAULT
+  }
+return *p.Echo
+}
+var UpgradeReply_MaxMetaEntries_DEFAULT int32
+func (p *UpgradeReply) GetMaxMetaEntries() int32 {
+  if !p.IsSetMaxMetaEntries() {
+    return UpgradeReply_MaxMetaEntries_DEFAULT
+  }
+return *p.MaxMetaEntries
+}
+var UpgradeReply_MetaCodec_DEFAULT string
+func (p *UpgradeReply) GetMetaCodec() string {
+  if !p.IsSetMetaCodec() {
+    return UpgradeReply_MetaCodec_DEFAULT
+  }
+return *p.MetaCodec
+}
+func (p *UpgradeReply) IsSetIDFormat() bool {
+  return p.IDFormat != nil
+}
+
+func (p *UpgradeReply) IsSetMaxConcurrent() bool {
+  return p.MaxConcurrent != nil
+}
+
+func (p *UpgradeReply) IsSetEcho() bool {
+  return p.Echo != nil
+}
+
+func (p *UpgradeReply) IsSetMaxMetaEntries() bool {
+  return p.MaxMetaEntries != nil
+}
+
+func (p *UpgradeReply) IsSetMetaCodec() bool {
+  return p.MetaCodec != nil
+}
+
 func (p *UpgradeReply) Read(iprot thrift.TProtocol) error {
   if _, err := iprot.ReadStructBegin(); err != nil {
     return thrift.PrependError(fmt.Sprintf("%T read error: ", p), err)
@@ -322,8 +504,31 @@ func (p *UpgradeReply) Read(iprot thrift.TProtocol) error {
       return thrift.PrependError(fmt.Sprintf("%T field %d read error: ", p, fieldId), err)
     }
     if fieldTypeId == thrift.STOP { break; }
-    if err := iprot.Skip(fieldTypeId); err != nil {
-      return err
+    switch fieldId {
+    case 1:
+      if err := p.ReadField1(iprot); err != nil {
+        return err
+      }
+    case 2:
+      if err := p.ReadField2(iprot); err != nil {
+        return err
+      }
+    case 3:
+      if err := p.ReadField3(iprot); err != nil {
+        return err
+      }
+    case 4:
+      if err := p.ReadField4(iprot); err != nil {
+        return err
+      }
+    case 5:
+      if err := p.ReadField5(iprot); err != nil {
+        return err
+      }
+    default:
+      if err := iprot.Skip(fieldTypeId); err != nil {
+        return err
+      }
     }
     if err := iprot.ReadFieldEnd(); err != nil {
       return err
@@ -335,10 +540,60 @@ func (p *UpgradeReply) Read(iprot thrift.TProtocol) error {
   return nil
 }
 
+func (p *UpgradeReply)  ReadField1(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadI32(); err != nil {
+  return thrift.PrependError("error reading field 1: ", err)
+} else {
+  p.IDFormat = &v
+}
+  return nil
+}
+
+func (p *UpgradeReply)  ReadField2(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadI32(); err != nil {
+  return thrift.PrependError("error reading field 2: ", err)
+} else {
+  p.MaxConcurrent = &v
+}
+  return nil
+}
+
+func (p *UpgradeReply)  ReadField3(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadString(); err != nil {
+  return thrift.PrependError("error reading field 3: ", err)
+} else {
+  p.Echo = &v
+}
+  return nil
+}
+
+func (p *UpgradeReply)  ReadField4(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadI32(); err != nil {
+  return thrift.PrependError("error reading field 4: ", err)
+} else {
+  p.MaxMetaEntries = &v
+}
+  return nil
+}
+
+func (p *UpgradeReply)  ReadField5(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadString(); err != nil {
+  return thrift.PrependError("error reading field 5: ", err)
+} else {
+  p.MetaCodec = &v
+}
+  return nil
+}
+
 func (p *UpgradeReply) Write(oprot thrift.TProtocol) error {
   if err := oprot.WriteStructBegin("UpgradeReply"); err != nil {
     return thrift.PrependError(fmt.Sprintf("%T write struct begin error: ", p), err) }
   if p != nil {
+    if err := p.writeField1(oprot); err != nil { return err }
+    if err := p.writeField2(oprot); err != nil { return err }
+    if err := p.writeField3(oprot); err != nil { return err }
+    if err := p.writeField4(oprot); err != nil { return err }
+    if err := p.writeField5(oprot); err != nil { return err }
   }
   if err := oprot.WriteFieldStop(); err != nil {
     return thrift.PrependError("write field stop error: ", err) }
@@ -347,6 +602,66 @@ func (p *UpgradeReply) Write(oprot thrift.TProtocol) error {
   return nil
 }
 
+func (p *UpgradeReply) writeField1(oprot thrift.TProtocol) (err error) {
+  if p.IsSetIDFormat() {
+    if err := oprot.WriteFieldBegin("id_format", thrift.I32, 1); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 1:id_format: ", p), err) }
+    if err := oprot.WriteI32(int32(*p.IDFormat)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.id_format (1) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 1:id_format: ", p), err) }
+  }
+  return err
+}
+
+func (p *UpgradeReply) writeField2(oprot thrift.TProtocol) (err error) {
+  if p.IsSetMaxConcurrent() {
+    if err := oprot.WriteFieldBegin("max_concurrent", thrift.I32, 2); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 2:max_concurrent: ", p), err) }
+    if err := oprot.WriteI32(int32(*p.MaxConcurrent)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.max_concurrent (2) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 2:max_concurrent: ", p), err) }
+  }
+  return err
+}
+
+func (p *UpgradeReply) writeField3(oprot thrift.TProtocol) (err error) {
+  if p.IsSetEcho() {
+    if err := oprot.WriteFieldBegin("echo", thrift.STRING, 3); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 3:echo: ", p), err) }
+    if err := oprot.WriteString(string(*p.Echo)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.echo (3) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 3:echo: ", p), err) }
+  }
+  return err
+}
+
+func (p *UpgradeReply) writeField4(oprot thrift.TProtocol) (err error) {
+  if p.IsSetMaxMetaEntries() {
+    if err := oprot.WriteFieldBegin("max_meta_entries", thrift.I32, 4); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 4:max_meta_entries: ", p), err) }
+    if err := oprot.WriteI32(int32(*p.MaxMetaEntries)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.max_meta_entries (4) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 4:max_meta_entries: ", p), err) }
+  }
+  return err
+}
+
+func (p *UpgradeReply) writeField5(oprot thrift.TProtocol) (err error) {
+  if p.IsSetMetaCodec() {
+    if err := oprot.WriteFieldBegin("meta_codec", thrift.STRING, 5); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 5:meta_codec: ", p), err) }
+    if err := oprot.WriteString(string(*p.MetaCodec)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.meta_codec (5) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 5:meta_codec: ", p), err) }
+  }
+  return err
+}
+
 func (p *UpgradeReply) String() string {
   if p == nil {
     return "<nil>"
@@ -356,8 +671,22 @@ func (p *UpgradeReply) String() string {
 
 // Attributes:
 //  - AppID
+//  - IDFormat
+//  - MaxConcurrent
+//  - Echo
+//  - Magic
+//  - MaxMetaEntries
+//  - MetaCodecs
+//  - Meta
 type UpgradeArgs_ struct {
   AppID string `thrift:"app_id,1" db:"app_id" json:"app_id"`
+  IDFormat *int32 `thrift:"id_format,2" db:"id_format" json:"id_format,omitempty"`
+  MaxConcurrent *int32 `thrift:"max_concurrent,3" db:"max_concurrent" json:"max_concurrent,omitempty"`
+  Echo *string `thrift:"echo,4" db:"echo" json:"echo,omitempty"`
+  Magic *int32 `thrift:"magic,5" db:"magic" json:"magic,omitempty"`
+  MaxMetaEntries *int32 `thrift:"max_meta_entries,6" db:"max_meta_entries" json:"max_meta_entries,omitempty"`
+  MetaCodecs []string `thrift:"meta_codecs,7" db:"meta_codecs" json:"meta_codecs,omitempty"`
+  Meta map[string]string `thrift:"meta,8" db:"meta" json:"meta,omitempty"`
 }
 
 func NewUpgradeArgs_() *UpgradeArgs_ {
@@ -368,6 +697,79 @@ func NewUpgradeArgs_() *UpgradeArgs_ {
 func (p *UpgradeArgs_) GetAppID() string {
   return p.AppID
 }
+var UpgradeArgs__IDFormat_DEFAULT int32
+func (p *UpgradeArgs_) GetIDFormat() int32 {
+  if !p.IsSetIDFormat() {
+    return UpgradeArgs__IDFormat_DEFAULT
+  }
+return *p.IDFormat
+}
+var UpgradeArgs__MaxConcurrent_DEFAULT int32
+func (p *UpgradeArgs_) GetMaxConcurrent() int32 {
+  if !p.IsSetMaxConcurrent() {
+    return UpgradeArgs__MaxConcurrent_DEFAULT
+  }
+return *p.MaxConcurrent
+}
+var UpgradeArgs__Echo_DEFAULT string
+func (p *UpgradeArgs_) GetEcho() string {
+  if !p.IsSetEcho() {
+    return UpgradeArgs__Echo_DEFAULT
+  }
+return *p.Echo
+}
+var UpgradeArgs__Magic_DEFAULT int32
+func (p *UpgradeArgs_) GetMagic() int32 {
+  if !p.IsSetMagic() {
+    return UpgradeArgs__Magic_DEFAULT
+  }
+return *p.Magic
+}
+var UpgradeArgs__MaxMetaEntries_DEFAULT int32
+func (p *UpgradeArgs_) GetMaxMetaEntries() int32 {
+  if !p.IsSetMaxMetaEntries() {
+    return UpgradeArgs__MaxMetaEntries_DEFAULT
+  }
+return *p.MaxMetaEntries
+}
+var UpgradeArgs__MetaCodecs_DEFAULT []string
+
+func (p *UpgradeArgs_) GetMetaCodecs() []string {
+  return p.MetaCodecs
+}
+var UpgradeArgs__Meta_DEFAULT map[string]string
+
+func (p *UpgradeArgs_) GetMeta() map[string]string {
+  return p.Meta
+}
+func (p *UpgradeArgs_) IsSetIDFormat() bool {
+  return p.IDFormat != nil
+}
+
+func (p *UpgradeArgs_) IsSetMaxConcurrent() bool {
+  return p.MaxConcurrent != nil
+}
+
+func (p *UpgradeArgs_) IsSetEcho() bool {
+  return p.Echo != nil
+}
+
+func (p *UpgradeArgs_) IsSetMagic() bool {
+  return p.Magic != nil
+}
+
+func (p *UpgradeArgs_) IsSetMaxMetaEntries() bool {
+  return p.MaxMetaEntries != nil
+}
+
+func (p *UpgradeArgs_) IsSetMetaCodecs() bool {
+  return p.MetaCodecs != nil
+}
+
+func (p *UpgradeArgs_) IsSetMeta() bool {
+  return p.Meta != nil
+}
+
 func (p *UpgradeArgs_) Read(iprot thrift.TProtocol) error {
   if _, err := iprot.ReadStructBegin(); err != nil {
     return thrift.PrependError(fmt.Sprintf("%T read error: ", p), err)
@@ -385,6 +787,34 @@ func (p *UpgradeArgs_) Read(iprot thrift.TProtocol) error {
       if err := p.ReadField1(iprot); err != nil {
         return err
       }
+    case 2:
+      if err := p.ReadField2(iprot); err != nil {
+        return err
+      }
+    case 3:
+      if err := p.ReadField3(iprot); err != nil {
+        return err
+      }
+    case 4:
+      if err := p.ReadField4(iprot); err != nil {
+        return err
+      }
+    case 5:
+      if err := p.ReadField5(iprot); err != nil {
+        return err
+      }
+    case 6:
+      if err := p.ReadField6(iprot); err != nil {
+        return err
+      }
+    case 7:
+      if err := p.ReadField7(iprot); err != nil {
+        return err
+      }
+    case 8:
+      if err := p.ReadField8(iprot); err != nil {
+        return err
+      }
     default:
       if err := iprot.Skip(fieldTypeId); err != nil {
         return err
@@ -409,11 +839,113 @@ func (p *UpgradeArgs_)  ReadField1(iprot thrift.TProtocol) error {
   return nil
 }
 
+func (p *UpgradeArgs_)  ReadField2(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadI32(); err != nil {
+  return thrift.PrependError("error reading field 2: ", err)
+} else {
+  p.IDFormat = &v
+}
+  return nil
+}
+
+func (p *UpgradeArgs_)  ReadField3(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadI32(); err != nil {
+  return thrift.PrependError("error reading field 3: ", err)
+} else {
+  p.MaxConcurrent = &v
+}
+  return nil
+}
+
+func (p *UpgradeArgs_)  ReadField4(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadString(); err != nil {
+  return thrift.PrependError("error reading field 4: ", err)
+} else {
+  p.Echo = &v
+}
+  return nil
+}
+
+func (p *UpgradeArgs_)  ReadField5(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadI32(); err != nil {
+  return thrift.PrependError("error reading field 5: ", err)
+} else {
+  p.Magic = &v
+}
+  return nil
+}
+
+func (p *UpgradeArgs_)  ReadField6(iprot thrift.TProtocol) error {
+  if v, err := iprot.ReadI32(); err != nil {
+  return thrift.PrependError("error reading field 6: ", err)
+} else {
+  p.MaxMetaEntries = &v
+}
+  return nil
+}
+
+func (p *UpgradeArgs_)  ReadField7(iprot thrift.TProtocol) error {
+  _, size, err := iprot.ReadListBegin()
+  if err != nil {
+    return thrift.PrependError("error reading list begin: ", err)
+  }
+  tSlice := make([]string, 0, size)
+  p.MetaCodecs =  tSlice
+  for i := 0; i < size; i ++ {
+var _elem4 string
+    if v, err := iprot.ReadString(); err != nil {
+    return thrift.PrependError("error reading field 0: ", err)
+} else {
+    _elem4 = v
+}
+    p.MetaCodecs = append(p.MetaCodecs, _elem4)
+  }
+  if err := iprot.ReadListEnd(); err != nil {
+    return thrift.PrependError("error reading list end: ", err)
+  }
+  return nil
+}
+
+func (p *UpgradeArgs_)  ReadField8(iprot thrift.TProtocol) error {
+  _, _, size, err := iprot.ReadMapBegin()
+  if err != nil {
+    return thrift.PrependError("error reading map begin: ", err)
+  }
+  tMap := make(map[string]string, size)
+  p.Meta =  tMap
+  for i := 0; i < size; i ++ {
+var _key5 string
+    if v, err := iprot.ReadString(); err != nil {
+    return thrift.PrependError("error reading field 0: ", err)
+} else {
+    _key5 = v
+}
+var _val6 string
+    if v, err := iprot.ReadString(); err != nil {
+    return thrift.PrependError("error reading field 0: ", err)
+} else {
+    _val6 = v
+}
+    p.Meta[_key5] = _val6
+  }
+  if err := iprot.ReadMapEnd(); err != nil {
+    return thrift.PrependError("error reading map end: ", err)
+  }
+  return nil
+}
+
 func (p *UpgradeArgs_) Write(oprot thrift.TProtocol) error {
   if err := oprot.WriteStructBegin("UpgradeArgs"); err != nil {
     return thrift.PrependError(fmt.Sprintf("%T write struct begin error: ", p), err) }
   if p != nil {
     if err := p.writeField1(oprot); err != nil { return err }
+    if err := p.writeField2(oprot); err != nil { return err }
+    if err := p.writeField3(oprot); err != nil { return err }
+    if err := p.writeField4(oprot); err != nil { return err }
+    if err := p.writeField5(oprot); err != nil { return err }
+    if err := p.writeField6(oprot); err != nil { return err }
+    if err := p.writeField7(oprot); err != nil { return err }
+    if err := p.writeField8(oprot); err != nil { return err }
   }
   if err := oprot.WriteFieldStop(); err != nil {
     return thrift.PrependError("write field stop error: ", err) }
@@ -432,6 +964,108 @@ func (p *UpgradeArgs_) writeField1(oprot thrift.TProtocol) (err error) {
   return err
 }
 
+func (p *UpgradeArgs_) writeField2(oprot thrift.TProtocol) (err error) {
+  if p.IsSetIDFormat() {
+    if err := oprot.WriteFieldBegin("id_format", thrift.I32, 2); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 2:id_format: ", p), err) }
+    if err := oprot.WriteI32(int32(*p.IDFormat)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.id_format (2) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 2:id_format: ", p), err) }
+  }
+  return err
+}
+
+func (p *UpgradeArgs_) writeField3(oprot thrift.TProtocol) (err error) {
+  if p.IsSetMaxConcurrent() {
+    if err := oprot.WriteFieldBegin("max_concurrent", thrift.I32, 3); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 3:max_concurrent: ", p), err) }
+    if err := oprot.WriteI32(int32(*p.MaxConcurrent)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.max_concurrent (3) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 3:max_concurrent: ", p), err) }
+  }
+  return err
+}
+
+func (p *UpgradeArgs_) writeField4(oprot thrift.TProtocol) (err error) {
+  if p.IsSetEcho() {
+    if err := oprot.WriteFieldBegin("echo", thrift.STRING, 4); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 4:echo: ", p), err) }
+    if err := oprot.WriteString(string(*p.Echo)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.echo (4) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 4:echo: ", p), err) }
+  }
+  return err
+}
+
+func (p *UpgradeArgs_) writeField5(oprot thrift.TProtocol) (err error) {
+  if p.IsSetMagic() {
+    if err := oprot.WriteFieldBegin("magic", thrift.I32, 5); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 5:magic: ", p), err) }
+    if err := oprot.WriteI32(int32(*p.Magic)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.magic (5) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 5:magic: ", p), err) }
+  }
+  return err
+}
+
+func (p *UpgradeArgs_) writeField6(oprot thrift.TProtocol) (err error) {
+  if p.IsSetMaxMetaEntries() {
+    if err := oprot.WriteFieldBegin("max_meta_entries", thrift.I32, 6); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 6:max_meta_entries: ", p), err) }
+    if err := oprot.WriteI32(int32(*p.MaxMetaEntries)); err != nil {
+    return thrift.PrependError(fmt.Sprintf("%T.max_meta_entries (6) field write error: ", p), err) }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 6:max_meta_entries: ", p), err) }
+  }
+  return err
+}
+
+func (p *UpgradeArgs_) writeField7(oprot thrift.TProtocol) (err error) {
+  if p.IsSetMetaCodecs() {
+    if err := oprot.WriteFieldBegin("meta_codecs", thrift.LIST, 7); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 7:meta_codecs: ", p), err) }
+    if err := oprot.WriteListBegin(thrift.STRING, len(p.MetaCodecs)); err != nil {
+      return thrift.PrependError("error writing list begin: ", err)
+    }
+    for _, v := range p.MetaCodecs {
+      if err := oprot.WriteString(string(v)); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T. (0) field write error: ", p), err) }
+    }
+    if err := oprot.WriteListEnd(); err != nil {
+      return thrift.PrependError("error writing list end: ", err)
+    }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 7:meta_codecs: ", p), err) }
+  }
+  return err
+}
+
+func (p *UpgradeArgs_) writeField8(oprot thrift.TProtocol) (err error) {
+  if p.IsSetMeta() {
+    if err := oprot.WriteFieldBegin("meta", thrift.MAP, 8); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field begin error 8:meta: ", p), err) }
+    if err := oprot.WriteMapBegin(thrift.STRING, thrift.STRING, len(p.Meta)); err != nil {
+      return thrift.PrependError("error writing map begin: ", err)
+    }
+    for k, v := range p.Meta {
+      if err := oprot.WriteString(string(k)); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T. (0) field write error: ", p), err) }
+      if err := oprot.WriteString(string(v)); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T. (0) field write error: ", p), err) }
+    }
+    if err := oprot.WriteMapEnd(); err != nil {
+      return thrift.PrependError("error writing map end: ", err)
+    }
+    if err := oprot.WriteFieldEnd(); err != nil {
+      return thrift.PrependError(fmt.Sprintf("%T write field end error 8:meta: ", p), err) }
+  }
+  return err
+}
+
 func (p *UpgradeArgs_) String() string {
   if p == nil {
     return "<nil>"
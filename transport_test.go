@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// messageTransport mimics a WebSocket connection: every Flush sends what has
+// been written as one message, Read drains the current message before moving
+// on to the next one, and never reads across two messages at once.
+type messageTransport struct {
+	in      <-chan []byte
+	out     chan<- []byte
+	current []byte
+	pending []byte
+	sent    int
+}
+
+func newMessageTransportPair() (a, b *messageTransport) {
+	ab, ba := make(chan []byte, 16), make(chan []byte, 16)
+	return &messageTransport{in: ba, out: ab}, &messageTransport{in: ab, out: ba}
+}
+
+func (m *messageTransport) Open() error  { return nil }
+func (m *messageTransport) IsOpen() bool { return true }
+func (m *messageTransport) Close() error { return nil }
+
+func (m *messageTransport) Read(p []byte) (int, error) {
+	if len(m.current) == 0 {
+		msg, ok := <-m.in
+		if !ok {
+			return 0, io.EOF
+		}
+		m.current = msg
+	}
+	n := copy(p, m.current)
+	m.current = m.current[n:]
+	return n, nil
+}
+
+func (m *messageTransport) Write(p []byte) (int, error) {
+	m.pending = append(m.pending, p...)
+	return len(p), nil
+}
+
+func (m *messageTransport) Flush() error {
+	if len(m.pending) > 0 {
+		m.out <- m.pending
+		m.pending = nil
+		m.sent++
+	}
+	return nil
+}
+
+func (m *messageTransport) RemainingBytes() uint64 {
+	return uint64(len(m.current))
+}
+
+func TestHandshakeOverMessageTransport(t *testing.T) {
+	ctrans, strans := newMessageTransportPair()
+	cprot := thrift.NewTBinaryProtocolTransport(ctrans)
+	sprot := thrift.NewTBinaryProtocolTransport(strans)
+	client, server := NewSimpleTracker("client"), NewSimpleTracker("server")
+
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+	if err := client.Negotiation(1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if ctrans.sent != 1 || strans.sent != 1 {
+		t.Fatalf("expect one message per side, got %d and %d", ctrans.sent, strans.sent)
+	}
+
+	// The request header goes in the same message as the call.
+	ctx := context.WithValue(context.Background(), CtxKeyRequestMeta, map[string]string{"k": "v"})
+	if err := client.TryWriteRequestHeader(ctx, cprot); err != nil {
+		t.Fatal(err)
+	}
+	cprot.WriteMessageBegin("Ping", thrift.CALL, 2)
+	cprot.WriteStructBegin("Ping_args")
+	cprot.WriteFieldStop()
+	cprot.WriteStructEnd()
+	cprot.WriteMessageEnd()
+	cprot.Flush()
+	if ctrans.sent != 2 {
+		t.Fatalf("expect the header and the call in one message, got %d messages", ctrans.sent)
+	}
+
+	sctx, err := server.TryReadRequestHeader(sprot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metaFromContext(sctx)["k"] != "v" {
+		t.Fatalf("expect the meta to be read, got %v", metaFromContext(sctx))
+	}
+	if name, _, seqID, err := sprot.ReadMessageBegin(); err != nil || name != "Ping" || seqID != 2 {
+		t.Fatalf("expect the call after the header, got %q %d %v", name, seqID, err)
+	}
+}
+
+func TestHandshakeOverFramedTransport(t *testing.T) {
+	craw, sraw := newProtocolPair(t)
+	cprot := thrift.NewTBinaryProtocolTransport(thrift.NewTFramedTransport(craw.Transport()))
+	sprot := thrift.NewTBinaryProtocolTransport(thrift.NewTFramedTransport(sraw.Transport()))
+	client, server := NewSimpleTracker("client"), NewSimpleTracker("server")
+
+	done := make(chan error, 1)
+	go func() { done <- serveUpgrade(server, sprot) }()
+	if err := client.Negotiation(1, cprot, cprot); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !client.RequestHeaderSupported() || !server.RequestHeaderSupported() {
+		t.Fatal("expect both sides to be upgraded")
+	}
+}
@@ -0,0 +1,82 @@
+package tracker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/damnever/thrift-tracker/tracking"
+)
+
+// HeaderWriteAbortedError is the error of a request header write given up on
+// as its context got done, Err is the error of the context. The connection is
+// poisoned if the header was partly written, see Poisoned.
+type HeaderWriteAbortedError struct {
+	Err error
+}
+
+func (e *HeaderWriteAbortedError) Error() string {
+	return "tracker: request header write aborted: " + e.Err.Error()
+}
+
+func (e *HeaderWriteAbortedError) Unwrap() error {
+	return e.Err
+}
+
+// writeRequestHeaderContext writes header, giving up once ctx is done if the
+// transport of oprot is a net.Conn, a TSocket or has a SetDeadline: the
+// deadline of the transport is moved to now to interrupt the write, blocked
+// on a peer not reading anymore for one, and cleared if the write went
+// through all the same. The other transports, the buffered and framed ones,
+// do not block on writes, header is written as usual.
+func (t *SimpleTracker) writeRequestHeaderContext(ctx context.Context, header *tracking.RequestHeader, oprot thrift.TProtocol) error {
+	if ctx.Done() == nil {
+		return t.writeRequestHeader(header, oprot)
+	}
+	deadliners := transportDeadliners(oprot)
+	if len(deadliners) == 0 {
+		return t.writeRequestHeader(header, oprot)
+	}
+	if err := ctx.Err(); err != nil { // nothing written, the stream is fine
+		return &HeaderWriteAbortedError{Err: err}
+	}
+
+	stop := make(chan struct{})
+	var (
+		wg    sync.WaitGroup
+		fired bool // the deadline got moved, read once wg is done
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+		case <-stop:
+			return
+		}
+		fired = true
+		for _, d := range deadliners {
+			d.SetDeadline(time.Now())
+		}
+	}()
+	err := t.writeRequestHeader(header, oprot)
+	close(stop)
+	wg.Wait()
+
+	if fired && err == nil {
+		// ctx got done as the write went through: the stream is fine, but
+		// the moved deadline would fail the call right after the header.
+		for _, d := range deadliners {
+			d.SetDeadline(time.Time{})
+		}
+		return nil
+	}
+	if fired {
+		t.mu.Lock()
+		t.poisoned = true
+		t.mu.Unlock()
+		return &HeaderWriteAbortedError{Err: ctx.Err()}
+	}
+	return err
+}
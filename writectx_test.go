@@ -0,0 +1,116 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+// stalledProtocol returns a protocol over a connection whose peer never
+// reads, every write blocks.
+func stalledProtocol(t *testing.T) thrift.TProtocol {
+	c, s := net.Pipe()
+	t.Cleanup(func() { c.Close(); s.Close() })
+	return thrift.NewTBinaryProtocolTransport(thrift.NewTSocketFromConnTimeout(c, 0))
+}
+
+func TestWriteRequestHeaderCanceled(t *testing.T) {
+	client, _ := upgradedPair(t, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() { done <- client.TryWriteRequestHeader(ctx, stalledProtocol(t)) }()
+
+	select {
+	case err := <-done:
+		var aerr *HeaderWriteAbortedError
+		if !errors.As(err, &aerr) || !errors.Is(err, context.Canceled) {
+			t.Fatalf("expect a HeaderWriteAbortedError, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the write to give up once canceled")
+	}
+	if !client.(*SimpleTracker).Poisoned() {
+		t.Fatal("expect the connection poisoned by the partial write")
+	}
+}
+
+func TestWriteRequestHeaderDone(t *testing.T) {
+	client, _ := upgradedPair(t, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := client.TryWriteRequestHeader(ctx, stalledProtocol(t))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expect the write not even tried, got %v", err)
+	}
+	if client.(*SimpleTracker).Poisoned() {
+		t.Fatal("expect the connection intact, nothing was written")
+	}
+	// A buffer never blocks, the header is written as usual.
+	if err := client.TryWriteRequestHeader(ctx, newMemoryProtocol()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// racingConn is a connection whose first write gets its context done, and
+// goes through once its deadline is moved: a peer catching up just as the
+// context gets done.
+type racingConn struct {
+	buf       *thrift.TMemoryBuffer
+	cancel    func()
+	moved     chan struct{}
+	mu        sync.Mutex
+	deadlines []time.Time
+}
+
+func (c *racingConn) Open() error                { return nil }
+func (c *racingConn) IsOpen() bool               { return true }
+func (c *racingConn) Close() error               { return nil }
+func (c *racingConn) Flush() error               { return nil }
+func (c *racingConn) RemainingBytes() uint64     { return c.buf.RemainingBytes() }
+func (c *racingConn) Read(p []byte) (int, error) { return c.buf.Read(p) }
+func (c *racingConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadlines = append(c.deadlines, t)
+	c.mu.Unlock()
+	if !t.IsZero() {
+		close(c.moved)
+	}
+	return nil
+}
+
+func (c *racingConn) Write(p []byte) (int, error) {
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+		select {
+		case <-c.moved:
+		case <-time.After(time.Second):
+		}
+	}
+	return c.buf.Write(p)
+}
+
+func TestWriteRequestHeaderDoneAsWritten(t *testing.T) {
+	client, server := upgradedPair(t, nil, nil)
+	ctx, cancel := context.WithCancel(WithRequestID(context.Background(), "req"))
+	conn := &racingConn{buf: thrift.NewTMemoryBuffer(), cancel: cancel, moved: make(chan struct{})}
+	if err := client.TryWriteRequestHeader(ctx, thrift.NewTBinaryProtocolTransport(conn)); err != nil {
+		t.Fatalf("expect the header written, got %v", err)
+	}
+	if client.(*SimpleTracker).Poisoned() {
+		t.Fatal("expect the connection intact, the header went through")
+	}
+	if n := len(conn.deadlines); n != 2 || !conn.deadlines[n-1].IsZero() {
+		t.Fatalf("expect the deadline moved then cleared, got %v", conn.deadlines)
+	}
+	got, err := server.TryReadRequestHeader(thrift.NewTBinaryProtocolTransport(conn.buf))
+	if err != nil || got.Value(CtxKeyRequestID) != "req" {
+		t.Fatalf("expect the whole header, got %v", err)
+	}
+}